@@ -0,0 +1,308 @@
+// cmd/rcptctl/main.go
+
+// rcptctl is the command line companion to the receipt processor server.
+// It talks to the same database, configured through the same .env file.
+//
+// Usage:
+//
+//	rcptctl export-csv [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-since RFC3339] [-after POSITION] [-gzip] [-o file]
+//	rcptctl purge
+//	rcptctl points-report [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-period day|week|month] [-bucket points] [-json]
+//	rcptctl backfill-points [-recalculate]
+//	rcptctl relink-retailers
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	// Load environment variables from .env file, if there is one
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	var err error
+	switch command {
+	case "export-csv":
+		err = exportCSV(args)
+	case "purge":
+		err = purge(args)
+	case "points-report":
+		err = pointsReport(args)
+	case "backfill-points":
+		err = backfillPoints(args)
+	case "relink-retailers":
+		err = relinkRetailers(args)
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: rcptctl <command> [flags]
+
+Commands:
+  export-csv       Export receipts as a flat CSV (one row per item)
+  purge            Hard-delete receipts past the retention window now
+  points-report    Report the points awarded per rule, retailer and period
+  backfill-points  Fill in the points breakdown of receipts stored without one
+  relink-retailers Link stored receipts to the retailers they match after retailer changes
+
+Run "rcptctl <command> -h" for the flags of a command.`)
+}
+
+func exportCSV(args []string) error {
+	flags := flag.NewFlagSet("export-csv", flag.ExitOnError)
+	from := flags.String("from", "", "earliest purchase date (YYYY-MM-DD, inclusive)")
+	to := flags.String("to", "", "latest purchase date (YYYY-MM-DD, inclusive)")
+	since := flags.String("since", "", "only receipts created after this RFC3339 timestamp")
+	after := flags.String("after", "", "the export_position of the last receipt exported, to resume after it")
+	useGzip := flags.Bool("gzip", false, "gzip the output")
+	output := flags.String("o", "-", "output file, - for stdout")
+	flags.Parse(args)
+
+	for name, date := range map[string]string{"from": *from, "to": *to} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return fmt.Errorf("invalid -%s %q: expected YYYY-MM-DD", name, date)
+		}
+	}
+
+	filter := model.ExportFilter{FromDate: *from, ToDate: *to}
+	if *since != "" {
+		sinceTime, err := time.Parse(time.RFC3339Nano, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since %q: %v", *since, err)
+		}
+		filter.Since = sinceTime
+	}
+	if *after != "" {
+		position, err := model.ParseExportPosition(*after)
+		if err != nil {
+			return fmt.Errorf("invalid -after %q: %v", *after, err)
+		}
+		filter.After = position
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var gzipWriter *gzip.Writer
+	if *useGzip {
+		gzipWriter = gzip.NewWriter(out)
+		out = gzipWriter
+	}
+
+	config.Init()
+	defer config.Log.Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := model.WriteReceiptsCSV(ctx, config.DB, filter, out, nil); err != nil {
+		config.Log.Error("CSV export failed", zap.Error(err))
+		return err
+	}
+
+	if gzipWriter != nil {
+		return gzipWriter.Close()
+	}
+	return nil
+}
+
+func purge(args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	flags.Parse(args)
+
+	config.Init()
+	defer config.Log.Sync()
+
+	purged, err := model.PurgeReceipts(config.DB, config.Retention)
+	if err != nil {
+		config.Log.Error("Purge failed", zap.Error(err))
+		return err
+	}
+
+	fmt.Printf("purged %d receipts\n", purged)
+	return nil
+}
+
+func pointsReport(args []string) error {
+	flags := flag.NewFlagSet("points-report", flag.ExitOnError)
+	from := flags.String("from", "", "earliest purchase date (YYYY-MM-DD, inclusive)")
+	to := flags.String("to", "", "latest purchase date (YYYY-MM-DD, inclusive)")
+	period := flags.String("period", model.DefaultPointsPeriod, "group periods by day, week or month")
+	bucket := flags.Int("bucket", model.DefaultPointsBucketWidth, "histogram bucket width in points")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	for name, date := range map[string]string{"from": *from, "to": *to} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return fmt.Errorf("invalid -%s %q: expected YYYY-MM-DD", name, date)
+		}
+	}
+	if !model.ValidPointsPeriod(*period) {
+		return fmt.Errorf("invalid -period %q: expected one of %s", *period, strings.Join(model.PointsPeriods, ", "))
+	}
+	if *bucket < 1 {
+		return fmt.Errorf("invalid -bucket %d: expected at least 1", *bucket)
+	}
+
+	config.Init()
+	defer config.Log.Sync()
+
+	report, err := model.GetPointsReport(config.DB, model.PointsFilter{
+		FromDate:    *from,
+		ToDate:      *to,
+		Period:      *period,
+		BucketWidth: *bucket,
+	})
+	if err != nil {
+		config.Log.Error("Points report failed", zap.Error(err))
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return writePointsReport(os.Stdout, report)
+}
+
+func backfillPoints(args []string) error {
+	flags := flag.NewFlagSet("backfill-points", flag.ExitOnError)
+	recalculate := flags.Bool("recalculate", false, "recalculate the points of every receipt with the current rules")
+	flags.Parse(args)
+
+	config.Init()
+	defer config.Log.Sync()
+
+	backfill, err := model.BackfillPoints(config.DB, config.Points, *recalculate)
+	if err != nil {
+		config.Log.Error("Points backfill failed", zap.Error(err))
+		return err
+	}
+
+	fmt.Printf("filled %d breakdowns, recalculated %d receipts\n", backfill.Filled, backfill.Recalculated)
+	if backfill.Mismatched > 0 {
+		fmt.Printf("%d receipts were awarded points the current rules do not add up to; rerun with -recalculate to replace them\n",
+			backfill.Mismatched)
+	}
+	return nil
+}
+
+func relinkRetailers(args []string) error {
+	flags := flag.NewFlagSet("relink-retailers", flag.ExitOnError)
+	flags.Parse(args)
+
+	config.Init()
+	defer config.Log.Sync()
+
+	relink, err := model.RelinkReceipts(config.DB, config.Points)
+	if err != nil {
+		config.Log.Error("Retailer relink failed", zap.Error(err))
+		return err
+	}
+
+	fmt.Printf("relinked %d receipts, recalculated the points of %d\n", relink.Relinked, relink.Recalculated)
+	if relink.Kept > 0 {
+		fmt.Printf("%d receipts with a store kept their retailer, since their name matches no retailer now\n", relink.Kept)
+	}
+	return nil
+}
+
+// writePointsReport prints a points report as plain text tables.
+func writePointsReport(out io.Writer, report *model.PointsReport) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintf(w, "Receipts\t%d\t\n", report.Receipts)
+	fmt.Fprintf(w, "Points\t%d\t\n", report.Points)
+	fmt.Fprintf(w, "Average\t%.2f\t\n", report.Average)
+
+	fmt.Fprintln(w, "\nRule\tPoints\tReceipts\tShare\t")
+	for _, rule := range report.Rules {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\t\n", rule.Rule, rule.Points, rule.Receipts, rule.Share*100)
+	}
+
+	for _, section := range []struct {
+		title  string
+		groups []model.PointsGroup
+	}{
+		{"Retailer", report.Retailers},
+		{"Period (" + report.Period + ")", report.Periods},
+	} {
+		fmt.Fprintf(w, "\n%s\tReceipts\tPoints\tAverage\tTop rule\t\n", section.title)
+		for _, group := range section.groups {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%s\t\n",
+				group.Key, group.Receipts, group.Points, group.Average, topRule(group.Rules))
+		}
+	}
+
+	fmt.Fprintln(w, "\nPoints\tReceipts\t")
+	for _, bucket := range report.Histogram {
+		fmt.Fprintf(w, "%d-%d\t%d\t\n", bucket.Min, bucket.Max, bucket.Receipts)
+	}
+
+	return w.Flush()
+}
+
+// topRule names the rule awarding the most points, the first by name on a
+// tie, or "-" when none awarded any.
+func topRule(rules map[string]int) string {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	top := "-"
+	most := 0
+	for _, name := range names {
+		if rules[name] > most {
+			top, most = name, rules[name]
+		}
+	}
+	return top
+}
@@ -0,0 +1,51 @@
+// config/catalog.go
+
+package config
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// SKU conflict policies: what to do when a receipt defines a stored SKU
+// (same prefix and unique identifier) with a different category,
+// manufacturer, product line or attributes.
+const (
+	SKUConflictReject        = "reject"          // reject the receipt
+	SKUConflictKeepFirst     = "keep_first"      // keep the stored definition
+	SKUConflictLastWriteWins = "last_write_wins" // overwrite the stored definition
+	SKUConflictVersion       = "version"         // keep the stored definition as a past version, then overwrite it
+)
+
+// CatalogRules configures the SKU catalog through CATALOG_* variables.
+type CatalogRules struct {
+	SKUConflictPolicy string
+}
+
+var Catalog = DefaultCatalogRules()
+
+// DefaultCatalogRules returns the rules used when no CATALOG_* variables are set.
+func DefaultCatalogRules() CatalogRules {
+	return CatalogRules{
+		SKUConflictPolicy: SKUConflictKeepFirst,
+	}
+}
+
+// initCatalogRules overrides the defaults with CATALOG_* environment variables.
+func initCatalogRules() {
+	Catalog = DefaultCatalogRules()
+
+	if value := os.Getenv("CATALOG_SKU_CONFLICT_POLICY"); value != "" {
+		switch value {
+		case SKUConflictReject, SKUConflictKeepFirst, SKUConflictLastWriteWins, SKUConflictVersion:
+			Catalog.SKUConflictPolicy = value
+		default:
+			Log.Error("Invalid SKU conflict policy, keeping the default",
+				zap.String("value", value), zap.String("default", Catalog.SKUConflictPolicy))
+		}
+	}
+
+	Log.Info("Catalog rules loaded",
+		zap.String("skuConflictPolicy", Catalog.SKUConflictPolicy))
+}
@@ -22,6 +22,10 @@ var (
 
 func Init() {
 	initLogger()
+	initFraudRules()
+	initRetentionRules()
+	initCatalogRules()
+	initPointsRules()
 	initDB()
 	runMigrations() // Run database migrations using Goose
 }
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Environment helpers: leave *target untouched when the variable is unset,
+// and log (but otherwise ignore) values that do not parse.
+func envBool(name string, target *bool) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			*target = parsed
+		} else {
+			Log.Error("Invalid boolean environment variable", zap.String("name", name), zap.Error(err))
+		}
+	}
+}
+
+func envInt(name string, target *int) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			*target = parsed
+		} else {
+			Log.Error("Invalid integer environment variable", zap.String("name", name), zap.Error(err))
+		}
+	}
+}
+
+func envFloat(name string, target *float64) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			*target = parsed
+		} else {
+			Log.Error("Invalid number environment variable", zap.String("name", name), zap.Error(err))
+		}
+	}
+}
+
+func envDuration(name string, target *time.Duration) {
+	if value := os.Getenv(name); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			*target = parsed
+		} else {
+			Log.Error("Invalid duration environment variable", zap.String("name", name), zap.Error(err))
+		}
+	}
+}
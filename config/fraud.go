@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FraudRules configures the fraud scoring stage of receipt processing.
+// Every heuristic adds its weight to the receipt's score when it fires;
+// a weight of 0 turns the heuristic off. Receipts scoring at or above
+// FlagThreshold are flagged and, with HoldFlagged, held for manual review.
+type FraudRules struct {
+	Enabled       bool
+	FlagThreshold float64
+	// HoldFlagged keeps flagged receipts pending until a reviewer decides
+	// them; without it they are approved but keep their fraud assessment
+	HoldFlagged bool
+
+	// Velocity: more than VelocityMaxReceipts from one member within VelocityWindow
+	VelocityWindow      time.Duration
+	VelocityMaxReceipts int
+	VelocityWeight      float64
+
+	// Points awarded per dollar spent above MaxPointsPerDollar
+	MaxPointsPerDollar float64
+	PointsRatioWeight  float64
+
+	// Total more than OutlierZScore standard deviations away from the
+	// retailer's mean, once the retailer has OutlierMinSamples receipts
+	OutlierZScore     float64
+	OutlierMinSamples int
+	OutlierWeight     float64
+
+	// Per suspicious description/total/time pattern found
+	PatternWeight float64
+}
+
+var Fraud = DefaultFraudRules()
+
+// DefaultFraudRules returns the rules used when no FRAUD_* variables are set.
+func DefaultFraudRules() FraudRules {
+	return FraudRules{
+		Enabled:       true,
+		FlagThreshold: 1.0,
+		HoldFlagged:   true,
+
+		VelocityWindow:      time.Hour,
+		VelocityMaxReceipts: 5,
+		VelocityWeight:      1.0,
+
+		MaxPointsPerDollar: 30,
+		PointsRatioWeight:  1.0,
+
+		OutlierZScore:     3,
+		OutlierMinSamples: 30,
+		OutlierWeight:     0.75,
+
+		PatternWeight: 0.5,
+	}
+}
+
+// initFraudRules overrides the defaults with FRAUD_* environment variables.
+func initFraudRules() {
+	Fraud = DefaultFraudRules()
+
+	envBool("FRAUD_SCORING_ENABLED", &Fraud.Enabled)
+	envFloat("FRAUD_FLAG_THRESHOLD", &Fraud.FlagThreshold)
+	envDuration("FRAUD_VELOCITY_WINDOW", &Fraud.VelocityWindow)
+	envInt("FRAUD_VELOCITY_MAX_RECEIPTS", &Fraud.VelocityMaxReceipts)
+	envFloat("FRAUD_VELOCITY_WEIGHT", &Fraud.VelocityWeight)
+	envFloat("FRAUD_MAX_POINTS_PER_DOLLAR", &Fraud.MaxPointsPerDollar)
+	envFloat("FRAUD_POINTS_RATIO_WEIGHT", &Fraud.PointsRatioWeight)
+	envFloat("FRAUD_OUTLIER_ZSCORE", &Fraud.OutlierZScore)
+	envInt("FRAUD_OUTLIER_MIN_SAMPLES", &Fraud.OutlierMinSamples)
+	envFloat("FRAUD_OUTLIER_WEIGHT", &Fraud.OutlierWeight)
+	envFloat("FRAUD_PATTERN_WEIGHT", &Fraud.PatternWeight)
+
+	// FRAUD_WITHHOLD_POINTS, from before the review queue, held back the
+	// points of flagged receipts the way holding them for review now does
+	if _, ok := os.LookupEnv("FRAUD_WITHHOLD_POINTS"); ok {
+		Log.Warn("FRAUD_WITHHOLD_POINTS is deprecated, use FRAUD_HOLD_FLAGGED")
+		envBool("FRAUD_WITHHOLD_POINTS", &Fraud.HoldFlagged)
+	}
+	envBool("FRAUD_HOLD_FLAGGED", &Fraud.HoldFlagged)
+
+	Log.Info("Fraud rules loaded",
+		zap.Bool("enabled", Fraud.Enabled),
+		zap.Float64("flagThreshold", Fraud.FlagThreshold),
+		zap.Bool("holdFlagged", Fraud.HoldFlagged))
+}
@@ -0,0 +1,49 @@
+// config/points.go
+
+package config
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// Retailer names the retailer rule of the points calculation can count.
+const (
+	PointsRetailerRaw       = "raw"       // the retailer as printed on the receipt
+	PointsRetailerCanonical = "canonical" // the canonical retailer, when the receipt matched one
+)
+
+// PointsRules configures the points calculation through POINTS_* variables.
+type PointsRules struct {
+	// Which retailer name earns a point per letter or digit: counting the
+	// canonical name keeps store numbers ("TARGET #1234") from scoring
+	RetailerName string
+}
+
+var Points = DefaultPointsRules()
+
+// DefaultPointsRules returns the rules used when no POINTS_* variables are set.
+func DefaultPointsRules() PointsRules {
+	return PointsRules{
+		RetailerName: PointsRetailerRaw,
+	}
+}
+
+// initPointsRules overrides the defaults with POINTS_* environment variables.
+func initPointsRules() {
+	Points = DefaultPointsRules()
+
+	if value := os.Getenv("POINTS_RETAILER_NAME"); value != "" {
+		switch value {
+		case PointsRetailerRaw, PointsRetailerCanonical:
+			Points.RetailerName = value
+		default:
+			Log.Error("Invalid points retailer name, keeping the default",
+				zap.String("value", value), zap.String("default", Points.RetailerName))
+		}
+	}
+
+	Log.Info("Points rules loaded",
+		zap.String("retailerName", Points.RetailerName))
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RetentionRules configures soft deletion and the purge job. Each
+// environment sets its own through RETENTION_* variables.
+type RetentionRules struct {
+	// How long a deleted receipt can still be restored before it is purged
+	RestoreWindow time.Duration
+
+	// Receipts created longer ago than this are purged even if they were
+	// never deleted; 0 keeps receipts forever
+	MaxAge time.Duration
+
+	PurgeInterval  time.Duration // how often the purge job runs; 0 disables it
+	PurgeBatchSize int           // receipts hard-deleted per transaction
+}
+
+var Retention = DefaultRetentionRules()
+
+// DefaultRetentionRules returns the rules used when no RETENTION_* variables are set.
+func DefaultRetentionRules() RetentionRules {
+	return RetentionRules{
+		RestoreWindow:  30 * 24 * time.Hour,
+		MaxAge:         0,
+		PurgeInterval:  time.Hour,
+		PurgeBatchSize: 500,
+	}
+}
+
+// initRetentionRules overrides the defaults with RETENTION_* environment variables.
+func initRetentionRules() {
+	Retention = DefaultRetentionRules()
+
+	envDuration("RETENTION_RESTORE_WINDOW", &Retention.RestoreWindow)
+	envDuration("RETENTION_MAX_AGE", &Retention.MaxAge)
+	envDuration("RETENTION_PURGE_INTERVAL", &Retention.PurgeInterval)
+	envInt("RETENTION_PURGE_BATCH_SIZE", &Retention.PurgeBatchSize)
+
+	if Retention.PurgeBatchSize <= 0 {
+		Retention.PurgeBatchSize = DefaultRetentionRules().PurgeBatchSize
+	}
+
+	Log.Info("Retention rules loaded",
+		zap.Duration("restoreWindow", Retention.RestoreWindow),
+		zap.Duration("maxAge", Retention.MaxAge),
+		zap.Duration("purgeInterval", Retention.PurgeInterval))
+}
@@ -0,0 +1,151 @@
+// controller/analyticsController.go
+
+package controller
+
+import (
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+)
+
+// GetSpend godoc
+// @Summary Report spending
+// @Description Totals what was spent on live receipts, rejected ones left out, grouped by canonical retailer, item category, member or purchase day, week or month. Category totals roll up into their parent categories.
+// @Tags analytics
+// @Produce json
+// @Param groupBy query string false "retailer, category, member, day, week or month (default)"
+// @Param from query string false "Earliest purchase date (YYYY-MM-DD, inclusive)"
+// @Param to query string false "Latest purchase date (YYYY-MM-DD, inclusive)"
+// @Param memberId query string false "Only receipts of this member"
+// @Success 200 {object} model.SpendReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /analytics/spend [get]
+func GetSpend(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseSpendFilter(r)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := model.GetSpend(config.DB, filter)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to report spending"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, report)
+}
+
+// GetPointsReport godoc
+// @Summary Report points by rule
+// @Description Reports the points awarded to approved live receipts: in total and on average, per rule, per canonical retailer and per purchase day, week or month, with a histogram of receipts by points. Receipts stored before per-rule breakdowns were kept count their points under the unattributed rule.
+// @Tags analytics
+// @Produce json
+// @Param from query string false "Earliest purchase date (YYYY-MM-DD, inclusive)"
+// @Param to query string false "Latest purchase date (YYYY-MM-DD, inclusive)"
+// @Param period query string false "day, week or month (default)"
+// @Param bucket query int false "Histogram bucket width in points (default 25)"
+// @Success 200 {object} model.PointsReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /analytics/points [get]
+func GetPointsReport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parsePointsFilter(r)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := model.GetPointsReport(config.DB, filter)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to report points"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, report)
+}
+
+/*
+	Helper Functions
+*/
+func parseSpendFilter(r *http.Request) (model.SpendFilter, error) {
+	query := r.URL.Query()
+	filter := model.SpendFilter{
+		GroupBy:  model.DefaultSpendGrouping,
+		MemberID: query.Get("memberId"),
+	}
+
+	if groupBy := query.Get("groupBy"); groupBy != "" {
+		if !model.ValidSpendGrouping(groupBy) {
+			return filter, errInvalidQueryParam("groupBy", groupBy)
+		}
+		filter.GroupBy = groupBy
+	}
+
+	from, to, err := parseDateRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		return filter, err
+	}
+	filter.FromDate, filter.ToDate = from, to
+
+	return filter, nil
+}
+
+func parsePointsFilter(r *http.Request) (model.PointsFilter, error) {
+	query := r.URL.Query()
+	filter := model.PointsFilter{
+		Period:      model.DefaultPointsPeriod,
+		BucketWidth: model.DefaultPointsBucketWidth,
+	}
+
+	if period := query.Get("period"); period != "" {
+		if !model.ValidPointsPeriod(period) {
+			return filter, errInvalidQueryParam("period", period)
+		}
+		filter.Period = period
+	}
+
+	if bucket := query.Get("bucket"); bucket != "" {
+		width, err := strconv.Atoi(bucket)
+		if err != nil || width < 1 {
+			return filter, errInvalidQueryParam("bucket", bucket)
+		}
+		filter.BucketWidth = width
+	}
+
+	from, to, err := parseDateRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		return filter, err
+	}
+	filter.FromDate, filter.ToDate = from, to
+
+	return filter, nil
+}
+
+// parseDateRange reads the from and to query parameters, purchase dates
+// in any format parseAndFormatDate understands, as YYYY-MM-DD.
+func parseDateRange(from, to string) (string, string, error) {
+	if from != "" {
+		formattedDate, err := parseAndFormatDate(from)
+		if err != nil {
+			return "", "", errInvalidQueryParam("from", from)
+		}
+		from = formattedDate
+	}
+
+	if to != "" {
+		formattedDate, err := parseAndFormatDate(to)
+		if err != nil {
+			return "", "", errInvalidQueryParam("to", to)
+		}
+		to = formattedDate
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,68 @@
+// controller/auditController.go
+
+package controller
+
+import (
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/middleware"
+	"rcpt-proc-challenge-ans/model"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ActorHeader names who is making a request, for the audit log.
+const ActorHeader = "X-Actor"
+
+// anonymousActor is audited when a request does not name its actor.
+const anonymousActor = "anonymous"
+
+// GetReceiptHistory godoc
+// @Summary Get the audit history of a receipt
+// @Description Lists every recorded change to a receipt (create, update, delete, restore, review, points recalculation, purge) with actor, time, request ID and a diff of the changed fields. Available for deleted and purged receipts too.
+// @Tags receipts
+// @Produce json
+// @Param id path string true "Receipt ID"
+// @Success 200 {array} model.AuditEntry
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /receipts/{id}/history [get]
+func GetReceiptHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	receiptID, err := uuid.Parse(id)
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return
+	}
+
+	history, err := model.GetReceiptHistory(config.DB, receiptID)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve receipt history"})
+		return
+	}
+	if len(history) == 0 {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Receipt not found"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, history)
+}
+
+// requestAudit returns who is making the request and its request ID.
+func requestAudit(r *http.Request) model.AuditInfo {
+	actor := r.Header.Get(ActorHeader)
+	if actor == "" || len(actor) > 255 {
+		actor = anonymousActor
+	}
+
+	return model.AuditInfo{
+		Actor:     actor,
+		RequestID: middleware.RequestIDFromContext(r.Context()),
+	}
+}
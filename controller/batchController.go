@@ -0,0 +1,215 @@
+// controller/batchController.go
+
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// MaxBatchSize is the most receipts accepted in a single batch submission.
+const MaxBatchSize = 10000
+
+// MaxBatchBodyBytes is the largest batch submission body accepted.
+const MaxBatchBodyBytes = 32 << 20
+
+var errBatchTooLarge = fmt.Errorf("batch exceeds the maximum of %d receipts", MaxBatchSize)
+
+// batchEntry is one decoded receipt of a batch submission; err is set when
+// that receipt could not be decoded, without failing the rest of the batch.
+// raw keeps the submitted JSON so asynchronous jobs can persist it.
+type batchEntry struct {
+	raw     json.RawMessage
+	receipt model.Receipt
+	err     error
+}
+
+// ProcessReceiptBatch godoc
+// @Summary Process a batch of receipts
+// @Description Accepts a JSON array of receipts, or newline-delimited JSON (one receipt per line).
+// @Description Each receipt is validated and scored independently; the response lists, in submission order, the ID or the error of every receipt.
+// @Description With async=true the batch is queued instead: the response is 202 with a job to poll at /jobs/{id}.
+// @Tags receipts
+// @Accept json
+// @Accept application/x-ndjson
+// @Produce json
+// @Param receipts body []model.Receipt true "Receipts"
+// @Param async query bool false "Process the batch in the background"
+// @Success 200 {object} ProcessReceiptBatchResponse
+// @Success 202 {object} JobAcceptedResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse "More than MaxBatchSize receipts or MaxBatchBodyBytes bytes"
+// @Failure 500 {object} ErrorResponse
+// @Router /receipts/batch [post]
+func ProcessReceiptBatch(w http.ResponseWriter, r *http.Request) {
+	async := false
+	if value := r.URL.Query().Get("async"); value != "" {
+		var err error
+		if async, err = strconv.ParseBool(value); err != nil {
+			sendJSONResponse(w, http.StatusBadRequest,
+				ErrorResponse{Error: errInvalidQueryParam("async", value).Error()})
+			return
+		}
+	}
+
+	entries, err := decodeReceiptBatch(http.MaxBytesReader(w, r.Body, MaxBatchBodyBytes))
+	if err != nil {
+		config.Log.Error("Invalid batch input", zap.Error(err))
+		status := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.Is(err, errBatchTooLarge) || errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		sendJSONResponse(w, status,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if async {
+		enqueueReceiptBatch(w, entries, requestAudit(r))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, processReceiptBatch(entries, requestAudit(r)))
+}
+
+// processReceiptBatch prepares every decoded receipt independently, stores
+// the valid ones in bulk and reports a result per receipt.
+func processReceiptBatch(entries []batchEntry, audit model.AuditInfo) ProcessReceiptBatchResponse {
+	results := make([]BatchReceiptResult, len(entries))
+	var valid []*model.Receipt
+	var validIndexes []int
+
+	for i := range entries {
+		results[i].Index = i
+
+		if entries[i].err != nil {
+			results[i].Error = "Invalid input: " + entries[i].err.Error()
+			continue
+		}
+
+		if err := prepareReceipt(&entries[i].receipt); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		valid = append(valid, &entries[i].receipt)
+		validIndexes = append(validIndexes, i)
+	}
+
+	for i, err := range model.AddReceipts(config.DB, valid, audit) {
+		index := validIndexes[i]
+		var duplicate *model.DuplicateReceiptError
+		var skuConflict *model.SKUConflictError
+		if errors.As(err, &duplicate) {
+			results[index].Error = "Receipt has already been submitted"
+			results[index].ExistingID = duplicate.ExistingID.String()
+			continue
+		} else if errors.As(err, &skuConflict) {
+			results[index].Error = skuConflict.Error()
+			results[index].SKUConflicts = []model.SKUConflict{skuConflict.Conflict}
+			continue
+		} else if err != nil {
+			config.Log.Error("Failed to create receipt", zap.Int("index", index), zap.Error(err))
+			results[index].Error = "Failed to create receipt"
+			continue
+		}
+		results[index].ID = valid[i].ID.String()
+		results[index].SKUConflicts = valid[i].SKUConflicts
+	}
+
+	response := ProcessReceiptBatchResponse{Results: results}
+	for _, result := range results {
+		if result.Error == "" {
+			response.Accepted++
+		} else {
+			response.Rejected++
+		}
+	}
+
+	config.Log.Info("Processed receipt batch",
+		zap.Int("accepted", response.Accepted),
+		zap.Int("rejected", response.Rejected))
+
+	return response
+}
+
+/*
+	Helper Functions
+*/
+// decodeReceiptBatch reads either a JSON array of receipts or an NDJSON
+// stream, detected from the first non-whitespace byte. Malformed JSON aborts
+// the whole batch; a well-formed receipt that fails to unmarshal (e.g. a bad
+// SKU) only marks its own entry.
+func decodeReceiptBatch(body io.Reader) ([]batchEntry, error) {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil, errors.New("batch contains no receipts")
+	} else if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(reader)
+	isArray := first == '['
+	if isArray {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []batchEntry
+	for {
+		if isArray && !decoder.More() {
+			break
+		}
+
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF && !isArray {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("receipt %d: %w", len(entries), err)
+		}
+
+		if len(entries) == MaxBatchSize {
+			return nil, errBatchTooLarge
+		}
+
+		entry := batchEntry{raw: raw}
+		entry.err = json.Unmarshal(raw, &entry.receipt)
+		entries = append(entries, entry)
+	}
+
+	if isArray {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("batch contains no receipts")
+	}
+
+	return entries, nil
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
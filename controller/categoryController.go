@@ -0,0 +1,155 @@
+// controller/categoryController.go
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// GetCategories godoc
+// @Summary Get the category taxonomy
+// @Description Returns the category tree, each category with its path from the root and the keywords that classify item descriptions into it.
+// @Tags categories
+// @Produce json
+// @Success 200 {array} model.Category
+// @Failure 500 {object} ErrorResponse
+// @Router /categories [get]
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	taxonomy, err := model.GetTaxonomy(config.DB)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve categories"})
+		return
+	}
+
+	tree := taxonomy.Tree()
+	if tree == nil {
+		tree = []*model.Category{}
+	}
+	sendJSONResponse(w, http.StatusOK, tree)
+}
+
+// CreateCategory godoc
+// @Summary Add a category
+// @Description Adds a category to the taxonomy, at the root or under parentID. Its keywords classify the items of receipts submitted from then on.
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param category body CategoryRequest true "Name, optional parent and keywords"
+// @Success 201 {object} model.Category
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "A sibling has the same name"
+// @Failure 500 {object} ErrorResponse
+// @Router /categories [post]
+func CreateCategory(w http.ResponseWriter, r *http.Request) {
+	var request CategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		config.Log.Error("Invalid input", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	category := &model.Category{
+		ParentID: request.ParentID,
+		Name:     strings.TrimSpace(request.Name),
+		Keywords: request.Keywords,
+	}
+	if category.Name == "" {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "A category name is required"})
+		return
+	}
+
+	err := model.CreateCategory(config.DB, category)
+	switch {
+	case errors.Is(err, model.ErrUnknownCategory):
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Parent category not found"})
+		return
+	case errors.Is(err, model.ErrDuplicateCategory):
+		sendJSONResponse(w, http.StatusConflict,
+			ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to create category"})
+		return
+	}
+
+	// Respond with the category as placed in the tree
+	if taxonomy, err := model.GetTaxonomy(config.DB); err == nil {
+		if placed, ok := taxonomy.Category(category.ID); ok {
+			category = placed
+		}
+	}
+	sendJSONResponse(w, http.StatusCreated, category)
+}
+
+// GetCategoryMappings godoc
+// @Summary List the SKU category code mappings
+// @Description Returns the taxonomy category each SKU category code (e.g. SNCK) maps to.
+// @Tags categories
+// @Produce json
+// @Success 200 {object} map[string]int
+// @Failure 500 {object} ErrorResponse
+// @Router /categories/mappings [get]
+func GetCategoryMappings(w http.ResponseWriter, r *http.Request) {
+	mappings, err := model.GetCategoryMappings(config.DB)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve category mappings"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, mappings)
+}
+
+// SetCategoryMapping godoc
+// @Summary Map a SKU category code to a category
+// @Description Maps a SKU category code to a taxonomy category. Stored items of SKUs with that code move to the category.
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param code path string true "SKU category code"
+// @Param mapping body CategoryMappingRequest true "Category ID"
+// @Success 200 {object} CategoryMappingResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /categories/mappings/{code} [put]
+func SetCategoryMapping(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+
+	var request CategoryMappingRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		config.Log.Error("Invalid input", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	recategorized, err := model.SetCategoryMapping(config.DB, code, request.CategoryID)
+	if errors.Is(err, model.ErrUnknownCategory) {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Category not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to set category mapping"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, CategoryMappingResponse{
+		Code:          code,
+		CategoryID:    request.CategoryID,
+		Recategorized: recategorized,
+	})
+}
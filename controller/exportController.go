@@ -0,0 +1,179 @@
+// controller/exportController.go
+
+package controller
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ExportReceipts godoc
+// @Summary Export receipts as NDJSON or CSV
+// @Description Stream receipts with their items, in the order they were stored.
+// @Description format=ndjson (default) writes one receipt per line; use the position of the last line as the next "after" watermark for incremental pulls.
+// @Description format=csv writes one row per item with the receipt columns repeated and the SKU split into columns.
+// @Tags receipts
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param from query string false "Earliest purchase date (YYYY-MM-DD, inclusive)"
+// @Param to query string false "Latest purchase date (YYYY-MM-DD, inclusive)"
+// @Param since query string false "Only receipts created after this RFC3339 timestamp"
+// @Param after query string false "The position of the last receipt exported: only receipts stored after it"
+// @Param format query string false "ndjson (default) or csv"
+// @Param gzip query bool false "Gzip the export"
+// @Success 200 {object} model.ExportedReceipt
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /receipts/export [get]
+func ExportReceipts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		config.Log.Error("Invalid export filter", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: errInvalidQueryParam("format", format).Error()})
+		return
+	}
+
+	useGzip := false
+	if gz := r.URL.Query().Get("gzip"); gz != "" {
+		if useGzip, err = strconv.ParseBool(gz); err != nil {
+			sendJSONResponse(w, http.StatusBadRequest,
+				ErrorResponse{Error: errInvalidQueryParam("gzip", gz).Error()})
+			return
+		}
+	}
+
+	filename := "receipts." + format
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	// countingWriter lets us tell whether anything reached the client
+	// before a failure, in which case the status can no longer change.
+	out := &countingWriter{w: w}
+	var body io.Writer = out
+	var gzipWriter *gzip.Writer
+	if useGzip {
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+		gzipWriter = gzip.NewWriter(out)
+		body = gzipWriter
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	flusher, _ := w.(http.Flusher)
+	afterBatch := func() error {
+		if gzipWriter != nil {
+			if err := gzipWriter.Flush(); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	if format == "csv" {
+		err = model.WriteReceiptsCSV(r.Context(), config.DB, filter, body, afterBatch)
+	} else {
+		encoder := json.NewEncoder(body)
+		err = model.StreamReceipts(r.Context(), config.DB, filter,
+			func(receipt model.ExportedReceipt) error {
+				return encoder.Encode(receipt)
+			}, afterBatch)
+	}
+	if err == nil && gzipWriter != nil {
+		err = gzipWriter.Close()
+	}
+
+	if err != nil {
+		config.Log.Error("Failed to export receipts",
+			zap.String("format", format), zap.Int64("bytesWritten", out.n), zap.Error(err))
+		// Once bytes have gone out the status is already 200; the client
+		// sees a truncated stream and can resume from its last watermark.
+		if out.n == 0 {
+			w.Header().Del("Content-Disposition")
+			sendJSONResponse(w, http.StatusInternalServerError,
+				ErrorResponse{Error: "Failed to export receipts"})
+		}
+		return
+	}
+
+	if out.n == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+/*
+	Helper Functions
+*/
+func parseExportFilter(r *http.Request) (model.ExportFilter, error) {
+	var filter model.ExportFilter
+	query := r.URL.Query()
+
+	if from := query.Get("from"); from != "" {
+		formattedDate, err := parseAndFormatDate(from)
+		if err != nil {
+			return filter, errInvalidQueryParam("from", from)
+		}
+		filter.FromDate = formattedDate
+	}
+
+	if to := query.Get("to"); to != "" {
+		formattedDate, err := parseAndFormatDate(to)
+		if err != nil {
+			return filter, errInvalidQueryParam("to", to)
+		}
+		filter.ToDate = formattedDate
+	}
+
+	if since := query.Get("since"); since != "" {
+		sinceTime, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return filter, errInvalidQueryParam("since", since)
+		}
+		filter.Since = sinceTime
+	}
+
+	if after := query.Get("after"); after != "" {
+		position, err := model.ParseExportPosition(after)
+		if err != nil {
+			return filter, errInvalidQueryParam("after", after)
+		}
+		filter.After = position
+	}
+
+	return filter, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
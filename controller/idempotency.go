@@ -0,0 +1,101 @@
+// controller/idempotency.go
+
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader is the request header clients set to make retries safe.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength matches the idempotency_keys.key column.
+const maxIdempotencyKeyLength = 255
+
+// handleIdempotentRequest runs handle at most once per key. A retry with the
+// same key and body replays the stored response; reusing the key with a
+// different body is rejected with 422. Bodies are compared as JSON, so
+// whitespace and key order do not matter. Server errors are not stored, so
+// the client can retry them.
+func handleIdempotentRequest(w http.ResponseWriter, key string, body []byte,
+	handle func(body []byte) (int, interface{})) {
+	if len(key) > maxIdempotencyKeyLength {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Idempotency-Key must be at most 255 characters"})
+		return
+	}
+
+	requestHash := hashRequestBody(body)
+
+	lease, existing, err := model.ReserveIdempotencyKey(config.DB, key, requestHash)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to process Idempotency-Key"})
+		return
+	}
+
+	if lease == nil {
+		switch {
+		case existing.RequestHash != requestHash:
+			config.Log.Info("Idempotency-Key reused with a different body", zap.String("key", key))
+			sendJSONResponse(w, http.StatusUnprocessableEntity,
+				ErrorResponse{Error: "Idempotency-Key was already used with a different request body"})
+		case !existing.Completed:
+			sendJSONResponse(w, http.StatusConflict,
+				ErrorResponse{Error: "A request with this Idempotency-Key is still being processed"})
+		default:
+			config.Log.Info("Replaying idempotent response", zap.String("key", key))
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.ResponseStatus)
+			w.Write(existing.ResponseBody)
+		}
+		return
+	}
+
+	status, payload := handle(body)
+
+	// A key left unfinished is taken over by a retry once its lease
+	// expires; the model logs a lease lost to one
+	if status >= http.StatusInternalServerError {
+		if err := model.ReleaseIdempotencyKey(config.DB, lease); err != nil && !errors.Is(err, model.ErrIdempotencyLeaseLost) {
+			config.Log.Error("Failed to release Idempotency-Key", zap.String("key", key), zap.Error(err))
+		}
+	} else if encoded, err := json.Marshal(payload); err != nil {
+		config.Log.Error("Failed to encode idempotent response", zap.String("key", key), zap.Error(err))
+		if err := model.ReleaseIdempotencyKey(config.DB, lease); err != nil && !errors.Is(err, model.ErrIdempotencyLeaseLost) {
+			config.Log.Error("Failed to release Idempotency-Key", zap.String("key", key), zap.Error(err))
+		}
+	} else if err := model.CompleteIdempotencyKey(config.DB, lease, status, encoded); err != nil && !errors.Is(err, model.ErrIdempotencyLeaseLost) {
+		config.Log.Error("Failed to store idempotent response", zap.String("key", key), zap.Error(err))
+	}
+
+	sendJSONResponse(w, status, payload)
+}
+
+// hashRequestBody hashes a request body as canonical JSON, compact and with
+// sorted keys, so that retries differing only in formatting match. A body
+// that is not JSON is hashed as is.
+func hashRequestBody(body []byte) string {
+	canonical := body
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err == nil && !decoder.More() {
+		if encoded, err := json.Marshal(value); err == nil {
+			canonical = encoded
+		}
+	}
+
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,78 @@
+// controller/jobController.go
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// GetJob godoc
+// @Summary Get an asynchronous job by ID
+// @Description Reports the progress of a batch job and, for every receipt processed so far, its receipt ID or error.
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} model.Job
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /jobs/{id} [get]
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return
+	}
+
+	job, err := model.GetJobByID(config.DB, jobID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Job not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve job"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, job)
+}
+
+// enqueueReceiptBatch persists a decoded batch as a queued job, wakes a
+// worker and answers 202 with where to follow the job's progress.
+func enqueueReceiptBatch(w http.ResponseWriter, entries []batchEntry, audit model.AuditInfo) {
+	payloads := make([]json.RawMessage, len(entries))
+	for i := range entries {
+		payloads[i] = entries[i].raw
+	}
+
+	job, err := model.CreateJob(config.DB, payloads, audit)
+	if err != nil {
+		config.Log.Error("Failed to queue receipt batch", zap.Error(err))
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to queue receipt batch"})
+		return
+	}
+
+	notifyJobWorkers()
+
+	statusURL := "/jobs/" + job.ID.String()
+	w.Header().Set("Location", statusURL)
+	sendJSONResponse(w, http.StatusAccepted, JobAcceptedResponse{
+		JobID:     job.ID.String(),
+		Status:    job.Status,
+		Total:     job.Total,
+		StatusURL: statusURL,
+	})
+}
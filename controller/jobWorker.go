@@ -0,0 +1,179 @@
+// controller/jobWorker.go
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// jobPollInterval is how often idle workers look for queued jobs when they
+// have not been woken up by a new submission.
+const jobPollInterval = 5 * time.Second
+
+// errJobAttemptsExhausted fails a job claimed after its last attempt.
+var errJobAttemptsExhausted = errors.New("job has used up its attempts")
+
+// jobQueued wakes an idle worker when a job is submitted.
+var jobQueued = make(chan struct{}, 1)
+
+// StartJobWorkers starts a pool of workers processing queued jobs until ctx
+// is cancelled. Jobs interrupted by a restart, or by another instance
+// going away, are taken over once their lease expires.
+func StartJobWorkers(ctx context.Context, workers int) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	for i := 0; i < workers; i++ {
+		owner := fmt.Sprintf("%s/%d/%d", hostname, os.Getpid(), i)
+		go runJobWorker(ctx, i, owner)
+	}
+
+	config.Log.Info("Job workers started", zap.Int("workers", workers))
+}
+
+func notifyJobWorkers() {
+	select {
+	case jobQueued <- struct{}{}:
+	default:
+	}
+}
+
+// runJobWorker claims and processes jobs as owner, which identifies the
+// worker in job leases across instances.
+func runJobWorker(ctx context.Context, worker int, owner string) {
+	for {
+		jobID, attempts, ok, err := model.ClaimNextJob(config.DB, owner)
+		if err == nil && ok {
+			processJob(worker, owner, jobID, attempts)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-jobQueued:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// processJob runs the pending items of a claimed job through the same
+// pipeline as synchronous submissions, one chunk at a time, recording each
+// chunk's outcome before moving on so progress is visible while it runs.
+// A heartbeat keeps the job leased to owner meanwhile.
+func processJob(worker int, owner string, jobID uuid.UUID, attempts int) {
+	startTime := time.Now()
+	config.Log.Info("Processing job",
+		zap.Int("worker", worker), zap.String("id", jobID.String()), zap.Int("attempt", attempts))
+
+	// A job whose workers kept going away without releasing it has used up
+	// its attempts too
+	if attempts > model.MaxJobAttempts {
+		retryJob(jobID, owner, errJobAttemptsExhausted)
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go renewJobLease(jobID, owner, stop)
+
+	for {
+		items, err := model.GetPendingJobItems(config.DB, jobID, model.AddReceiptsChunkSize)
+		if err != nil {
+			retryJob(jobID, owner, err)
+			return
+		}
+
+		if len(items) == 0 {
+			break
+		}
+
+		results := make([]model.JobItemResult, len(items))
+		for i, item := range items {
+			results[i] = prepareJobItem(item)
+		}
+
+		if err := model.RecordJobItemResults(config.DB, jobID, owner, results); err != nil {
+			retryJob(jobID, owner, err)
+			return
+		}
+	}
+
+	if err := model.CompleteJob(config.DB, jobID, owner); err != nil {
+		retryJob(jobID, owner, err)
+		return
+	}
+
+	config.Log.Info("Job completed",
+		zap.Int("worker", worker),
+		zap.String("id", jobID.String()),
+		zap.Duration("duration", time.Since(startTime)))
+}
+
+func prepareJobItem(item model.PendingJobItem) model.JobItemResult {
+	result := model.JobItemResult{Index: item.Index}
+
+	var receipt model.Receipt
+	if err := json.Unmarshal(item.Payload, &receipt); err != nil {
+		result.Error = "Invalid input: " + err.Error()
+		return result
+	}
+
+	if err := prepareReceipt(&receipt); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Receipt = &receipt
+	return result
+}
+
+// renewJobLease extends owner's lease of a job every third of
+// JobLeaseDuration until stop is closed or the lease is lost.
+func renewJobLease(jobID uuid.UUID, owner string, stop <-chan struct{}) {
+	ticker := time.NewTicker(model.JobLeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := model.RenewJobLease(config.DB, jobID, owner); errors.Is(err, model.ErrJobLeaseLost) {
+				config.Log.Warn("Job lease lost", zap.String("id", jobID.String()), zap.String("owner", owner))
+				return
+			}
+		}
+	}
+}
+
+// retryJob hands a job back to the queue after a database error so that
+// it is retried after a backoff instead of being stuck as running, or fails
+// it once it has used up its attempts. A job whose lease was lost belongs
+// to another worker now and is left alone.
+func retryJob(jobID uuid.UUID, owner string, cause error) {
+	if errors.Is(cause, model.ErrJobLeaseLost) {
+		config.Log.Warn("Job lease lost, stopping", zap.String("id", jobID.String()), zap.String("owner", owner))
+		return
+	}
+	config.Log.Error("Job interrupted", zap.String("id", jobID.String()), zap.Error(cause))
+
+	status, err := model.RetryJob(config.DB, jobID, owner)
+	if err != nil {
+		config.Log.Error("Failed to retry job", zap.String("id", jobID.String()), zap.Error(err))
+	} else if status == model.JobStatusFailed {
+		config.Log.Error("Job failed after its last attempt", zap.String("id", jobID.String()))
+	}
+}
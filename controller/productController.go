@@ -0,0 +1,229 @@
+// controller/productController.go
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// GetProduct godoc
+// @Summary Get a product
+// @Description Returns a product with its canonical name, brand and category and the SKUs it is sold under.
+// @Tags products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} model.Product
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /products/{id} [get]
+func GetProduct(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := model.GetProduct(config.DB, productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Product not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve product"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, product)
+}
+
+// UpdateProduct godoc
+// @Summary Update a product
+// @Description Replaces the canonical name, brand and category of a product.
+// @Tags products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param product body ProductRequest true "Canonical name, brand and category"
+// @Success 200 {object} model.Product
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /products/{id} [put]
+func UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	var request ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		config.Log.Error("Invalid input", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid input"})
+		return
+	}
+	product := &model.Product{
+		ID:       productID,
+		Name:     strings.TrimSpace(request.Name),
+		Brand:    strings.TrimSpace(request.Brand),
+		Category: strings.TrimSpace(request.Category),
+	}
+	if product.Name == "" {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "A product name is required"})
+		return
+	}
+
+	if err := model.UpdateProduct(config.DB, product); errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Product not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to update product"})
+		return
+	}
+
+	GetProduct(w, r)
+}
+
+// LinkProductSKU godoc
+// @Summary Link a SKU to a product
+// @Description Makes a SKU, e.g. the same product sold by another retailer, one of the product's SKUs, moving it from the product it belonged to.
+// @Tags products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param prefix path string true "SKU prefix, or _ for none"
+// @Param sku path string true "SKU unique identifier"
+// @Success 200 {object} model.Product
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "Product or SKU not found"
+// @Failure 500 {object} ErrorResponse
+// @Router /products/{id}/skus/{prefix}/{sku} [put]
+func LinkProductSKU(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	prefix := mux.Vars(r)["prefix"]
+	if prefix == noSKUPrefixParam {
+		prefix = ""
+	}
+	sku := model.SKUKey{Prefix: prefix, UniqueIdentifier: mux.Vars(r)["sku"]}
+
+	if err := model.LinkSKUToProduct(config.DB, productID, sku); errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Product or SKU not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to link SKU to product"})
+		return
+	}
+
+	GetProduct(w, r)
+}
+
+// GetProductPrices godoc
+// @Summary Get the price history of a product
+// @Description Summarizes the unit prices (price paid over quantity) a product was bought at on live receipts, per period and retailer, oldest first. Rejected receipts are left out.
+// @Tags products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param period query string false "day, week, month (default), quarter or year"
+// @Param from query string false "Earliest purchase date (YYYY-MM-DD, inclusive)"
+// @Param to query string false "Latest purchase date (YYYY-MM-DD, inclusive)"
+// @Param retailer query string false "Only purchases from this retailer"
+// @Success 200 {object} ProductPricesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /products/{id}/prices [get]
+func GetProductPrices(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := parsePriceHistoryFilter(r)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Tell an unknown product apart from one without purchases
+	if _, err := model.GetProduct(config.DB, productID); errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Product not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve product"})
+		return
+	}
+
+	prices, err := model.GetProductPrices(config.DB, productID, filter)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve product prices"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, ProductPricesResponse{
+		ProductID: productID.String(),
+		Period:    filter.Period,
+		Prices:    prices,
+	})
+}
+
+/*
+	Helper Functions
+*/
+// parseProductID reads the product ID from the path, responding with the
+// error itself when it is not a UUID.
+func parseProductID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	productID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return uuid.Nil, false
+	}
+	return productID, true
+}
+
+func parsePriceHistoryFilter(r *http.Request) (model.PriceHistoryFilter, error) {
+	query := r.URL.Query()
+	filter := model.PriceHistoryFilter{
+		Period:   model.DefaultPricePeriod,
+		Retailer: query.Get("retailer"),
+	}
+
+	if period := query.Get("period"); period != "" {
+		if !model.ValidPricePeriod(period) {
+			return filter, errInvalidQueryParam("period", period)
+		}
+		filter.Period = period
+	}
+
+	from, to, err := parseDateRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		return filter, err
+	}
+	filter.FromDate, filter.ToDate = from, to
+
+	return filter, nil
+}
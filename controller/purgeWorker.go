@@ -0,0 +1,41 @@
+// controller/purgeWorker.go
+
+package controller
+
+import (
+	"context"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StartPurgeWorker runs the retention purge every config.Retention.PurgeInterval
+// until ctx is cancelled. A zero interval leaves purging to rcptctl.
+func StartPurgeWorker(ctx context.Context) {
+	interval := config.Retention.PurgeInterval
+	if interval <= 0 {
+		config.Log.Info("Purge worker disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := model.PurgeReceipts(config.DB, config.Retention); err != nil {
+				config.Log.Error("Purge failed", zap.Error(err))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	config.Log.Info("Purge worker started", zap.Duration("interval", interval))
+}
@@ -4,7 +4,9 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"rcpt-proc-challenge-ans/config"
 	"rcpt-proc-challenge-ans/model"
@@ -46,6 +48,7 @@ func GetReceipt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", receiptETag(receipt.Version))
 	sendJSONResponse(w, http.StatusOK, receipt)
 	//w.Header().Set("Content-Type", "application/json")
 	//json.NewEncoder(w).Encode(receipt)
@@ -58,75 +61,73 @@ func GetReceipt(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param receipt body model.Receipt true "Receipt"
+// @Param Idempotency-Key header string false "Key making retries of this request return the original response"
 // @Success 200 {object} ProcessReceiptResponse
 // @Failure 400 {string} string "Invalid input"
+// @Failure 409 {object} DuplicateReceiptResponse "Receipt already submitted, or a request with this Idempotency-Key is still in progress"
+// @Failure 409 {object} SKUConflictResponse "An item SKU conflicts with the catalog under the reject policy"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request body"
 // @Failure 500 {string} string "Failed to create receipt"
 // @Router /receipts/process [post]
 func ProcessReceipt(w http.ResponseWriter, r *http.Request) {
-	var receipt model.Receipt
-	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
 		config.Log.Error("Invalid input", zap.Error(err))
 		sendJSONResponse(w, http.StatusBadRequest, 
 			ErrorResponse{Error: "Invalid input"})
-		//http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	// Clean item descriptions before validation or calculation
-    //cleanItemShortDescriptions(&receipt)
+	audit := requestAudit(r)
 
-    // Validate the receipt
-    if err := receipt.ValidateReceipt(); err != nil {
-		config.Log.Error("Invalid receipt data", zap.Error(err))
-		sendJSONResponse(w, http.StatusBadRequest, 
-			ErrorResponse{Error: err.Error()})
-		//http.Error(w, err.Error(), http.StatusBadRequest)
+	// Retries carrying the same Idempotency-Key get the original response
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		handleIdempotentRequest(w, key, body, func(body []byte) (int, interface{}) {
+			return processReceiptBody(body, audit)
+		})
 		return
 	}
 
-	// run trimming operation for itemShortDescriptions...
-	//model.CleanItemShortDescriptions(&receipt)
-	//cleanItemShortDescriptions(&receipt)
-	// Clean item descriptions
-    receipt.CleanItemShortDescriptions()
+	status, payload := processReceiptBody(body, audit)
+	sendJSONResponse(w, status, payload)
+}
 
-	// reformat Date if needed.
-	if formattedDate, err := parseAndFormatDate(receipt.PurchaseDate); err == nil {
-		receipt.PurchaseDate = formattedDate
-	} else {
-		fmt.Println(err)
+// processReceiptBody decodes, prepares and stores a single receipt and
+// returns the status and payload to respond with.
+func processReceiptBody(body []byte, audit model.AuditInfo) (int, interface{}) {
+	var receipt model.Receipt
+	if err := json.Unmarshal(body, &receipt); err != nil {
+		config.Log.Error("Invalid input", zap.Error(err))
+		return http.StatusBadRequest, ErrorResponse{Error: "Invalid input"}
 	}
 
-	// reformat Time if needed.
-	if formattedTime, err := parseAndFormatTime(receipt.PurchaseTime); err == nil {
-		receipt.PurchaseTime = formattedTime
-	} else {
-		fmt.Println(err)
+	// Validate, clean and score the receipt
+	if err := prepareReceipt(&receipt); err != nil {
+		return http.StatusBadRequest, ErrorResponse{Error: err.Error()}
 	}
 
-    // Generate and set the receipt ID
-    receipt.GenerateID()
-	receipt.CalculatePoints()
-
 	// AddReceipt
-	if err := model.AddReceipt(config.DB, &receipt); err != nil {
+	var duplicate *model.DuplicateReceiptError
+	var skuConflict *model.SKUConflictError
+	if err := model.AddReceipt(config.DB, &receipt, audit); errors.As(err, &duplicate) {
+		return http.StatusConflict, DuplicateReceiptResponse{
+			Error:      "Receipt has already been submitted",
+			ExistingID: duplicate.ExistingID.String(),
+		}
+	} else if errors.As(err, &skuConflict) {
+		return http.StatusConflict, SKUConflictResponse{
+			Error:    skuConflict.Error(),
+			Conflict: skuConflict.Conflict,
+		}
+	} else if err != nil {
 		config.Log.Error("Failed to create receipt", zap.Error(err))
-		sendJSONResponse(w, http.StatusInternalServerError, 
-			ErrorResponse{Error: "Failed to create receipt"})
-		//http.Error(w, "Failed to create receipt", http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError, ErrorResponse{Error: "Failed to create receipt"}
 	}
 
-	/*
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(ProcessReceiptResponse{
-		ID: receipt.ID.String(),
-	})
-	*/
-	sendJSONResponse(w, http.StatusOK, 
-		ProcessReceiptResponse{
-			ID: receipt.ID.String(),
-	})
+	return http.StatusOK, ProcessReceiptResponse{
+		ID:           receipt.ID.String(),
+		SKUConflicts: receipt.SKUConflicts,
+	}
 }
 
 
@@ -160,9 +161,20 @@ func GetReceiptPoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Points only count once the receipt is approved
+	if receipt.Status != model.ReceiptStatusApproved {
+		sendJSONResponse(w, http.StatusOK, GetReceiptPointsResponse{
+			Points:         0,
+			PointsWithheld: true,
+			Status:         receipt.Status,
+		})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(GetReceiptPointsResponse{
-		Points: receipt.Points,
+		Points:    receipt.Points,
+		Breakdown: receipt.PointsBreakdown,
 	})
 }
 
@@ -193,6 +205,80 @@ func GetAllReceipts(w http.ResponseWriter, r *http.Request) {
 /*
 	Helper Functions
 */
+// prepareReceipt assigns a new ID to a submitted receipt and runs it
+// through the processing pipeline. Every submission path goes through here.
+func prepareReceipt(receipt *model.Receipt) error {
+	// Generate and set the receipt ID
+	receipt.GenerateID()
+	return processReceiptContent(receipt)
+}
+
+// processReceiptContent is the processing pipeline shared by submissions
+// and updates: validate, clean the item descriptions, normalize date/time,
+// calculate the points and score it for fraud.
+func processReceiptContent(receipt *model.Receipt) error {
+	// SKUs in a retailer's own format can only be read knowing the retailer
+	receipt.ResolveSKUs()
+
+	if err := receipt.NormalizeGTINs(); err != nil {
+		config.Log.Error("Invalid receipt data", zap.Error(err))
+		return err
+	}
+
+	if err := receipt.NormalizeStore(); err != nil {
+		config.Log.Error("Invalid receipt data", zap.Error(err))
+		return err
+	}
+
+	// Clean item descriptions before validation or calculation
+    //cleanItemShortDescriptions(&receipt)
+
+    // Validate the receipt
+    if err := receipt.ValidateReceipt(); err != nil {
+		config.Log.Error("Invalid receipt data", zap.Error(err))
+		return err
+	}
+
+	// run trimming operation for itemShortDescriptions...
+	//model.CleanItemShortDescriptions(&receipt)
+	//cleanItemShortDescriptions(&receipt)
+	// Clean item descriptions
+    receipt.CleanItemShortDescriptions()
+
+	// Put every item in a category of the taxonomy for spending reports
+	model.CategorizeItems(config.DB, receipt)
+
+	// reformat Date if needed.
+	if formattedDate, err := parseAndFormatDate(receipt.PurchaseDate); err == nil {
+		receipt.PurchaseDate = formattedDate
+	} else {
+		config.Log.Error("Failed to format purchase date", zap.String("purchaseDate", receipt.PurchaseDate), zap.Error(err))
+	}
+
+	// reformat Time if needed.
+	if formattedTime, err := parseAndFormatTime(receipt.PurchaseTime); err == nil {
+		receipt.PurchaseTime = formattedTime
+	} else {
+		config.Log.Error("Failed to format purchase time", zap.String("purchaseTime", receipt.PurchaseTime), zap.Error(err))
+	}
+
+	// Link the receipt to its canonical retailer, whose name points may count
+	model.NormalizeRetailer(config.DB, receipt)
+
+	// Stores belong to a canonical retailer
+	if receipt.Store != nil && receipt.RetailerID == 0 {
+		config.Log.Error("Invalid receipt data", zap.Error(model.ErrStoreWithoutRetailer))
+		return model.ErrStoreWithoutRetailer
+	}
+
+	receipt.CalculatePointsWithRules(config.Points)
+
+	// Score the receipt for fraud; flagged receipts are held for review
+	model.AssessFraud(config.DB, receipt, config.Fraud)
+
+	return nil
+}
+
 // Date Functions
 func isISODateFormat(dateStr string) bool {
 	// Regular expression to check if the date string is in YYYY-MM-DD format
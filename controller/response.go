@@ -2,16 +2,150 @@
 
 package controller
 
+import "rcpt-proc-challenge-ans/model"
+
 type ErrorResponse struct {
     Error string `json:"error"`
 }
 
 // CreateReceiptResponse represents the response for creating a receipt
 type ProcessReceiptResponse struct {
-    ID string `json:"id"`
+    ID           string              `json:"id"`
+    SKUConflicts []model.SKUConflict `json:"skuConflicts,omitempty"`
+}
+
+// DuplicateReceiptResponse represents the response for a receipt that was already submitted
+type DuplicateReceiptResponse struct {
+    Error      string `json:"error"`
+    ExistingID string `json:"existingID"`
+}
+
+// SKUConflictResponse represents the response for a receipt rejected for defining a stored SKU differently
+type SKUConflictResponse struct {
+    Error    string            `json:"error"`
+    Conflict model.SKUConflict `json:"conflict"`
+}
+
+// VersionConflictResponse represents the response for an update based on an outdated receipt version
+type VersionConflictResponse struct {
+    Error          string `json:"error"`
+    CurrentVersion int    `json:"currentVersion"`
 }
 
 // GetReceiptPointsResponse represents the response for getting receipt points
 type GetReceiptPointsResponse struct {
-    Points uint `json:"points"`
+    Points         uint            `json:"points"`
+    PointsWithheld bool            `json:"pointsWithheld,omitempty"`
+    Status         string          `json:"status,omitempty"`
+    Breakdown      map[string]uint `json:"breakdown,omitempty"`
+}
+
+// ReviewRequest is the body of a receipt approval or rejection
+type ReviewRequest struct {
+    Reason   string `json:"reason"`
+    Reviewer string `json:"reviewer"`
+}
+
+// BatchReceiptResult is the outcome of one receipt in a batch submission
+type BatchReceiptResult struct {
+    Index        int                 `json:"index"`
+    ID           string              `json:"id,omitempty"`
+    Error        string              `json:"error,omitempty"`
+    ExistingID   string              `json:"existingID,omitempty"`
+    SKUConflicts []model.SKUConflict `json:"skuConflicts,omitempty"`
+}
+
+// ProcessReceiptBatchResponse represents the response for processing a batch of receipts
+type ProcessReceiptBatchResponse struct {
+    Accepted int                  `json:"accepted"`
+    Rejected int                  `json:"rejected"`
+    Results  []BatchReceiptResult `json:"results"`
+}
+
+// JobAcceptedResponse represents the response for queueing an asynchronous batch
+type JobAcceptedResponse struct {
+    JobID     string `json:"jobID"`
+    Status    string `json:"status"`
+    Total     int    `json:"total"`
+    StatusURL string `json:"statusURL"`
+}
+
+// SearchReceiptsResponse represents one page of receipt search results
+type SearchReceiptsResponse struct {
+    Total   int                  `json:"total"`
+    Limit   int                  `json:"limit"`
+    Offset  int                  `json:"offset"`
+    Results []model.SearchResult `json:"results"`
+}
+
+// SKUListResponse represents one page of the SKU catalog
+type SKUListResponse struct {
+    Total  int                `json:"total"`
+    Limit  int                `json:"limit"`
+    Offset int                `json:"offset"`
+    SKUs   []model.CatalogSKU `json:"skus"`
+}
+
+// SKUReceiptsResponse represents one page of the receipts that bought a SKU or GTIN
+type SKUReceiptsResponse struct {
+    Total    int             `json:"total"`
+    Limit    int             `json:"limit"`
+    Offset   int             `json:"offset"`
+    Receipts []model.Receipt `json:"receipts"`
+}
+
+// ProductRequest is the body of a product update
+type ProductRequest struct {
+    Name     string `json:"name"`
+    Brand    string `json:"brand"`
+    Category string `json:"category"`
+}
+
+// ProductPricesResponse represents the price history of a product
+type ProductPricesResponse struct {
+    ProductID string             `json:"productID"`
+    Period    string             `json:"period"`
+    Prices    []model.PricePoint `json:"prices"`
+}
+
+// CategoryRequest is the body of a new taxonomy category
+type CategoryRequest struct {
+    Name     string   `json:"name"`
+    ParentID *int     `json:"parentID"`
+    Keywords []string `json:"keywords"`
+}
+
+// CategoryMappingRequest is the body of a SKU category code mapping
+type CategoryMappingRequest struct {
+    CategoryID int `json:"categoryID"`
+}
+
+// CategoryMappingResponse represents a SKU category code mapping that was set
+type CategoryMappingResponse struct {
+    Code          string `json:"code"`
+    CategoryID    int    `json:"categoryID"`
+    Recategorized int64  `json:"recategorized"`
+}
+
+// RetailerRequest is the body of a new or replaced canonical retailer
+type RetailerRequest struct {
+    Name    string   `json:"name"`
+    Aliases []string `json:"aliases"`
+}
+
+// StoreListResponse represents one page of store locations
+type StoreListResponse struct {
+    Total  int           `json:"total"`
+    Limit  int           `json:"limit"`
+    Offset int           `json:"offset"`
+    Stores []model.Store `json:"stores"`
+}
+
+// NearbyReceiptsResponse represents one page of receipts near a point, nearest first
+type NearbyReceiptsResponse struct {
+    Total    int                   `json:"total"`
+    Limit    int                   `json:"limit"`
+    Offset   int                   `json:"offset"`
+    RadiusKm float64               `json:"radiusKm"`
+    Results  []model.NearbyReceipt `json:"results"`
 }
@@ -3,6 +3,7 @@
 package controller
 
 import (
+    "fmt"
     "net/http"
     "encoding/json"
     "rcpt-proc-challenge-ans/config"
@@ -37,4 +38,8 @@ func sendJSONResponse(w http.ResponseWriter, status int, payload interface{}) {
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(status)
     json.NewEncoder(w).Encode(payload)
-}
\ No newline at end of file
+}
+
+func errInvalidQueryParam(name, value string) error {
+    return fmt.Errorf("invalid value %q for query parameter %q", value, name)
+}
@@ -0,0 +1,214 @@
+// controller/retailerController.go
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// GetRetailers godoc
+// @Summary List the canonical retailers
+// @Description Returns the canonical retailers by name, each with the alias patterns that link receipts to it.
+// @Tags retailers
+// @Produce json
+// @Success 200 {array} model.Retailer
+// @Failure 500 {object} ErrorResponse
+// @Router /retailers [get]
+func GetRetailers(w http.ResponseWriter, r *http.Request) {
+	retailers, err := model.ListRetailers(config.DB)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve retailers"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, retailers)
+}
+
+// GetRetailer godoc
+// @Summary Get a canonical retailer
+// @Tags retailers
+// @Produce json
+// @Param id path int true "Retailer ID"
+// @Success 200 {object} model.Retailer
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /retailers/{id} [get]
+func GetRetailer(w http.ResponseWriter, r *http.Request) {
+	retailerID, ok := parseRetailerID(w, r)
+	if !ok {
+		return
+	}
+
+	retailer, err := model.GetRetailer(config.DB, retailerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Retailer not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve retailer"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, retailer)
+}
+
+// CreateRetailer godoc
+// @Summary Add a canonical retailer
+// @Description Adds a canonical retailer. Aliases are patterns over the retailer name lowercased with only letters and digits kept, where * matches any run of characters and ? a single one (e.g. target*). New receipts it matches are linked to it at once; stored ones when `rcptctl relink-retailers` runs.
+// @Tags retailers
+// @Accept json
+// @Produce json
+// @Param retailer body RetailerRequest true "Canonical name and alias patterns"
+// @Success 201 {object} model.Retailer
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Another retailer has the same name"
+// @Failure 500 {object} ErrorResponse
+// @Router /retailers [post]
+func CreateRetailer(w http.ResponseWriter, r *http.Request) {
+	retailer, ok := decodeRetailerRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := model.CreateRetailer(config.DB, retailer); errors.Is(err, model.ErrDuplicateRetailer) {
+		sendJSONResponse(w, http.StatusConflict,
+			ErrorResponse{Error: err.Error()})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to create retailer"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, retailer)
+}
+
+// UpdateRetailer godoc
+// @Summary Update a canonical retailer
+// @Description Replaces the name and alias patterns of a canonical retailer. New receipts are linked by them at once; stored ones when `rcptctl relink-retailers` runs.
+// @Tags retailers
+// @Accept json
+// @Produce json
+// @Param id path int true "Retailer ID"
+// @Param retailer body RetailerRequest true "Canonical name and alias patterns"
+// @Success 200 {object} model.Retailer
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Another retailer has the same name"
+// @Failure 500 {object} ErrorResponse
+// @Router /retailers/{id} [put]
+func UpdateRetailer(w http.ResponseWriter, r *http.Request) {
+	retailerID, ok := parseRetailerID(w, r)
+	if !ok {
+		return
+	}
+	retailer, ok := decodeRetailerRequest(w, r)
+	if !ok {
+		return
+	}
+	retailer.ID = retailerID
+
+	err := model.UpdateRetailer(config.DB, retailer)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Retailer not found"})
+		return
+	case errors.Is(err, model.ErrDuplicateRetailer):
+		sendJSONResponse(w, http.StatusConflict,
+			ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to update retailer"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, retailer)
+}
+
+// DeleteRetailer godoc
+// @Summary Delete a canonical retailer
+// @Description Deletes a canonical retailer. A retailer that stores or receipts are still linked to cannot be deleted.
+// @Tags retailers
+// @Param id path int true "Retailer ID"
+// @Success 204 "Retailer deleted"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Stores or receipts are linked to the retailer"
+// @Failure 500 {object} ErrorResponse
+// @Router /retailers/{id} [delete]
+func DeleteRetailer(w http.ResponseWriter, r *http.Request) {
+	retailerID, ok := parseRetailerID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := model.DeleteRetailer(config.DB, retailerID); errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Retailer not found"})
+		return
+	} else if errors.Is(err, model.ErrRetailerInUse) {
+		sendJSONResponse(w, http.StatusConflict,
+			ErrorResponse{Error: err.Error()})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to delete retailer"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+	Helper Functions
+*/
+// parseRetailerID reads the retailer ID from the path, responding with the
+// error itself when it is not a number.
+func parseRetailerID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	retailerID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		config.Log.Error("Invalid retailer ID", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid retailer ID"})
+		return 0, false
+	}
+	return retailerID, true
+}
+
+// decodeRetailerRequest reads a retailer from the request body, responding
+// with the error itself when the body is invalid or the name is missing.
+func decodeRetailerRequest(w http.ResponseWriter, r *http.Request) (*model.Retailer, bool) {
+	var request RetailerRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		config.Log.Error("Invalid input", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid input"})
+		return nil, false
+	}
+
+	retailer := &model.Retailer{
+		Name:    strings.TrimSpace(request.Name),
+		Aliases: request.Aliases,
+	}
+	if retailer.Name == "" {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "A retailer name is required"})
+		return nil, false
+	}
+	return retailer, true
+}
@@ -0,0 +1,109 @@
+// controller/retentionController.go
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// DeleteReceipt godoc
+// @Summary Delete a receipt
+// @Description Soft-deletes a receipt. It is hidden from every read and can be restored within the restore window, after which it is purged.
+// @Tags receipts
+// @Param id path string true "Receipt ID"
+// @Success 204 "Receipt deleted"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /receipts/{id} [delete]
+func DeleteReceipt(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	receiptID, err := uuid.Parse(id)
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return
+	}
+
+	err = model.DeleteReceipt(config.DB, receiptID, requestAudit(r))
+	if errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Receipt not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to delete receipt"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreReceipt godoc
+// @Summary Restore a deleted receipt
+// @Description Undoes the deletion of a receipt deleted within the restore window.
+// @Tags receipts
+// @Produce json
+// @Param id path string true "Receipt ID"
+// @Success 200 {object} model.Receipt
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} DuplicateReceiptResponse "Receipt is not deleted, or was submitted again since"
+// @Failure 410 {object} ErrorResponse "Restore window expired"
+// @Router /receipts/{id}/restore [post]
+func RestoreReceipt(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	receiptID, err := uuid.Parse(id)
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return
+	}
+
+	err = model.RestoreReceipt(config.DB, receiptID, config.Retention.RestoreWindow, requestAudit(r))
+
+	var duplicate *model.DuplicateReceiptError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Receipt not found"})
+		return
+	case errors.Is(err, model.ErrReceiptNotDeleted):
+		sendJSONResponse(w, http.StatusConflict,
+			ErrorResponse{Error: "Receipt is not deleted"})
+		return
+	case errors.Is(err, model.ErrRestoreWindowExpired):
+		sendJSONResponse(w, http.StatusGone,
+			ErrorResponse{Error: "Receipt was deleted too long ago to be restored"})
+		return
+	case errors.As(err, &duplicate):
+		sendJSONResponse(w, http.StatusConflict, DuplicateReceiptResponse{
+			Error:      "Receipt has been submitted again since it was deleted",
+			ExistingID: duplicate.ExistingID.String(),
+		})
+		return
+	case err != nil:
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to restore receipt"})
+		return
+	}
+
+	receipt, err := model.GetReceiptByID(config.DB, receiptID)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve receipt"})
+		return
+	}
+
+	w.Header().Set("ETag", receiptETag(receipt.Version))
+	sendJSONResponse(w, http.StatusOK, receipt)
+}
@@ -0,0 +1,167 @@
+// controller/reviewController.go
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Page size bounds of the review queue listing
+const (
+	defaultReviewQueueLimit = 50
+	maxReviewQueueLimit     = 500
+)
+
+// GetPendingReceipts godoc
+// @Summary List receipts awaiting review
+// @Description Lists receipts flagged by fraud scoring that have not been approved or rejected yet, oldest first.
+// @Tags reviews
+// @Produce json
+// @Param limit query int false "Maximum number of receipts (default 50, max 500)"
+// @Success 200 {array} model.Receipt
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /receipts/pending [get]
+func GetPendingReceipts(w http.ResponseWriter, r *http.Request) {
+	limit := defaultReviewQueueLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxReviewQueueLimit {
+			sendJSONResponse(w, http.StatusBadRequest,
+				ErrorResponse{Error: errInvalidQueryParam("limit", value).Error()})
+			return
+		}
+		limit = parsed
+	}
+
+	receipts, err := model.GetPendingReceipts(config.DB, limit)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve pending receipts"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, receipts)
+}
+
+// ApproveReceipt godoc
+// @Summary Approve a pending receipt
+// @Description Approves a receipt held for review, so its points count. The decision is recorded in the receipt's review history.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Receipt ID"
+// @Param review body ReviewRequest false "Optional reason and reviewer"
+// @Success 200 {object} model.ReviewDecision
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Receipt is not pending review"
+// @Router /receipts/{id}/approve [post]
+func ApproveReceipt(w http.ResponseWriter, r *http.Request) {
+	reviewReceipt(w, r, model.ReceiptStatusApproved)
+}
+
+// RejectReceipt godoc
+// @Summary Reject a pending receipt
+// @Description Rejects a receipt held for review; its points are never awarded. A reason is required and is recorded in the receipt's review history.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Receipt ID"
+// @Param review body ReviewRequest true "Reason and optional reviewer"
+// @Success 200 {object} model.ReviewDecision
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Receipt is not pending review"
+// @Router /receipts/{id}/reject [post]
+func RejectReceipt(w http.ResponseWriter, r *http.Request) {
+	reviewReceipt(w, r, model.ReceiptStatusRejected)
+}
+
+// GetReceiptReviews godoc
+// @Summary Get the review history of a receipt
+// @Description Lists every review decision made on a receipt, oldest first.
+// @Tags reviews
+// @Produce json
+// @Param id path string true "Receipt ID"
+// @Success 200 {array} model.ReviewDecision
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /receipts/{id}/reviews [get]
+func GetReceiptReviews(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	receiptID, err := uuid.Parse(id)
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return
+	}
+
+	decisions, err := model.GetReviewDecisions(config.DB, receiptID)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve review history"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, decisions)
+}
+
+// reviewReceipt applies a reviewer's decision to the receipt in the path.
+func reviewReceipt(w http.ResponseWriter, r *http.Request, decision string) {
+	id := mux.Vars(r)["id"]
+	receiptID, err := uuid.Parse(id)
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return
+	}
+
+	// The body is optional when approving
+	var request ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		config.Log.Error("Invalid input", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid input"})
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	request.Reviewer = strings.TrimSpace(request.Reviewer)
+
+	if decision == model.ReceiptStatusRejected && request.Reason == "" {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "A reason is required to reject a receipt"})
+		return
+	}
+
+	// The decision is audited to the caller; the named reviewer is only
+	// kept in the review history
+	review, err := model.ReviewReceipt(config.DB, receiptID, decision, request.Reason, request.Reviewer, requestAudit(r))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Receipt not found"})
+	case errors.Is(err, model.ErrReceiptNotPending):
+		sendJSONResponse(w, http.StatusConflict,
+			ErrorResponse{Error: "Receipt is not pending review"})
+	case err != nil:
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to review receipt"})
+	default:
+		sendJSONResponse(w, http.StatusOK, review)
+	}
+}
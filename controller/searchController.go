@@ -0,0 +1,75 @@
+// controller/searchController.go
+
+package controller
+
+import (
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+)
+
+// Page size bounds of search results
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchReceipts godoc
+// @Summary Search receipts
+// @Description Full-text search over retailers and item descriptions. Every word must match; "quoted phrases" must match in order and a trailing * matches a prefix. Results are ranked, best match first.
+// @Tags receipts
+// @Produce json
+// @Param q query string true "Search query, e.g. doritos or \"nacho chee*\""
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of results to skip"
+// @Success 200 {object} SearchReceiptsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /receipts/search [get]
+func SearchReceipts(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	query, err := model.ParseSearchQuery(params.Get("q"))
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Query parameter \"q\" must contain at least one word"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if value := params.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxSearchLimit {
+			sendJSONResponse(w, http.StatusBadRequest,
+				ErrorResponse{Error: errInvalidQueryParam("limit", value).Error()})
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if value := params.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			sendJSONResponse(w, http.StatusBadRequest,
+				ErrorResponse{Error: errInvalidQueryParam("offset", value).Error()})
+			return
+		}
+		offset = parsed
+	}
+
+	results, total, err := model.SearchReceipts(config.DB, query, limit, offset)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to search receipts"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, SearchReceiptsResponse{
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Results: results,
+	})
+}
@@ -0,0 +1,298 @@
+// controller/skuController.go
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// Page size bounds of the SKU catalog listings
+const (
+	defaultCatalogLimit = 50
+	maxCatalogLimit     = 500
+)
+
+// skuAttributeParamPrefix marks the query parameters that filter on a SKU
+// attribute, e.g. attr.SIZE=12PK.
+const skuAttributeParamPrefix = "attr."
+
+// noSKUPrefixParam stands in the path for the empty prefix of a SKU that
+// has none, e.g. /skus/_/ABC123.
+const noSKUPrefixParam = "_"
+
+// GetSKUs godoc
+// @Summary List the SKU catalog
+// @Description Lists every SKU seen on a receipt with how many live receipts bought it, ordered by prefix and unique identifier. Filters combine; attr.NAME=VALUE matches SKUs having that attribute value and may be repeated for different attributes.
+// @Tags skus
+// @Produce json
+// @Param prefix query string false "SKU prefix"
+// @Param category query string false "Product category"
+// @Param manufacturer query string false "Manufacturer"
+// @Param attr.NAME query string false "Attribute value, e.g. attr.SIZE=12PK"
+// @Param gtin query string false "UPC-A, EAN-13 or GTIN-14 the SKU was bought under"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of SKUs to skip"
+// @Success 200 {object} SKUListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /skus [get]
+func GetSKUs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	filter, err := parseSKUFilter(params)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	limit, offset, err := parsePage(params, defaultCatalogLimit, maxCatalogLimit)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	skus, total, err := model.ListSKUs(config.DB, filter, limit, offset)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to list SKUs"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, SKUListResponse{
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		SKUs:   skus,
+	})
+}
+
+// GetSKU godoc
+// @Summary Get a SKU
+// @Description Returns a SKU from the catalog with how many live receipts bought it. A SKU is identified by its prefix and unique identifier together.
+// @Tags skus
+// @Produce json
+// @Param prefix path string true "SKU prefix, or _ for none"
+// @Param id path string true "SKU unique identifier"
+// @Success 200 {object} model.CatalogSKU
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /skus/{prefix}/{id} [get]
+func GetSKU(w http.ResponseWriter, r *http.Request) {
+	prefix, id := skuPathKey(r)
+
+	sku, err := model.GetSKU(config.DB, prefix, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "SKU not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve SKU"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, sku)
+}
+
+// GetSKUReceipts godoc
+// @Summary List the receipts that bought a SKU
+// @Description Lists the live receipts with at least one item of the SKU, newest first, with all of their items.
+// @Tags skus
+// @Produce json
+// @Param prefix path string true "SKU prefix, or _ for none"
+// @Param id path string true "SKU unique identifier"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of receipts to skip"
+// @Success 200 {object} SKUReceiptsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /skus/{prefix}/{id}/receipts [get]
+func GetSKUReceipts(w http.ResponseWriter, r *http.Request) {
+	prefix, id := skuPathKey(r)
+
+	limit, offset, err := parsePage(r.URL.Query(), defaultCatalogLimit, maxCatalogLimit)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Tell an unknown SKU apart from one that is only on deleted receipts
+	if _, err := model.GetSKU(config.DB, prefix, id); errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "SKU not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve SKU"})
+		return
+	}
+
+	receipts, total, err := model.GetReceiptsForSKU(config.DB, prefix, id, limit, offset)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve receipts for SKU"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, SKUReceiptsResponse{
+		Total:    total,
+		Limit:    limit,
+		Offset:   offset,
+		Receipts: receipts,
+	})
+}
+
+// GetGTIN godoc
+// @Summary Look up a barcode number
+// @Description Returns a UPC-A, EAN-13 or GTIN-14 in all three forms, with how many live receipts bought it and the SKUs it was bought under.
+// @Tags skus
+// @Produce json
+// @Param gtin path string true "UPC-A, EAN-13 or GTIN-14"
+// @Success 200 {object} model.CatalogGTIN
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /gtins/{gtin} [get]
+func GetGTIN(w http.ResponseWriter, r *http.Request) {
+	gtin, err := model.NormalizeGTIN(mux.Vars(r)["gtin"])
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	product, err := model.GetGTIN(config.DB, gtin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "GTIN not found"})
+		return
+	} else if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve GTIN"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, product)
+}
+
+// GetGTINReceipts godoc
+// @Summary List the receipts that bought a barcode number
+// @Description Lists the live receipts with at least one item of the UPC-A, EAN-13 or GTIN-14, newest first, with all of their items.
+// @Tags skus
+// @Produce json
+// @Param gtin path string true "UPC-A, EAN-13 or GTIN-14"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of receipts to skip"
+// @Success 200 {object} SKUReceiptsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /gtins/{gtin}/receipts [get]
+func GetGTINReceipts(w http.ResponseWriter, r *http.Request) {
+	gtin, err := model.NormalizeGTIN(mux.Vars(r)["gtin"])
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query(), defaultCatalogLimit, maxCatalogLimit)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	receipts, total, err := model.GetReceiptsForGTIN(config.DB, gtin, limit, offset)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve receipts for GTIN"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, SKUReceiptsResponse{
+		Total:    total,
+		Limit:    limit,
+		Offset:   offset,
+		Receipts: receipts,
+	})
+}
+
+// skuPathKey reads the prefix and unique identifier of a SKU from the path.
+func skuPathKey(r *http.Request) (string, string) {
+	vars := mux.Vars(r)
+	prefix := vars["prefix"]
+	if prefix == noSKUPrefixParam {
+		prefix = ""
+	}
+	return prefix, vars["id"]
+}
+
+// parseSKUFilter reads the catalog filters from the query string.
+func parseSKUFilter(params url.Values) (model.SKUFilter, error) {
+	filter := model.SKUFilter{
+		Prefix:          params.Get("prefix"),
+		ProductCategory: params.Get("category"),
+		Manufacturer:    params.Get("manufacturer"),
+	}
+
+	if value := params.Get("gtin"); value != "" {
+		gtin, err := model.NormalizeGTIN(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.GTIN = gtin
+	}
+
+	for name, values := range params {
+		attribute, ok := strings.CutPrefix(name, skuAttributeParamPrefix)
+		if !ok {
+			continue
+		}
+		// A SKU has a single value per attribute, so two can never both match
+		if attribute == "" || len(values) != 1 || values[0] == "" {
+			return filter, errInvalidQueryParam(name, strings.Join(values, ","))
+		}
+		if filter.Attributes == nil {
+			filter.Attributes = map[string]string{}
+		}
+		filter.Attributes[attribute] = values[0]
+	}
+
+	return filter, nil
+}
+
+// parsePage reads the limit and offset query parameters of a paged listing.
+func parsePage(params url.Values, defaultLimit, maxLimit int) (int, int, error) {
+	limit := defaultLimit
+	if value := params.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxLimit {
+			return 0, 0, errInvalidQueryParam("limit", value)
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if value := params.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, errInvalidQueryParam("offset", value)
+		}
+		offset = parsed
+	}
+
+	return limit, offset, nil
+}
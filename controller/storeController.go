@@ -0,0 +1,135 @@
+// controller/storeController.go
+
+package controller
+
+import (
+	"math"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+)
+
+// Page size bounds of the store and nearby receipt listings
+const (
+	defaultStoreLimit = 50
+	maxStoreLimit     = 500
+)
+
+// Radius bounds, in kilometers, of nearby receipt queries
+const (
+	defaultNearbyRadiusKm = 10
+	maxNearbyRadiusKm     = 1000
+)
+
+// GetStores godoc
+// @Summary List store locations
+// @Description Returns a page of the store locations receipts were printed at, ordered by retailer and store number.
+// @Tags stores
+// @Produce json
+// @Param retailerID query int false "Only stores of this canonical retailer"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of stores to skip"
+// @Success 200 {object} StoreListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /stores [get]
+func GetStores(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	retailerID := 0
+	if value := params.Get("retailerID"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			sendJSONResponse(w, http.StatusBadRequest,
+				ErrorResponse{Error: errInvalidQueryParam("retailerID", value).Error()})
+			return
+		}
+		retailerID = parsed
+	}
+
+	limit, offset, err := parsePage(params, defaultStoreLimit, maxStoreLimit)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	stores, total, err := model.ListStores(config.DB, retailerID, limit, offset)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve stores"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, StoreListResponse{
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Stores: stores,
+	})
+}
+
+// GetNearbyReceipts godoc
+// @Summary Find receipts near a point
+// @Description Returns a page of the receipts printed at stores within a radius of a point, nearest first, each with its distance in kilometers. Stores without coordinates are left out.
+// @Tags receipts
+// @Produce json
+// @Param lat query number true "Latitude in degrees"
+// @Param lon query number true "Longitude in degrees"
+// @Param radius query number false "Radius in kilometers (default 10, max 1000)"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of receipts to skip"
+// @Success 200 {object} NearbyReceiptsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /receipts/nearby [get]
+func GetNearbyReceipts(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	latitude, err := strconv.ParseFloat(params.Get("lat"), 64)
+	if err != nil || math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: errInvalidQueryParam("lat", params.Get("lat")).Error()})
+		return
+	}
+	longitude, err := strconv.ParseFloat(params.Get("lon"), 64)
+	if err != nil || math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: errInvalidQueryParam("lon", params.Get("lon")).Error()})
+		return
+	}
+
+	radiusKm := float64(defaultNearbyRadiusKm)
+	if value := params.Get("radius"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(parsed) || parsed <= 0 || parsed > maxNearbyRadiusKm {
+			sendJSONResponse(w, http.StatusBadRequest,
+				ErrorResponse{Error: errInvalidQueryParam("radius", value).Error()})
+			return
+		}
+		radiusKm = parsed
+	}
+
+	limit, offset, err := parsePage(params, defaultStoreLimit, maxStoreLimit)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	results, total, err := model.GetReceiptsNear(config.DB, latitude, longitude, radiusKm, limit, offset)
+	if err != nil {
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to retrieve nearby receipts"})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, NearbyReceiptsResponse{
+		Total:    total,
+		Limit:    limit,
+		Offset:   offset,
+		RadiusKm: radiusKm,
+		Results:  results,
+	})
+}
@@ -0,0 +1,220 @@
+// controller/updateController.go
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+	"rcpt-proc-challenge-ans/model"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// receiptPatch holds the fields a PATCH may change; absent fields keep
+// their stored value. Items, when present, replace all stored items.
+type receiptPatch struct {
+	Retailer     *string       `json:"retailer"`
+	PurchaseDate *string       `json:"purchaseDate"`
+	PurchaseTime *string       `json:"purchaseTime"`
+	Total        *string       `json:"total"`
+	Items        *[]model.Item `json:"items"`
+	MemberID     *string       `json:"memberId"`
+	Store        *model.Store  `json:"store"`
+}
+
+// ReplaceReceipt godoc
+// @Summary Replace a receipt
+// @Description Replaces a stored receipt. The receipt is re-validated, re-cleaned and re-scored, and its items are replaced. The If-Match header must carry the receipt's current ETag (its version).
+// @Tags receipts
+// @Accept json
+// @Produce json
+// @Param id path string true "Receipt ID"
+// @Param If-Match header string true "ETag of the version being replaced"
+// @Param receipt body model.Receipt true "Receipt"
+// @Success 200 {object} model.Receipt
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} DuplicateReceiptResponse "Content matches another stored receipt"
+// @Failure 409 {object} SKUConflictResponse "An item SKU conflicts with the catalog under the reject policy"
+// @Failure 412 {object} VersionConflictResponse "Receipt was modified since the given version"
+// @Failure 428 {object} ErrorResponse "If-Match header missing"
+// @Router /receipts/{id} [put]
+func ReplaceReceipt(w http.ResponseWriter, r *http.Request) {
+	receiptID, version, ok := parseUpdateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var receipt model.Receipt
+	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+		config.Log.Error("Invalid input", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	receipt.ID = receiptID
+	updateReceipt(w, &receipt, version, requestAudit(r))
+}
+
+// PatchReceipt godoc
+// @Summary Partially update a receipt
+// @Description Changes the given fields of a stored receipt; items, when given, replace all stored items. The result is re-validated, re-cleaned and re-scored. The If-Match header must carry the receipt's current ETag (its version).
+// @Tags receipts
+// @Accept json
+// @Produce json
+// @Param id path string true "Receipt ID"
+// @Param If-Match header string true "ETag of the version being changed"
+// @Param receipt body object true "Fields to change"
+// @Success 200 {object} model.Receipt
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} DuplicateReceiptResponse "Content matches another stored receipt"
+// @Failure 409 {object} SKUConflictResponse "An item SKU conflicts with the catalog under the reject policy"
+// @Failure 412 {object} VersionConflictResponse "Receipt was modified since the given version"
+// @Failure 428 {object} ErrorResponse "If-Match header missing"
+// @Router /receipts/{id} [patch]
+func PatchReceipt(w http.ResponseWriter, r *http.Request) {
+	receiptID, version, ok := parseUpdateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var patch receiptPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		config.Log.Error("Invalid input", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid input"})
+		return
+	}
+
+	receipt, err := model.GetReceiptByID(config.DB, receiptID)
+	if err != nil {
+		config.Log.Error("Receipt not found", zap.String("id", receiptID.String()), zap.Error(err))
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Receipt not found"})
+		return
+	}
+
+	if patch.Retailer != nil {
+		receipt.Retailer = *patch.Retailer
+	}
+	if patch.PurchaseDate != nil {
+		receipt.PurchaseDate = *patch.PurchaseDate
+	}
+	if patch.PurchaseTime != nil {
+		receipt.PurchaseTime = *patch.PurchaseTime
+	}
+	if patch.Total != nil {
+		receipt.Total = *patch.Total
+	}
+	if patch.Items != nil {
+		receipt.Items = *patch.Items
+	}
+	if patch.MemberID != nil {
+		receipt.MemberID = *patch.MemberID
+	}
+	if patch.Store != nil {
+		receipt.Store = patch.Store
+	}
+
+	updateReceipt(w, receipt, version, requestAudit(r))
+}
+
+// updateReceipt re-processes the changed receipt, stores it if version is
+// still current and responds with the stored receipt and its new ETag.
+func updateReceipt(w http.ResponseWriter, receipt *model.Receipt, version int, audit model.AuditInfo) {
+	if err := processReceiptContent(receipt); err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	err := model.UpdateReceipt(config.DB, receipt, version, audit)
+
+	var conflict *model.VersionConflictError
+	var duplicate *model.DuplicateReceiptError
+	var skuConflict *model.SKUConflictError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		sendJSONResponse(w, http.StatusNotFound,
+			ErrorResponse{Error: "Receipt not found"})
+	case errors.As(err, &conflict):
+		w.Header().Set("ETag", receiptETag(conflict.CurrentVersion))
+		sendJSONResponse(w, http.StatusPreconditionFailed, VersionConflictResponse{
+			Error:          "Receipt has been modified since the given version",
+			CurrentVersion: conflict.CurrentVersion,
+		})
+	case errors.As(err, &duplicate):
+		sendJSONResponse(w, http.StatusConflict, DuplicateReceiptResponse{
+			Error:      "Receipt matches another submitted receipt",
+			ExistingID: duplicate.ExistingID.String(),
+		})
+	case errors.As(err, &skuConflict):
+		sendJSONResponse(w, http.StatusConflict, SKUConflictResponse{
+			Error:    skuConflict.Error(),
+			Conflict: skuConflict.Conflict,
+		})
+	case err != nil:
+		sendJSONResponse(w, http.StatusInternalServerError,
+			ErrorResponse{Error: "Failed to update receipt"})
+	default:
+		w.Header().Set("ETag", receiptETag(receipt.Version))
+		sendJSONResponse(w, http.StatusOK, receipt)
+	}
+}
+
+// parseUpdateRequest reads the receipt ID and the If-Match version of an
+// update request, responding with the error itself when either is unusable.
+func parseUpdateRequest(w http.ResponseWriter, r *http.Request) (uuid.UUID, int, bool) {
+	id := mux.Vars(r)["id"]
+	receiptID, err := uuid.Parse(id)
+	if err != nil {
+		config.Log.Error("Invalid UUID format", zap.Error(err))
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: "Invalid UUID format"})
+		return uuid.Nil, 0, false
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		sendJSONResponse(w, http.StatusPreconditionRequired,
+			ErrorResponse{Error: "If-Match header with the receipt's ETag is required"})
+		return uuid.Nil, 0, false
+	}
+
+	version, err := parseReceiptETag(ifMatch)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest,
+			ErrorResponse{Error: err.Error()})
+		return uuid.Nil, 0, false
+	}
+
+	return receiptID, version, true
+}
+
+// receiptETag is the ETag of a receipt version.
+func receiptETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseReceiptETag reads the version back from an If-Match value. Weak and
+// unquoted forms are accepted since the version is all that is compared.
+func parseReceiptETag(value string) (int, error) {
+	tag := strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	tag = strings.Trim(tag, `"`)
+
+	version, err := strconv.Atoi(tag)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("invalid If-Match value %q, expected a receipt ETag", value)
+	}
+	return version, nil
+}
@@ -0,0 +1,18 @@
+-- +goose Up
+-- created_xid, the transaction that stored a receipt, orders incremental
+-- exports: every transaction below the oldest one still running has
+-- finished, so a receipt can never commit behind a position already
+-- exported. created_at is when it was stored, for filtering.
+
+ALTER TABLE receipts
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN created_xid XID8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS idx_receipts_created_at ON receipts(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_receipts_created_xid ON receipts(created_xid, id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_receipts_created_xid;
+DROP INDEX IF EXISTS idx_receipts_created_at;
+ALTER TABLE receipts DROP COLUMN created_xid, DROP COLUMN created_at;
@@ -0,0 +1,37 @@
+-- +goose Up
+-- Asynchronous batch submissions. Each job item keeps its raw payload so
+-- unfinished jobs can be picked up again after a restart. A running job is
+-- leased to the worker locked_by until locked_until, which its heartbeat
+-- keeps extending; once the lease expires, another worker may take over.
+-- Every claim counts as an attempt; a job retried after an error waits
+-- until not_before, and fails once it runs out of attempts.
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id UUID PRIMARY KEY,
+    status VARCHAR(20) NOT NULL,
+    total INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at TIMESTAMPTZ,
+    locked_by VARCHAR(255),
+    locked_until TIMESTAMPTZ,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    not_before TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status_created_at ON jobs(status, created_at);
+
+CREATE TABLE IF NOT EXISTS job_items (
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    item_index INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    receipt_id UUID REFERENCES receipts(id) ON DELETE SET NULL,
+    error TEXT,
+    PRIMARY KEY (job_id, item_index)
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS job_items;
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Idempotency-Key support for POST /receipts/process. A NULL response_status
+-- means the original request is still being processed, until locked_until;
+-- after that a retry of the same request may take the key over. lock_token
+-- identifies the reservation, so a request outlived by its lease cannot
+-- store its response over, or release, the retry's.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash CHAR(64) NOT NULL,
+    response_status INTEGER,
+    response_body JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    lock_token UUID
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS idempotency_keys;
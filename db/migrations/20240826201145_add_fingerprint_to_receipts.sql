@@ -0,0 +1,12 @@
+-- +goose Up
+-- Content fingerprint used to reject duplicate submissions of the same receipt.
+-- Receipts stored before this migration keep a NULL fingerprint.
+
+ALTER TABLE receipts ADD COLUMN fingerprint CHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_receipts_fingerprint ON receipts(fingerprint);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_receipts_fingerprint;
+ALTER TABLE receipts DROP COLUMN fingerprint;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Optional submitting member, and the outcome of fraud scoring
+
+ALTER TABLE receipts
+    ADD COLUMN member_id VARCHAR(255),
+    ADD COLUMN fraud_score NUMERIC(6, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN fraud_reasons JSONB NOT NULL DEFAULT '[]',
+    ADD COLUMN flagged BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN points_withheld BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_receipts_member_created_at ON receipts(member_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_receipts_retailer_lower ON receipts(LOWER(retailer));
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_receipts_retailer_lower;
+DROP INDEX IF EXISTS idx_receipts_member_created_at;
+ALTER TABLE receipts
+    DROP COLUMN points_withheld,
+    DROP COLUMN flagged,
+    DROP COLUMN fraud_reasons,
+    DROP COLUMN fraud_score,
+    DROP COLUMN member_id;
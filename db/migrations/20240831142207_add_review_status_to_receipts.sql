@@ -0,0 +1,35 @@
+-- +goose Up
+-- Review lifecycle: flagged receipts wait as 'pending' until a reviewer
+-- approves or rejects them, and points only count once 'approved'.
+-- The status replaces the points_withheld flag: receipts whose points were
+-- withheld go to review, while flagged receipts whose points were awarded
+-- keep them.
+
+ALTER TABLE receipts
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'approved'
+        CHECK (status IN ('pending', 'approved', 'rejected'));
+
+UPDATE receipts SET status = CASE WHEN points_withheld THEN 'pending' ELSE 'approved' END;
+
+ALTER TABLE receipts DROP COLUMN points_withheld;
+
+CREATE INDEX IF NOT EXISTS idx_receipts_status_created_at ON receipts(status, created_at);
+
+CREATE TABLE IF NOT EXISTS receipt_reviews (
+    id BIGSERIAL PRIMARY KEY,
+    receipt_id UUID NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+    decision VARCHAR(16) NOT NULL CHECK (decision IN ('approved', 'rejected')),
+    reason TEXT NOT NULL DEFAULT '',
+    reviewer VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_receipt_reviews_receipt_id ON receipt_reviews(receipt_id, created_at);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS receipt_reviews;
+DROP INDEX IF EXISTS idx_receipts_status_created_at;
+ALTER TABLE receipts ADD COLUMN points_withheld BOOLEAN NOT NULL DEFAULT false;
+UPDATE receipts SET points_withheld = (status <> 'approved');
+ALTER TABLE receipts DROP COLUMN status;
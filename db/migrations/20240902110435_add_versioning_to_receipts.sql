@@ -0,0 +1,22 @@
+-- +goose Up
+-- Optimistic concurrency for receipt updates, and a snapshot of every
+-- version an update replaced
+
+ALTER TABLE receipts
+    ADD COLUMN version INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN updated_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS receipt_versions (
+    receipt_id UUID NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    snapshot JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (receipt_id, version)
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS receipt_versions;
+ALTER TABLE receipts
+    DROP COLUMN updated_at,
+    DROP COLUMN version;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Soft deletion: deleted receipts are hidden from reads and restorable
+-- until the purge job hard-deletes them. Only live receipts count as
+-- duplicates, so the fingerprint index becomes partial.
+
+ALTER TABLE receipts ADD COLUMN deleted_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS idx_receipts_fingerprint;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_receipts_fingerprint ON receipts(fingerprint) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_receipts_deleted_at ON receipts(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_receipts_deleted_at;
+DROP INDEX IF EXISTS idx_receipts_fingerprint;
+DELETE FROM items WHERE receipt_id IN (SELECT id FROM receipts WHERE deleted_at IS NOT NULL);
+DELETE FROM receipts WHERE deleted_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_receipts_fingerprint ON receipts(fingerprint);
+ALTER TABLE receipts DROP COLUMN deleted_at;
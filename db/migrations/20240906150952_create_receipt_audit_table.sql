@@ -0,0 +1,40 @@
+-- +goose Up
+-- Append-only audit log of every change to a receipt. There is no foreign
+-- key to receipts: entries must outlive the receipts they describe.
+
+CREATE TABLE IF NOT EXISTS receipt_audit (
+    id BIGSERIAL PRIMARY KEY,
+    receipt_id UUID NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    diff JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_receipt_audit_receipt_id ON receipt_audit(receipt_id, id);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION receipt_audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'receipt_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER receipt_audit_append_only
+    BEFORE UPDATE OR DELETE ON receipt_audit
+    FOR EACH ROW EXECUTE FUNCTION receipt_audit_append_only();
+
+-- Who submitted an asynchronous batch, so its receipts are audited to them
+ALTER TABLE jobs
+    ADD COLUMN actor VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN request_id VARCHAR(255) NOT NULL DEFAULT '';
+
+-- +goose Down
+
+ALTER TABLE jobs
+    DROP COLUMN request_id,
+    DROP COLUMN actor;
+DROP TABLE IF EXISTS receipt_audit;
+DROP FUNCTION IF EXISTS receipt_audit_append_only();
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Full-text search over retailers and item descriptions. The 'simple'
+-- configuration only lowercases, so brand names are not stemmed.
+
+ALTER TABLE receipts
+    ADD COLUMN search_vector tsvector
+        GENERATED ALWAYS AS (to_tsvector('simple', retailer)) STORED;
+
+ALTER TABLE items
+    ADD COLUMN search_vector tsvector
+        GENERATED ALWAYS AS (to_tsvector('simple', short_description)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_receipts_search_vector ON receipts USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_items_search_vector ON items USING GIN (search_vector);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_items_search_vector;
+DROP INDEX IF EXISTS idx_receipts_search_vector;
+ALTER TABLE items DROP COLUMN search_vector;
+ALTER TABLE receipts DROP COLUMN search_vector;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Indexes for browsing the SKU catalog: filters on the SKU columns and
+-- attributes, and the receipts and purchase counts of a SKU.
+
+CREATE INDEX IF NOT EXISTS idx_skus_prefix ON skus (prefix);
+CREATE INDEX IF NOT EXISTS idx_skus_product_category ON skus (product_category);
+CREATE INDEX IF NOT EXISTS idx_skus_manufacturer ON skus (manufacturer);
+CREATE INDEX IF NOT EXISTS idx_skus_attributes ON skus USING GIN (attributes jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_items_sku_id ON items (sku_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_items_sku_id;
+DROP INDEX IF EXISTS idx_skus_attributes;
+DROP INDEX IF EXISTS idx_skus_manufacturer;
+DROP INDEX IF EXISTS idx_skus_product_category;
+DROP INDEX IF EXISTS idx_skus_prefix;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- SKUs that are not in the SKU grammar keep the retailer format they were
+-- read with (or 'raw') and the string they were submitted as. Both are NULL
+-- for the SKU grammar. Raw SKUs use the whole string as their identifier,
+-- hence the wider columns.
+
+ALTER TABLE skus
+    ADD COLUMN format VARCHAR(32),
+    ADD COLUMN raw VARCHAR(255);
+
+ALTER TABLE skus ALTER COLUMN unique_identifier TYPE VARCHAR(255);
+ALTER TABLE items ALTER COLUMN sku_id TYPE VARCHAR(255);
+
+-- +goose Down
+
+ALTER TABLE items ALTER COLUMN sku_id TYPE VARCHAR(50);
+ALTER TABLE skus ALTER COLUMN unique_identifier TYPE VARCHAR(50);
+ALTER TABLE skus DROP COLUMN raw, DROP COLUMN format;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Items may carry a barcode number, stored as a GTIN-14 (UPC-A and EAN-13
+-- zero-padded). Items without a SKU leave sku_id NULL.
+
+ALTER TABLE items ADD COLUMN gtin CHAR(14);
+
+CREATE INDEX IF NOT EXISTS idx_items_gtin ON items (gtin);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_items_gtin;
+ALTER TABLE items DROP COLUMN gtin;
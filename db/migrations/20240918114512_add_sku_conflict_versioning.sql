@@ -0,0 +1,24 @@
+-- +goose Up
+-- Under the version SKU conflict policy a conflicting receipt replaces the
+-- stored SKU definition, which is kept in sku_versions, and bumps its version.
+
+ALTER TABLE skus ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS sku_versions (
+    unique_identifier VARCHAR(255) NOT NULL REFERENCES skus (unique_identifier),
+    version INT NOT NULL,
+    prefix VARCHAR(50) NOT NULL,
+    product_category VARCHAR(50) NOT NULL,
+    manufacturer VARCHAR(100) NOT NULL,
+    product_line VARCHAR(100) NOT NULL,
+    attributes JSONB NOT NULL,
+    format VARCHAR(32),
+    raw VARCHAR(255),
+    superseded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (unique_identifier, version)
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS sku_versions;
+ALTER TABLE skus DROP COLUMN version;
@@ -0,0 +1,73 @@
+-- +goose Up
+-- A SKU is identified by its retailer prefix and unique identifier together,
+-- so the same identifier from two retailers makes two SKUs. Items reference
+-- both parts. Past versions of a SKU keep the prefix they were recorded
+-- with; only those recorded without one are moved under its current prefix.
+
+ALTER TABLE items ADD COLUMN sku_prefix VARCHAR(50);
+
+UPDATE items i SET sku_prefix = s.prefix
+FROM skus s
+WHERE s.unique_identifier = i.sku_id;
+
+UPDATE sku_versions v SET prefix = s.prefix
+FROM skus s
+WHERE s.unique_identifier = v.unique_identifier AND COALESCE(v.prefix, '') = '';
+
+ALTER TABLE items DROP CONSTRAINT IF EXISTS items_sku_id_fkey;
+ALTER TABLE sku_versions DROP CONSTRAINT IF EXISTS sku_versions_unique_identifier_fkey;
+ALTER TABLE sku_versions DROP CONSTRAINT sku_versions_pkey;
+ALTER TABLE skus DROP CONSTRAINT skus_pkey;
+
+ALTER TABLE skus ADD PRIMARY KEY (prefix, unique_identifier);
+
+-- A past version under a prefix the SKU no longer has is now another SKU,
+-- whose current definition is its latest version there
+WITH orphaned AS (
+    DELETE FROM sku_versions v
+    WHERE NOT EXISTS (
+            SELECT 1 FROM skus s
+            WHERE s.prefix = v.prefix AND s.unique_identifier = v.unique_identifier
+        )
+        AND v.version = (
+            SELECT MAX(w.version) FROM sku_versions w
+            WHERE w.prefix = v.prefix AND w.unique_identifier = v.unique_identifier
+        )
+    RETURNING *
+)
+INSERT INTO skus (unique_identifier, prefix, product_category, manufacturer, product_line, attributes, format, raw, version)
+SELECT unique_identifier, prefix, product_category, manufacturer, product_line, attributes, format, raw, version
+FROM orphaned;
+
+ALTER TABLE sku_versions
+    ADD PRIMARY KEY (prefix, unique_identifier, version),
+    ADD CONSTRAINT sku_versions_sku_fkey FOREIGN KEY (prefix, unique_identifier)
+        REFERENCES skus (prefix, unique_identifier);
+
+ALTER TABLE items
+    ADD CONSTRAINT items_sku_fkey FOREIGN KEY (sku_prefix, sku_id)
+        REFERENCES skus (prefix, unique_identifier),
+    ADD CONSTRAINT items_sku_complete CHECK ((sku_prefix IS NULL) = (sku_id IS NULL));
+
+DROP INDEX IF EXISTS idx_items_sku_id;
+CREATE INDEX IF NOT EXISTS idx_items_sku ON items (sku_prefix, sku_id);
+
+-- +goose Down
+-- Fails if an identifier is now used under more than one prefix.
+
+DROP INDEX IF EXISTS idx_items_sku;
+CREATE INDEX IF NOT EXISTS idx_items_sku_id ON items (sku_id);
+
+ALTER TABLE items DROP CONSTRAINT items_sku_complete, DROP CONSTRAINT items_sku_fkey;
+ALTER TABLE sku_versions DROP CONSTRAINT sku_versions_sku_fkey, DROP CONSTRAINT sku_versions_pkey;
+ALTER TABLE skus DROP CONSTRAINT skus_pkey;
+
+ALTER TABLE skus ADD PRIMARY KEY (unique_identifier);
+ALTER TABLE sku_versions
+    ADD PRIMARY KEY (unique_identifier, version),
+    ADD CONSTRAINT sku_versions_unique_identifier_fkey FOREIGN KEY (unique_identifier)
+        REFERENCES skus (unique_identifier);
+ALTER TABLE items
+    ADD CONSTRAINT items_sku_id_fkey FOREIGN KEY (sku_id) REFERENCES skus (unique_identifier);
+
+ALTER TABLE items DROP COLUMN sku_prefix;
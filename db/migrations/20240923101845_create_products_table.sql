@@ -0,0 +1,35 @@
+-- +goose Up
+-- A product is what one or more SKUs sell, under a canonical name, brand and
+-- category. Every SKU stored before this gets a product of its own, named
+-- after the first item bought under it, with an ID derived from the SKU.
+
+CREATE TABLE IF NOT EXISTS products (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    brand VARCHAR(100) NOT NULL DEFAULT '',
+    category VARCHAR(50) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ
+);
+
+ALTER TABLE skus ADD COLUMN product_id UUID REFERENCES products (id);
+
+INSERT INTO products (id, name, brand, category)
+SELECT md5(s.prefix || '-' || s.unique_identifier)::uuid,
+    COALESCE((
+        SELECT i.short_description FROM items i
+        WHERE i.sku_prefix = s.prefix AND i.sku_id = s.unique_identifier
+        ORDER BY i.id LIMIT 1
+    ), s.unique_identifier),
+    s.manufacturer, s.product_category
+FROM skus s;
+
+UPDATE skus SET product_id = md5(prefix || '-' || unique_identifier)::uuid;
+
+CREATE INDEX IF NOT EXISTS idx_skus_product_id ON skus (product_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_skus_product_id;
+ALTER TABLE skus DROP COLUMN product_id;
+DROP TABLE IF EXISTS products;
@@ -0,0 +1,71 @@
+-- +goose Up
+-- A hierarchical category taxonomy (Food > Snacks > Chips). SKU category
+-- codes map to a category; items without a mapped SKU are classified by the
+-- category keywords found in their description. Each item stores the
+-- category it was given so spending can be rolled up the tree.
+
+CREATE TABLE IF NOT EXISTS categories (
+    id SERIAL PRIMARY KEY,
+    parent_id INT REFERENCES categories (id),
+    name VARCHAR(100) NOT NULL,
+    keywords TEXT[] NOT NULL DEFAULT '{}'
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_parent_name ON categories (COALESCE(parent_id, 0), name);
+
+CREATE TABLE IF NOT EXISTS category_mappings (
+    code VARCHAR(50) PRIMARY KEY,
+    category_id INT NOT NULL REFERENCES categories (id)
+);
+
+ALTER TABLE items ADD COLUMN category_id INT REFERENCES categories (id);
+CREATE INDEX IF NOT EXISTS idx_items_category_id ON items (category_id);
+
+INSERT INTO categories (name, keywords) VALUES
+    ('Food', '{food}'),
+    ('Household', '{detergent,bleach,soap,sponge,paper towels,toilet paper,trash bags,cleaner}'),
+    ('Electronics', '{headphones,earbuds,charger,cable,battery,batteries,tv,speaker}'),
+    ('Clothing', '{shirt,jeans,pants,socks,jacket,sweater,dress,shoes}'),
+    ('Health & Beauty', '{shampoo,conditioner,toothpaste,toothbrush,vitamins,lotion,deodorant}');
+
+INSERT INTO categories (parent_id, name, keywords)
+SELECT id, child.name, child.keywords
+FROM categories, (VALUES
+    ('Snacks', '{snack,snacks,crackers,popcorn,pretzels}'::TEXT[]),
+    ('Beverages', '{drink,beverage,juice,water}'::TEXT[]),
+    ('Groceries', '{bread,rice,pasta,flour,sugar,cereal,soup}'::TEXT[]),
+    ('Produce', '{apple,apples,banana,bananas,lettuce,tomato,tomatoes,potato,potatoes,onion,onions}'::TEXT[]),
+    ('Dairy', '{milk,cheese,yogurt,butter,eggs,cream}'::TEXT[])
+) AS child (name, keywords)
+WHERE categories.name = 'Food' AND categories.parent_id IS NULL;
+
+INSERT INTO categories (parent_id, name, keywords)
+SELECT parent.id, child.name, child.keywords
+FROM categories parent, (VALUES
+    ('Snacks', 'Chips', '{chips,doritos,lays,pringles,cheetos,tostitos}'::TEXT[]),
+    ('Snacks', 'Candy', '{candy,chocolate,gum,skittles}'::TEXT[]),
+    ('Beverages', 'Soda', '{soda,cola,coke,pepsi,sprite,dr pepper,mountain dew}'::TEXT[]),
+    ('Beverages', 'Coffee & Tea', '{coffee,tea,espresso}'::TEXT[])
+) AS child (parent, name, keywords)
+WHERE parent.name = child.parent AND parent.parent_id IS NOT NULL;
+
+INSERT INTO category_mappings (code, category_id)
+SELECT mapping.code, categories.id
+FROM categories, (VALUES
+    ('GROC', 'Groceries'),
+    ('SNCK', 'Snacks'),
+    ('BVRG', 'Beverages'),
+    ('DAIRY', 'Dairy'),
+    ('ELEC', 'Electronics'),
+    ('CLTH', 'Clothing'),
+    ('HHLD', 'Household'),
+    ('HLTH', 'Health & Beauty')
+) AS mapping (code, name)
+WHERE categories.name = mapping.name;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_items_category_id;
+ALTER TABLE items DROP COLUMN category_id;
+DROP TABLE IF EXISTS category_mappings;
+DROP TABLE IF EXISTS categories;
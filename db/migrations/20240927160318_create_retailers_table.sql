@@ -0,0 +1,57 @@
+-- +goose Up
+-- Canonical retailers. A receipt's raw retailer text is compared lowercased
+-- and stripped of everything but letters and digits ("TARGET #1234" becomes
+-- "target1234") to each retailer's name and alias patterns, where * matches
+-- any run of characters and ? a single one. Existing receipts are linked
+-- here the same way.
+
+CREATE TABLE IF NOT EXISTS retailers (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ
+);
+
+-- A retailer cannot be deleted while receipts are linked to it
+ALTER TABLE receipts ADD COLUMN retailer_id INT REFERENCES retailers (id) ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS idx_receipts_retailer_id ON receipts (retailer_id);
+
+INSERT INTO retailers (name, aliases) VALUES
+    ('Target', '{target*,supertarget*}'),
+    ('Walmart', '{walmart*,walmartsupercenter*,walmartneighborhoodmarket*}'),
+    ('Amazon', '{amazon*,amzn*}'),
+    ('Costco', '{costco*}'),
+    ('Kroger', '{kroger*}'),
+    ('Walgreens', '{walgreens*}'),
+    ('CVS', '{cvs*}');
+
+-- As in RetailerNormalizer.Normalize, a retailer's own name wins, then the
+-- alias with the most literal characters, then the lower ID
+UPDATE receipts r SET retailer_id = matched.id
+FROM (
+    SELECT DISTINCT ON (k.receipt_id) k.receipt_id, rt.id
+    FROM (
+        SELECT id AS receipt_id, lower(regexp_replace(retailer, '[^[:alnum:]]', '', 'g')) AS key
+        FROM receipts
+    ) k
+    CROSS JOIN retailers rt
+    CROSS JOIN LATERAL (
+        SELECT
+            lower(regexp_replace(rt.name, '[^[:alnum:]]', '', 'g')) = k.key AS exact,
+            (
+                SELECT MAX(length(replace(replace(alias, '*', ''), '?', '')))
+                FROM unnest(rt.aliases) AS alias
+                WHERE k.key LIKE replace(replace(alias, '*', '%'), '?', '_')
+            ) AS literals
+    ) m
+    WHERE k.key <> '' AND (m.exact OR m.literals IS NOT NULL)
+    ORDER BY k.receipt_id, m.exact DESC, m.literals DESC NULLS LAST, rt.id
+) matched
+WHERE r.id = matched.receipt_id;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_receipts_retailer_id;
+ALTER TABLE receipts DROP COLUMN retailer_id;
+DROP TABLE IF EXISTS retailers;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- Store locations, each under a canonical retailer. A store is identified
+-- by its store number or, without one, its address, in the normalized form
+-- kept in store_key; later receipts fill in details earlier ones lacked.
+-- A retailer cannot be deleted while it has stores.
+
+CREATE TABLE IF NOT EXISTS stores (
+    id SERIAL PRIMARY KEY,
+    retailer_id INT NOT NULL REFERENCES retailers (id) ON DELETE RESTRICT,
+    store_key VARCHAR(512) NOT NULL,
+    store_number VARCHAR(50) NOT NULL DEFAULT '',
+    address TEXT NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ,
+    UNIQUE (retailer_id, store_key),
+    CONSTRAINT stores_coordinates CHECK (
+        (latitude IS NULL) = (longitude IS NULL)
+        AND latitude BETWEEN -90 AND 90
+        AND longitude BETWEEN -180 AND 180
+    )
+);
+
+CREATE INDEX IF NOT EXISTS idx_stores_latitude ON stores (latitude) WHERE latitude IS NOT NULL;
+
+ALTER TABLE receipts ADD COLUMN store_id INT REFERENCES stores (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_receipts_store_id ON receipts (store_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_receipts_store_id;
+ALTER TABLE receipts DROP COLUMN store_id;
+DROP TABLE IF EXISTS stores;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- The points each rule awarded a receipt, keyed by rule name, for points
+-- analytics. Receipts stored before this migration keep an empty breakdown;
+-- reports count their points as unattributed.
+
+ALTER TABLE receipts ADD COLUMN points_breakdown JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+
+ALTER TABLE receipts DROP COLUMN points_breakdown;
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"rcpt-proc-challenge-ans/config"
 	"rcpt-proc-challenge-ans/controller"
 	"rcpt-proc-challenge-ans/middleware"
@@ -32,6 +34,7 @@ func main() {
 
 	r := mux.NewRouter()
 	//r.Use(middleware.PreProcessLoggingMiddleware)
+	r.Use(middleware.RequestID)
 	r.Use(middleware.LoggingMiddleware)
 	r.Use(middleware.StripSlash)
 
@@ -40,9 +43,44 @@ func main() {
 	
 
 	r.HandleFunc("/receipts/process", controller.ProcessReceipt).Methods("POST")
+	r.HandleFunc("/receipts/batch", controller.ProcessReceiptBatch).Methods("POST")
+	r.HandleFunc("/receipts/export", controller.ExportReceipts).Methods("GET")
+	r.HandleFunc("/receipts/pending", controller.GetPendingReceipts).Methods("GET")
+	r.HandleFunc("/receipts/search", controller.SearchReceipts).Methods("GET")
+	r.HandleFunc("/receipts/nearby", controller.GetNearbyReceipts).Methods("GET")
 	r.HandleFunc("/receipts/{id}", controller.GetReceipt).Methods("GET")
+	r.HandleFunc("/receipts/{id}", controller.ReplaceReceipt).Methods("PUT")
+	r.HandleFunc("/receipts/{id}", controller.PatchReceipt).Methods("PATCH")
+	r.HandleFunc("/receipts/{id}", controller.DeleteReceipt).Methods("DELETE")
+	r.HandleFunc("/receipts/{id}/restore", controller.RestoreReceipt).Methods("POST")
 	r.HandleFunc("/receipts/{id}/points", controller.GetReceiptPoints).Methods("GET")
+	r.HandleFunc("/receipts/{id}/approve", controller.ApproveReceipt).Methods("POST")
+	r.HandleFunc("/receipts/{id}/reject", controller.RejectReceipt).Methods("POST")
+	r.HandleFunc("/receipts/{id}/reviews", controller.GetReceiptReviews).Methods("GET")
+	r.HandleFunc("/receipts/{id}/history", controller.GetReceiptHistory).Methods("GET")
 	r.HandleFunc("/receipts", controller.GetAllReceipts).Methods("GET")
+	r.HandleFunc("/skus", controller.GetSKUs).Methods("GET")
+	r.HandleFunc("/skus/{prefix}/{id}", controller.GetSKU).Methods("GET")
+	r.HandleFunc("/skus/{prefix}/{id}/receipts", controller.GetSKUReceipts).Methods("GET")
+	r.HandleFunc("/gtins/{gtin}", controller.GetGTIN).Methods("GET")
+	r.HandleFunc("/gtins/{gtin}/receipts", controller.GetGTINReceipts).Methods("GET")
+	r.HandleFunc("/categories", controller.GetCategories).Methods("GET")
+	r.HandleFunc("/categories", controller.CreateCategory).Methods("POST")
+	r.HandleFunc("/categories/mappings", controller.GetCategoryMappings).Methods("GET")
+	r.HandleFunc("/categories/mappings/{code}", controller.SetCategoryMapping).Methods("PUT")
+	r.HandleFunc("/retailers", controller.GetRetailers).Methods("GET")
+	r.HandleFunc("/retailers", controller.CreateRetailer).Methods("POST")
+	r.HandleFunc("/retailers/{id}", controller.GetRetailer).Methods("GET")
+	r.HandleFunc("/retailers/{id}", controller.UpdateRetailer).Methods("PUT")
+	r.HandleFunc("/retailers/{id}", controller.DeleteRetailer).Methods("DELETE")
+	r.HandleFunc("/stores", controller.GetStores).Methods("GET")
+	r.HandleFunc("/products/{id}", controller.GetProduct).Methods("GET")
+	r.HandleFunc("/products/{id}", controller.UpdateProduct).Methods("PUT")
+	r.HandleFunc("/products/{id}/prices", controller.GetProductPrices).Methods("GET")
+	r.HandleFunc("/products/{id}/skus/{prefix}/{sku}", controller.LinkProductSKU).Methods("PUT")
+	r.HandleFunc("/analytics/spend", controller.GetSpend).Methods("GET")
+	r.HandleFunc("/analytics/points", controller.GetPointsReport).Methods("GET")
+	r.HandleFunc("/jobs/{id}", controller.GetJob).Methods("GET")
 	
 	// Handle all other routes
     r.NotFoundHandler = http.HandlerFunc(controller.NotFoundHandler)
@@ -52,6 +90,16 @@ func main() {
 	// Swagger documentation endpoint
 	r.PathPrefix("/swagger").Handler(httpSwagger.WrapHandler)
 
+	// Background workers for asynchronous batch jobs
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 4
+	}
+	controller.StartJobWorkers(context.Background(), workers)
+
+	// Hard-deletes receipts past the retention window
+	controller.StartPurgeWorker(context.Background())
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -0,0 +1,35 @@
+// middleware/requestid.go
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"rcpt-proc-challenge-ans/config"
+)
+
+// RequestIDHeader carries the ID of a request, taken from the client when
+// given and generated otherwise.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID makes sure every request has an ID: it is echoed in the
+// response header and available to handlers through RequestIDFromContext.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > 255 {
+			requestID = config.GenerateUUID().String()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID)))
+	})
+}
+
+// RequestIDFromContext returns the ID RequestID assigned to the request.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
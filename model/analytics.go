@@ -0,0 +1,1003 @@
+// model/analytics.go
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidSpendGrouping is returned for a spend grouping that is not one
+// of SpendGroupings.
+var ErrInvalidSpendGrouping = errors.New("invalid spend grouping")
+
+// Spend groupings: receipts by canonical retailer (or the printed name when
+// they have none), by member or by purchase period, or items by category.
+const (
+	SpendByRetailer = "retailer"
+	SpendByCategory = "category"
+	SpendByMember   = "member"
+	SpendByDay      = "day"
+	SpendByWeek     = "week"
+	SpendByMonth    = "month"
+)
+
+// SpendGroupings are the ways spend can be grouped.
+var SpendGroupings = []string{SpendByRetailer, SpendByCategory, SpendByMember, SpendByDay, SpendByWeek, SpendByMonth}
+
+// DefaultSpendGrouping groups spend when no grouping is given.
+const DefaultSpendGrouping = SpendByMonth
+
+// uncategorizedSpendKey is the key of the items without a category.
+const uncategorizedSpendKey = "Uncategorized"
+
+// SpendFilter narrows a spend report. FromDate and ToDate are inclusive
+// YYYY-MM-DD purchase dates; empty fields match everything.
+type SpendFilter struct {
+	GroupBy  string
+	FromDate string
+	ToDate   string
+	MemberID string
+}
+
+// SpendReport is what was spent on live receipts, rejected ones left out,
+// in total and per group. Amounts are rounded to the cent.
+type SpendReport struct {
+	GroupBy  string       `json:"groupBy"`
+	FromDate string       `json:"from,omitempty"`
+	ToDate   string       `json:"to,omitempty"`
+	MemberID string       `json:"memberId,omitempty"`
+	Total    float64      `json:"total"`
+	Receipts int          `json:"receipts"`
+	Items    int          `json:"items"`
+	Groups   []SpendGroup `json:"groups"`
+}
+
+// SpendGroup is the spend of one group. Key is the retailer, the member (""
+// for receipts without one), the first day of the period (YYYY-MM-DD) or the
+// category path ("Food > Snacks"). Category totals roll up: a category
+// counts the items of its subcategories too, so only the root categories
+// and Uncategorized add up to the report total.
+type SpendGroup struct {
+	Key        string  `json:"key"`
+	CategoryID int     `json:"categoryID,omitempty"`
+	Total      float64 `json:"total"`
+	Receipts   int     `json:"receipts"`
+	Items      int     `json:"items"`
+}
+
+// ValidSpendGrouping reports whether groupBy is one of SpendGroupings.
+func ValidSpendGrouping(groupBy string) bool {
+	for _, valid := range SpendGroupings {
+		if groupBy == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeSpendFilter applies the default grouping and rejects unknown
+// ones.
+func normalizeSpendFilter(filter SpendFilter) (SpendFilter, error) {
+	if filter.GroupBy == "" {
+		filter.GroupBy = DefaultSpendGrouping
+	}
+	if !ValidSpendGrouping(filter.GroupBy) {
+		return filter, fmt.Errorf("%w %q: expected one of %s", ErrInvalidSpendGrouping, filter.GroupBy,
+			strings.Join(SpendGroupings, ", "))
+	}
+	return filter, nil
+}
+
+// GetSpend reports the spend on live receipts matching the filter, grouped
+// by filter.GroupBy, aggregating in the database.
+func GetSpend(db *pgxpool.Pool, filter SpendFilter) (*SpendReport, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := normalizeSpendFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"r.deleted_at IS NULL", "r.status <> 'rejected'"}
+	var args []any
+	if filter.FromDate != "" {
+		args = append(args, filter.FromDate)
+		conditions = append(conditions, fmt.Sprintf("r.purchase_date >= $%d::date", len(args)))
+	}
+	if filter.ToDate != "" {
+		args = append(args, filter.ToDate)
+		conditions = append(conditions, fmt.Sprintf("r.purchase_date <= $%d::date", len(args)))
+	}
+	if filter.MemberID != "" {
+		args = append(args, filter.MemberID)
+		conditions = append(conditions, fmt.Sprintf("r.member_id = $%d", len(args)))
+	}
+	where := strings.Join(conditions, " AND ")
+
+	report := &SpendReport{
+		GroupBy:  filter.GroupBy,
+		FromDate: filter.FromDate,
+		ToDate:   filter.ToDate,
+		MemberID: filter.MemberID,
+		Groups:   []SpendGroup{},
+	}
+	err = db.QueryRow(ctx, `
+		SELECT COALESCE(ROUND(SUM(r.total), 2), 0)::float8, COUNT(*)::int,
+			COALESCE(SUM((SELECT COUNT(*) FROM items i WHERE i.receipt_id = r.id)), 0)::int
+		FROM receipts r
+		WHERE `+where, args...).Scan(&report.Total, &report.Receipts, &report.Items)
+	if err != nil {
+		config.Log.Error("Failed to total spend", zap.Error(err))
+		return nil, err
+	}
+
+	if filter.GroupBy == SpendByCategory {
+		report.Groups, err = getCategorySpend(ctx, db, where, args)
+	} else {
+		report.Groups, err = getReceiptSpend(ctx, db, filter.GroupBy, where, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.Log.Info("GetSpend executed",
+		zap.String("groupBy", filter.GroupBy),
+		zap.Int("groups", len(report.Groups)),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return report, nil
+}
+
+// getReceiptSpend groups the receipts matching where by retailer, member
+// or period.
+func getReceiptSpend(ctx context.Context, db *pgxpool.Pool, groupBy, where string, args []any) ([]SpendGroup, error) {
+	var key, order string
+	switch groupBy {
+	case SpendByRetailer:
+		key = `COALESCE((SELECT rt.name FROM retailers rt WHERE rt.id = r.retailer_id), r.retailer)`
+		order = `total DESC, key`
+	case SpendByMember:
+		key = `COALESCE(r.member_id, '')`
+		order = `total DESC, key`
+	default:
+		args = append(args, groupBy)
+		key = fmt.Sprintf(`TO_CHAR(date_trunc($%d::text, r.purchase_date::timestamp), 'YYYY-MM-DD')`, len(args))
+		order = `key`
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT `+key+` AS key, ROUND(SUM(r.total), 2)::float8 AS total, COUNT(*)::int,
+			SUM((SELECT COUNT(*) FROM items i WHERE i.receipt_id = r.id))::int
+		FROM receipts r
+		WHERE `+where+`
+		GROUP BY key
+		ORDER BY `+order, args...)
+	if err != nil {
+		config.Log.Error("Failed to group spend", zap.String("groupBy", groupBy), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []SpendGroup{}
+	for rows.Next() {
+		var group SpendGroup
+		if err := rows.Scan(&group.Key, &group.Total, &group.Receipts, &group.Items); err != nil {
+			config.Log.Error("Failed to scan spend group", zap.Error(err))
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate spend groups", zap.Error(err))
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// getCategorySpend groups the items of the receipts matching where by
+// category, each item counting toward its category and every ancestor.
+func getCategorySpend(ctx context.Context, db *pgxpool.Pool, where string, args []any) ([]SpendGroup, error) {
+	taxonomy, err := GetTaxonomy(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		WITH RECURSIVE ancestry AS (
+			SELECT id AS category_id, id AS ancestor_id FROM categories
+			UNION ALL
+			SELECT a.category_id, c.parent_id
+			FROM ancestry a
+			JOIN categories c ON c.id = a.ancestor_id
+			WHERE c.parent_id IS NOT NULL
+		)
+		SELECT COALESCE(a.ancestor_id, 0), ROUND(SUM(i.price_paid), 2)::float8,
+			COUNT(DISTINCT i.receipt_id)::int, COUNT(*)::int
+		FROM items i
+		JOIN receipts r ON r.id = i.receipt_id
+		LEFT JOIN ancestry a ON a.category_id = i.category_id
+		WHERE `+where+`
+		GROUP BY 1
+	`, args...)
+	if err != nil {
+		config.Log.Error("Failed to group spend", zap.String("groupBy", SpendByCategory), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []SpendGroup{}
+	for rows.Next() {
+		var group SpendGroup
+		if err := rows.Scan(&group.CategoryID, &group.Total, &group.Receipts, &group.Items); err != nil {
+			config.Log.Error("Failed to scan spend group", zap.Error(err))
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate spend groups", zap.Error(err))
+		return nil, err
+	}
+
+	return labelCategorySpend(groups, taxonomy), nil
+}
+
+// labelCategorySpend keys category groups by their path and orders them
+// as the tree is, parents first, with Uncategorized last. Groups of
+// categories missing from the taxonomy are merged into Uncategorized.
+func labelCategorySpend(groups []SpendGroup, taxonomy *Taxonomy) []SpendGroup {
+	labeled := []SpendGroup{}
+	var uncategorized *SpendGroup
+	for _, group := range groups {
+		if category, ok := taxonomy.Category(group.CategoryID); ok {
+			group.Key = strings.Join(category.Path, " > ")
+			labeled = append(labeled, group)
+			continue
+		}
+		if uncategorized == nil {
+			uncategorized = &SpendGroup{Key: uncategorizedSpendKey}
+		}
+		uncategorized.Total = config.RoundToNearestCent(uncategorized.Total + group.Total)
+		uncategorized.Receipts += group.Receipts
+		uncategorized.Items += group.Items
+	}
+
+	sortKey := func(group SpendGroup) string {
+		category, _ := taxonomy.Category(group.CategoryID)
+		return strings.Join(category.Path, "\x00")
+	}
+	sort.Slice(labeled, func(i, j int) bool {
+		return sortKey(labeled[i]) < sortKey(labeled[j])
+	})
+
+	if uncategorized != nil {
+		labeled = append(labeled, *uncategorized)
+	}
+	return labeled
+}
+
+// SpendInMemory is GetSpend over receipts already in memory, which are
+// taken to be live; rejected ones are left out. Items are put in categories
+// by the taxonomy, which may be nil to leave them uncategorized.
+func SpendInMemory(receipts []Receipt, taxonomy *Taxonomy, filter SpendFilter) (*SpendReport, error) {
+	filter, err := normalizeSpendFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if taxonomy == nil {
+		taxonomy = NewTaxonomy(nil, nil)
+	}
+
+	type accumulator struct {
+		categoryID int
+		cents      int64
+		receipts   map[int]bool
+		items      int
+	}
+	groups := map[string]*accumulator{}
+	add := func(key string, categoryID int, cents int64, receipt, items int) {
+		group, ok := groups[key]
+		if !ok {
+			group = &accumulator{categoryID: categoryID, receipts: map[int]bool{}}
+			groups[key] = group
+		}
+		group.cents += cents
+		group.receipts[receipt] = true
+		group.items += items
+	}
+
+	var totalCents int64
+	report := &SpendReport{
+		GroupBy:  filter.GroupBy,
+		FromDate: filter.FromDate,
+		ToDate:   filter.ToDate,
+		MemberID: filter.MemberID,
+		Groups:   []SpendGroup{},
+	}
+	for index, receipt := range receipts {
+		if receipt.Status == ReceiptStatusRejected ||
+			filter.FromDate != "" && receipt.PurchaseDate < filter.FromDate ||
+			filter.ToDate != "" && receipt.PurchaseDate > filter.ToDate ||
+			filter.MemberID != "" && receipt.MemberID != filter.MemberID {
+			continue
+		}
+		cents := amountCents(receipt.Total)
+		totalCents += cents
+		report.Receipts++
+		report.Items += len(receipt.Items)
+
+		switch filter.GroupBy {
+		case SpendByRetailer:
+			key := receipt.CanonicalRetailer
+			if key == "" {
+				key = receipt.Retailer
+			}
+			add(key, 0, cents, index, len(receipt.Items))
+		case SpendByMember:
+			add(receipt.MemberID, 0, cents, index, len(receipt.Items))
+		case SpendByCategory:
+			for _, item := range receipt.Items {
+				itemCents := amountCents(item.PricePaid)
+				category, ok := taxonomy.Category(item.CategoryID)
+				if !ok {
+					add(uncategorizedSpendKey, 0, itemCents, index, 1)
+					continue
+				}
+				for {
+					add(strconv.Itoa(category.ID), category.ID, itemCents, index, 1)
+					if category.ParentID == nil {
+						break
+					}
+					if category, ok = taxonomy.Category(*category.ParentID); !ok {
+						break
+					}
+				}
+			}
+		default:
+			add(periodStart(receipt.PurchaseDate, filter.GroupBy), 0, cents, index, len(receipt.Items))
+		}
+	}
+	report.Total = float64(totalCents) / 100
+
+	for key, group := range groups {
+		report.Groups = append(report.Groups, SpendGroup{
+			Key:        key,
+			CategoryID: group.categoryID,
+			Total:      float64(group.cents) / 100,
+			Receipts:   len(group.receipts),
+			Items:      group.items,
+		})
+	}
+
+	switch filter.GroupBy {
+	case SpendByCategory:
+		report.Groups = labelCategorySpend(report.Groups, taxonomy)
+	case SpendByRetailer, SpendByMember:
+		sort.Slice(report.Groups, func(i, j int) bool {
+			a, b := report.Groups[i], report.Groups[j]
+			return a.Total > b.Total || a.Total == b.Total && a.Key < b.Key
+		})
+	default:
+		sort.Slice(report.Groups, func(i, j int) bool {
+			return report.Groups[i].Key < report.Groups[j].Key
+		})
+	}
+
+	return report, nil
+}
+
+// amountCents reads a receipt amount ("6.49") as a whole number of cents,
+// 0 if it is not a number.
+func amountCents(amount string) int64 {
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(math.Round(value * 100))
+}
+
+// periodStart returns the first day (YYYY-MM-DD) of the day, week (starting
+// on Monday, as Postgres date_trunc does) or month a YYYY-MM-DD date falls
+// in, or the date itself if it cannot be read.
+func periodStart(date, period string) string {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	switch period {
+	case SpendByWeek:
+		day = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case SpendByMonth:
+		day = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day.Format("2006-01-02")
+}
+
+// ErrInvalidPointsPeriod is returned for a points report period that is not
+// one of PointsPeriods.
+var ErrInvalidPointsPeriod = errors.New("invalid points period")
+
+// PointsPeriods are the periods a points report can be grouped by.
+var PointsPeriods = []string{SpendByDay, SpendByWeek, SpendByMonth}
+
+// Points report defaults: the period receipts are grouped by and the width
+// of the histogram buckets.
+const (
+	DefaultPointsPeriod      = SpendByMonth
+	DefaultPointsBucketWidth = 25
+)
+
+// PointsRuleUnattributed stands for the points of receipts stored before
+// points breakdowns were kept.
+const PointsRuleUnattributed = "unattributed"
+
+// PointsFilter narrows a points report. FromDate and ToDate are inclusive
+// YYYY-MM-DD purchase dates; empty fields match everything.
+type PointsFilter struct {
+	FromDate    string
+	ToDate      string
+	Period      string
+	BucketWidth int
+}
+
+// PointsReport is how points were awarded to approved live receipts: in
+// total and on average, per rule, per retailer and per period, and how
+// receipts are distributed by points.
+type PointsReport struct {
+	FromDate  string         `json:"from,omitempty"`
+	ToDate    string         `json:"to,omitempty"`
+	Period    string         `json:"period"`
+	Receipts  int            `json:"receipts"`
+	Points    int            `json:"points"`
+	Average   float64        `json:"average"`
+	Rules     []RulePoints   `json:"rules"`
+	Retailers []PointsGroup  `json:"retailers"`
+	Periods   []PointsGroup  `json:"periods"`
+	Histogram []PointsBucket `json:"histogram"`
+}
+
+// RulePoints is what one rule awarded: its points, how many receipts it
+// awarded any to, and its share of all points (0 to 1).
+type RulePoints struct {
+	Rule     string  `json:"rule"`
+	Points   int     `json:"points"`
+	Receipts int     `json:"receipts"`
+	Share    float64 `json:"share"`
+}
+
+// PointsGroup is the points of the receipts of one retailer (the canonical
+// one, or the printed name without one) or one period (its first day,
+// YYYY-MM-DD), with the points of each rule.
+type PointsGroup struct {
+	Key      string         `json:"key"`
+	Receipts int            `json:"receipts"`
+	Points   int            `json:"points"`
+	Average  float64        `json:"average"`
+	Rules    map[string]int `json:"rules"`
+}
+
+// PointsBucket counts the receipts awarded from Min to Max points,
+// inclusive.
+type PointsBucket struct {
+	Min      int `json:"min"`
+	Max      int `json:"max"`
+	Receipts int `json:"receipts"`
+}
+
+// pointsReceipt is what a points report needs of a receipt.
+type pointsReceipt struct {
+	retailer  string
+	period    string
+	points    int
+	breakdown map[string]uint
+}
+
+// normalizePointsFilter applies the defaults and rejects unknown periods
+// and bucket widths below 1.
+func normalizePointsFilter(filter PointsFilter) (PointsFilter, error) {
+	if filter.Period == "" {
+		filter.Period = DefaultPointsPeriod
+	}
+	if filter.BucketWidth == 0 {
+		filter.BucketWidth = DefaultPointsBucketWidth
+	}
+	if !ValidPointsPeriod(filter.Period) {
+		return filter, fmt.Errorf("%w %q: expected one of %s", ErrInvalidPointsPeriod, filter.Period,
+			strings.Join(PointsPeriods, ", "))
+	}
+	if filter.BucketWidth < 1 {
+		return filter, fmt.Errorf("invalid histogram bucket width %d: expected at least 1", filter.BucketWidth)
+	}
+	return filter, nil
+}
+
+// ValidPointsPeriod reports whether period is one of PointsPeriods.
+func ValidPointsPeriod(period string) bool {
+	for _, valid := range PointsPeriods {
+		if period == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPointsReport reports the points of the approved live receipts
+// matching the filter, aggregating in the database. Pending and rejected
+// receipts are left out, as their points do not count.
+func GetPointsReport(db *pgxpool.Pool, filter PointsFilter) (*PointsReport, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := normalizePointsFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"r.deleted_at IS NULL", "r.status = 'approved'"}
+	args := []any{filter.Period}
+	if filter.FromDate != "" {
+		args = append(args, filter.FromDate)
+		conditions = append(conditions, fmt.Sprintf("r.purchase_date >= $%d::date", len(args)))
+	}
+	if filter.ToDate != "" {
+		args = append(args, filter.ToDate)
+		conditions = append(conditions, fmt.Sprintf("r.purchase_date <= $%d::date", len(args)))
+	}
+
+	// Every receipt's rules, with the points its breakdown does not account
+	// for as unattributed
+	base := `
+		WITH base AS (
+			SELECT r.points,
+				COALESCE((SELECT rt.name FROM retailers rt WHERE rt.id = r.retailer_id), r.retailer) AS retailer,
+				TO_CHAR(date_trunc($1::text, r.purchase_date::timestamp), 'YYYY-MM-DD') AS period,
+				r.points_breakdown
+			FROM receipts r
+			WHERE ` + strings.Join(conditions, " AND ") + `
+		), rule_points AS (
+			SELECT b.retailer, b.period, rule.key AS rule, rule.value::int AS points
+			FROM base b, jsonb_each_text(b.points_breakdown) rule
+			UNION ALL
+			SELECT b.retailer, b.period, '` + PointsRuleUnattributed + `', unattributed.points
+			FROM base b
+			CROSS JOIN LATERAL (
+				SELECT b.points - COALESCE((SELECT SUM(value::int) FROM jsonb_each_text(b.points_breakdown)), 0) AS points
+			) unattributed
+			WHERE unattributed.points > 0
+		)`
+
+	report := &PointsReport{
+		FromDate:  filter.FromDate,
+		ToDate:    filter.ToDate,
+		Period:    filter.Period,
+		Rules:     []RulePoints{},
+		Retailers: []PointsGroup{},
+		Periods:   []PointsGroup{},
+	}
+	retailers := map[string]*PointsGroup{}
+	periods := map[string]*PointsGroup{}
+
+	rows, err := db.Query(ctx, base+`
+		SELECT GROUPING(retailer)::int, GROUPING(period)::int, COALESCE(retailer, ''), COALESCE(period, ''),
+			COUNT(*)::int, COALESCE(SUM(points), 0)::int
+		FROM base
+		GROUP BY GROUPING SETS ((), (retailer), (period))
+	`, args...)
+	if err != nil {
+		config.Log.Error("Failed to total points", zap.Error(err))
+		return nil, err
+	}
+	for rows.Next() {
+		var byRetailer, byPeriod int
+		var retailer, period string
+		group := PointsGroup{Rules: map[string]int{}}
+		if err := rows.Scan(&byRetailer, &byPeriod, &retailer, &period, &group.Receipts, &group.Points); err != nil {
+			rows.Close()
+			config.Log.Error("Failed to scan points group", zap.Error(err))
+			return nil, err
+		}
+		switch {
+		case byRetailer == 0:
+			group.Key = retailer
+			retailers[retailer] = &group
+		case byPeriod == 0:
+			group.Key = period
+			periods[period] = &group
+		default:
+			report.Receipts, report.Points = group.Receipts, group.Points
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate points groups", zap.Error(err))
+		return nil, err
+	}
+
+	rows, err = db.Query(ctx, base+`
+		SELECT GROUPING(retailer)::int, GROUPING(period)::int, COALESCE(retailer, ''), COALESCE(period, ''),
+			rule, SUM(points)::int, COUNT(*) FILTER (WHERE points > 0)::int
+		FROM rule_points
+		GROUP BY GROUPING SETS ((rule), (retailer, rule), (period, rule))
+	`, args...)
+	if err != nil {
+		config.Log.Error("Failed to total rule points", zap.Error(err))
+		return nil, err
+	}
+	for rows.Next() {
+		var byRetailer, byPeriod int
+		var retailer, period string
+		var rule RulePoints
+		if err := rows.Scan(&byRetailer, &byPeriod, &retailer, &period, &rule.Rule, &rule.Points, &rule.Receipts); err != nil {
+			rows.Close()
+			config.Log.Error("Failed to scan rule points", zap.Error(err))
+			return nil, err
+		}
+		switch {
+		case byRetailer == 0:
+			if group, ok := retailers[retailer]; ok {
+				group.Rules[rule.Rule] = rule.Points
+			}
+		case byPeriod == 0:
+			if group, ok := periods[period]; ok {
+				group.Rules[rule.Rule] = rule.Points
+			}
+		default:
+			report.Rules = append(report.Rules, rule)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate rule points", zap.Error(err))
+		return nil, err
+	}
+
+	counts := map[int]int{}
+	rows, err = db.Query(ctx, base+`
+		SELECT (points / $`+strconv.Itoa(len(args)+1)+`::int)::int AS bucket, COUNT(*)::int
+		FROM base
+		GROUP BY bucket
+	`, append(args, filter.BucketWidth)...)
+	if err != nil {
+		config.Log.Error("Failed to bucket points", zap.Error(err))
+		return nil, err
+	}
+	for rows.Next() {
+		var bucket, receipts int
+		if err := rows.Scan(&bucket, &receipts); err != nil {
+			rows.Close()
+			config.Log.Error("Failed to scan points bucket", zap.Error(err))
+			return nil, err
+		}
+		counts[bucket] = receipts
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate points buckets", zap.Error(err))
+		return nil, err
+	}
+
+	finishPointsReport(report, retailers, periods, counts, filter.BucketWidth)
+
+	config.Log.Info("GetPointsReport executed",
+		zap.Int("receipts", report.Receipts),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return report, nil
+}
+
+// PointsReportInMemory is GetPointsReport over receipts already in memory,
+// which are taken to be live; only approved ones count.
+func PointsReportInMemory(receipts []Receipt, filter PointsFilter) (*PointsReport, error) {
+	filter, err := normalizePointsFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PointsReport{
+		FromDate:  filter.FromDate,
+		ToDate:    filter.ToDate,
+		Period:    filter.Period,
+		Rules:     []RulePoints{},
+		Retailers: []PointsGroup{},
+		Periods:   []PointsGroup{},
+	}
+	retailers := map[string]*PointsGroup{}
+	periods := map[string]*PointsGroup{}
+	rules := map[string]*RulePoints{}
+	counts := map[int]int{}
+
+	addTo := func(groups map[string]*PointsGroup, key string, receipt pointsReceipt) {
+		group, ok := groups[key]
+		if !ok {
+			group = &PointsGroup{Key: key, Rules: map[string]int{}}
+			groups[key] = group
+		}
+		group.Receipts++
+		group.Points += receipt.points
+		for rule, points := range receipt.breakdown {
+			group.Rules[rule] += int(points)
+		}
+	}
+
+	for _, r := range receipts {
+		if r.Status != ReceiptStatusApproved ||
+			filter.FromDate != "" && r.PurchaseDate < filter.FromDate ||
+			filter.ToDate != "" && r.PurchaseDate > filter.ToDate {
+			continue
+		}
+
+		receipt := pointsReceipt{
+			retailer:  r.CanonicalRetailer,
+			period:    periodStart(r.PurchaseDate, filter.Period),
+			points:    int(r.Points),
+			breakdown: map[string]uint{},
+		}
+		if receipt.retailer == "" {
+			receipt.retailer = r.Retailer
+		}
+		attributed := 0
+		for rule, points := range r.PointsBreakdown {
+			receipt.breakdown[rule] = points
+			attributed += int(points)
+		}
+		if unattributed := receipt.points - attributed; unattributed > 0 {
+			receipt.breakdown[PointsRuleUnattributed] = uint(unattributed)
+		}
+
+		report.Receipts++
+		report.Points += receipt.points
+		addTo(retailers, receipt.retailer, receipt)
+		addTo(periods, receipt.period, receipt)
+		for rule, points := range receipt.breakdown {
+			total, ok := rules[rule]
+			if !ok {
+				total = &RulePoints{Rule: rule}
+				rules[rule] = total
+			}
+			total.Points += int(points)
+			if points > 0 {
+				total.Receipts++
+			}
+		}
+		counts[receipt.points/filter.BucketWidth]++
+	}
+
+	for _, rule := range rules {
+		report.Rules = append(report.Rules, *rule)
+	}
+	finishPointsReport(report, retailers, periods, counts, filter.BucketWidth)
+
+	return report, nil
+}
+
+// finishPointsReport fills in the averages, shares and histogram of a
+// report from its totals and orders its lists: rules and retailers by
+// points, most first, and periods by date. The histogram runs from 0 to
+// the bucket of the most points, empty buckets included.
+func finishPointsReport(report *PointsReport, retailers, periods map[string]*PointsGroup, counts map[int]int, bucketWidth int) {
+	average := func(points, receipts int) float64 {
+		if receipts == 0 {
+			return 0
+		}
+		return config.RoundToNearestCent(float64(points) / float64(receipts))
+	}
+
+	report.Average = average(report.Points, report.Receipts)
+	for i := range report.Rules {
+		if report.Points > 0 {
+			report.Rules[i].Share = math.Round(float64(report.Rules[i].Points)/float64(report.Points)*10000) / 10000
+		}
+	}
+	sort.Slice(report.Rules, func(i, j int) bool {
+		a, b := report.Rules[i], report.Rules[j]
+		return a.Points > b.Points || a.Points == b.Points && a.Rule < b.Rule
+	})
+
+	for _, group := range retailers {
+		group.Average = average(group.Points, group.Receipts)
+		report.Retailers = append(report.Retailers, *group)
+	}
+	sort.Slice(report.Retailers, func(i, j int) bool {
+		a, b := report.Retailers[i], report.Retailers[j]
+		return a.Points > b.Points || a.Points == b.Points && a.Key < b.Key
+	})
+
+	for _, group := range periods {
+		group.Average = average(group.Points, group.Receipts)
+		report.Periods = append(report.Periods, *group)
+	}
+	sort.Slice(report.Periods, func(i, j int) bool {
+		return report.Periods[i].Key < report.Periods[j].Key
+	})
+
+	last := -1
+	for bucket := range counts {
+		if bucket > last {
+			last = bucket
+		}
+	}
+	report.Histogram = []PointsBucket{}
+	for bucket := 0; bucket <= last; bucket++ {
+		report.Histogram = append(report.Histogram, PointsBucket{
+			Min:      bucket * bucketWidth,
+			Max:      (bucket+1)*bucketWidth - 1,
+			Receipts: counts[bucket],
+		})
+	}
+}
+
+// pointsBackfillBatchSize is how many receipts BackfillPoints updates per
+// transaction.
+const pointsBackfillBatchSize = 500
+
+// PointsBackfill counts what BackfillPoints changed: receipts whose
+// breakdown was filled in, receipts whose points were recalculated, and
+// receipts left alone because their points no longer add up under the
+// rules.
+type PointsBackfill struct {
+	Filled       int64 `json:"filled"`
+	Recalculated int64 `json:"recalculated"`
+	Mismatched   int64 `json:"mismatched"`
+}
+
+// BackfillPoints calculates with rules the points breakdown of the stored
+// receipts without one, those stored before breakdowns were kept, deleted
+// ones included so a restore brings theirs back. The breakdown is only
+// stored when it adds up to the points the receipt was awarded; a receipt
+// awarded its points under other rules keeps counting them as
+// unattributed. With recalculate, every receipt's points are calculated
+// again with rules instead; changed points are audited as recalculations
+// and make a new version of the receipt, as UpdateReceipt does.
+func BackfillPoints(db *pgxpool.Pool, rules config.PointsRules, recalculate bool) (PointsBackfill, error) {
+	startTime := time.Now()
+
+	var backfill PointsBackfill
+	after := uuid.Nil
+	for {
+		last, count, err := backfillPointsBatch(db, rules, recalculate, after, &backfill)
+		if err != nil {
+			return backfill, err
+		}
+		if count < pointsBackfillBatchSize {
+			break
+		}
+		after = last
+	}
+
+	config.Log.Info("BackfillPoints executed",
+		zap.Int64("filled", backfill.Filled),
+		zap.Int64("recalculated", backfill.Recalculated),
+		zap.Int64("mismatched", backfill.Mismatched),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return backfill, nil
+}
+
+// backfillPointsBatch backfills the next receipts by ID after after,
+// returning the last ID it read and how many receipts it read.
+func backfillPointsBatch(db *pgxpool.Pool, rules config.PointsRules, recalculate bool, after uuid.UUID, backfill *PointsBackfill) (uuid.UUID, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return after, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT `+receiptColumns+`
+		FROM receipts
+		WHERE id > $1 AND ($2 OR points_breakdown = '{}')
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE
+	`, after, recalculate, pointsBackfillBatchSize)
+	if err != nil {
+		config.Log.Error("Failed to select receipts to backfill", zap.Error(err))
+		return after, 0, err
+	}
+	defer rows.Close()
+
+	receipts := []Receipt{}
+	var receiptIDs []string
+	for rows.Next() {
+		var row receiptRow
+		if err := rows.Scan(row.targets()...); err != nil {
+			config.Log.Error("Failed to scan receipt to backfill", zap.Error(err))
+			return after, 0, err
+		}
+		receipts = append(receipts, row.result())
+		receiptIDs = append(receiptIDs, row.receipt.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate receipts to backfill", zap.Error(err))
+		return after, 0, err
+	}
+	rows.Close()
+	if len(receipts) == 0 {
+		return after, 0, nil
+	}
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, tx, receiptIDs)
+	if err != nil {
+		return after, 0, err
+	}
+
+	var counts PointsBackfill
+	batch := &pgx.Batch{}
+	for i := range receipts {
+		receipt := &receipts[i]
+		receipt.Items = itemsByReceipt[receipt.ID]
+		previous := *receipt
+
+		receipt.CalculatePointsWithRules(rules)
+		switch {
+		case receipt.Points == previous.Points && maps.Equal(receipt.PointsBreakdown, previous.PointsBreakdown):
+			continue
+		case receipt.Points == previous.Points:
+			counts.Filled++
+			batch.Queue(`UPDATE receipts SET points_breakdown = $2 WHERE id = $1`,
+				receipt.ID, receipt.PointsBreakdown)
+			continue
+		case !recalculate:
+			counts.Mismatched++
+			continue
+		}
+
+		// Recalculated points make a new version of the receipt, as an
+		// update does
+		snapshot, err := json.Marshal(previous)
+		if err != nil {
+			config.Log.Error("Failed to encode receipt snapshot", zap.Error(err))
+			return after, 0, err
+		}
+		counts.Recalculated++
+		batch.Queue(`
+			INSERT INTO receipt_versions (receipt_id, version, snapshot)
+			VALUES ($1, $2, $3)
+		`, previous.ID, previous.Version, snapshot)
+		batch.Queue(`
+			UPDATE receipts SET points = $2, points_breakdown = $3, version = version + 1, updated_at = now()
+			WHERE id = $1
+		`, receipt.ID, receipt.Points, receipt.PointsBreakdown)
+		queueAuditEntry(batch, receipt.ID, AuditActionPointsRecalculated, AuditInfo{}, map[string]AuditChange{
+			"points": {From: previous.Points, To: receipt.Points},
+		})
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		config.Log.Error("Failed to backfill points", zap.Error(err))
+		return after, 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return after, 0, err
+	}
+
+	backfill.Filled += counts.Filled
+	backfill.Recalculated += counts.Recalculated
+	backfill.Mismatched += counts.Mismatched
+	return receipts[len(receipts)-1].ID, len(receipts), nil
+}
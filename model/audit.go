@@ -0,0 +1,166 @@
+// model/audit.go
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Audited actions
+const (
+	AuditActionCreate             = "create"
+	AuditActionUpdate             = "update"
+	AuditActionDelete             = "delete"
+	AuditActionRestore            = "restore"
+	AuditActionReview             = "review"
+	AuditActionPointsRecalculated = "points_recalculated"
+	AuditActionRelink             = "relink"
+	AuditActionPurge              = "purge"
+)
+
+// AuditActorSystem is recorded for changes nobody in particular asked for,
+// such as the retention purge.
+const AuditActorSystem = "system"
+
+// AuditInfo identifies who made a change and in which request.
+type AuditInfo struct {
+	Actor     string
+	RequestID string
+}
+
+// AuditChange is the before and after value of one changed field.
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// AuditEntry is one entry of a receipt's audit log.
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	ReceiptID uuid.UUID              `json:"receiptID"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	RequestID string                 `json:"requestID,omitempty"`
+	Diff      map[string]AuditChange `json:"diff"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+const insertAuditEntrySQL = `
+	INSERT INTO receipt_audit (receipt_id, action, actor, request_id, diff)
+	VALUES ($1, $2, $3, $4, $5)
+`
+
+// auditEntryArgs returns the arguments of insertAuditEntrySQL, so entries
+// can be queued on a batch or executed in a transaction alike.
+func auditEntryArgs(receiptID uuid.UUID, action string, audit AuditInfo, diff map[string]AuditChange) []any {
+	actor := audit.Actor
+	if actor == "" {
+		actor = AuditActorSystem
+	}
+	if diff == nil {
+		diff = map[string]AuditChange{}
+	}
+	return []any{receiptID, action, actor, audit.RequestID, diff}
+}
+
+// receiptDiff lists the fields of the receipt JSON that differ between
+// before and after; a nil before diffs against nothing (creation). Item IDs
+// are left out since items are re-inserted on every update.
+func receiptDiff(before, after *Receipt) map[string]AuditChange {
+	from, to := auditFields(before), auditFields(after)
+
+	diff := map[string]AuditChange{}
+	for field, value := range to {
+		if previous, ok := from[field]; !ok || !reflect.DeepEqual(previous, value) {
+			diff[field] = AuditChange{From: from[field], To: value}
+		}
+	}
+	for field, previous := range from {
+		if _, ok := to[field]; !ok {
+			diff[field] = AuditChange{From: previous}
+		}
+	}
+	return diff
+}
+
+func auditFields(receipt *Receipt) map[string]any {
+	fields := map[string]any{}
+	if receipt == nil {
+		return fields
+	}
+
+	encoded, err := json.Marshal(receipt)
+	if err == nil {
+		err = json.Unmarshal(encoded, &fields)
+	}
+	if err != nil {
+		config.Log.Error("Failed to encode receipt for audit", zap.Error(err))
+		return fields
+	}
+
+	// SKU conflicts describe the submission, not the stored receipt
+	delete(fields, "skuConflicts")
+	// The canonical name follows retailerID, and changes with the retailer;
+	// the breakdown follows the points, whose changes are audited themselves
+	delete(fields, "canonicalRetailer")
+	delete(fields, "pointsBreakdown")
+
+	if items, ok := fields["items"].([]any); ok {
+		for _, item := range items {
+			if item, ok := item.(map[string]any); ok {
+				delete(item, "id")
+				delete(item, "receiptID")
+			}
+		}
+	}
+	return fields
+}
+
+// GetReceiptHistory returns the audit log of a receipt, oldest first. The
+// log outlives the receipt, so it is available for deleted and purged
+// receipts too.
+func GetReceiptHistory(db *pgxpool.Pool, id uuid.UUID) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT id, receipt_id, action, actor, request_id, diff, created_at
+		FROM receipt_audit
+		WHERE receipt_id = $1
+		ORDER BY id
+	`, id)
+	if err != nil {
+		config.Log.Error("Failed to retrieve receipt history", zap.String("id", id.String()), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(&entry.ID, &entry.ReceiptID, &entry.Action, &entry.Actor,
+			&entry.RequestID, &entry.Diff, &entry.CreatedAt)
+		if err != nil {
+			config.Log.Error("Failed to scan audit entry", zap.Error(err))
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// queueAuditEntry queues an audit log entry onto batch.
+func queueAuditEntry(batch *pgx.Batch, receiptID uuid.UUID, action string, audit AuditInfo, diff map[string]AuditChange) {
+	batch.Queue(insertAuditEntrySQL, auditEntryArgs(receiptID, action, audit, diff)...)
+}
@@ -0,0 +1,79 @@
+// model/batch.go
+
+package model
+
+import (
+	"context"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// AddReceiptsChunkSize is how many receipts AddReceipts stores per
+// transaction / round trip.
+const AddReceiptsChunkSize = 250
+
+// AddReceipts stores many receipts efficiently and reports the outcome of
+// each one: the returned slice has one entry per receipt, nil on success.
+//
+// Receipts are written in chunks of AddReceiptsChunkSize, each chunk as a
+// single pipelined batch inside one transaction. If a chunk fails, its
+// receipts are retried one at a time so a single bad receipt does not
+// take the rest of the chunk down with it. Every creation is audited as audit.
+func AddReceipts(db *pgxpool.Pool, receipts []*Receipt, audit AuditInfo) []error {
+	startTime := time.Now()
+	errs := make([]error, len(receipts))
+
+	for start := 0; start < len(receipts); start += AddReceiptsChunkSize {
+		end := start + AddReceiptsChunkSize
+		if end > len(receipts) {
+			end = len(receipts)
+		}
+		chunk := receipts[start:end]
+
+		if err := addReceiptChunk(db, chunk, audit); err != nil {
+			config.Log.Warn("Batch insert failed, retrying receipts individually",
+				zap.Int("chunkStart", start), zap.Int("chunkSize", len(chunk)), zap.Error(err))
+
+			for i, receipt := range chunk {
+				errs[start+i] = AddReceipt(db, receipt, audit)
+			}
+		}
+	}
+
+	executionTime := time.Since(startTime)
+	config.Log.Info("AddReceipts executed",
+		zap.Int("receipts", len(receipts)),
+		zap.Duration("duration", executionTime))
+
+	return errs
+}
+
+func addReceiptChunk(db *pgxpool.Pool, receipts []*Receipt, audit AuditInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, receipt := range receipts {
+		if err := queueReceiptInserts(batch, receipt, audit); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,312 @@
+// model/catalog.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// SKUFilter narrows a catalog listing. Empty fields match every SKU; every
+// attribute given must be present on the SKU with exactly that value.
+type SKUFilter struct {
+	Prefix          string
+	ProductCategory string
+	Manufacturer    string
+	Attributes      map[string]string
+	// GTIN, as a GTIN-14, matches SKUs bought under that barcode
+	GTIN string
+}
+
+// SKUKey identifies a stored SKU: the same unique identifier under two
+// prefixes is two SKUs.
+type SKUKey struct {
+	Prefix           string `json:"prefix"`
+	UniqueIdentifier string `json:"uniqueIdentifier"`
+}
+
+// CatalogSKU is a stored SKU along with how often it was bought on receipts
+// that are not deleted. Version counts the definitions the SKU went through
+// under the version conflict policy; ProductID is the product it sells.
+type CatalogSKU struct {
+	UniqueIdentifier string            `json:"uniqueIdentifier"`
+	Prefix           string            `json:"prefix"`
+	ProductCategory  string            `json:"productCategory"`
+	Manufacturer     string            `json:"manufacturer"`
+	ProductLine      string            `json:"productLine"`
+	Attributes       map[string]string `json:"attributes"`
+	Format           string            `json:"format,omitempty"`
+	Raw              string            `json:"raw,omitempty"`
+	Version          int               `json:"version"`
+	ProductID        *uuid.UUID        `json:"productID,omitempty"`
+	GTINs            []string          `json:"gtins"`
+	Receipts         int               `json:"receipts"`
+	Quantity         int               `json:"quantity"`
+}
+
+// CatalogGTIN is a barcode number in its GTIN-14, UPC-A and EAN-13 forms,
+// along with how often it was bought on receipts that are not deleted and
+// the SKUs it was bought under.
+type CatalogGTIN struct {
+	GTIN     string   `json:"gtin"`
+	UPCA     string   `json:"upcA,omitempty"`
+	EAN13    string   `json:"ean13,omitempty"`
+	SKUs     []SKUKey `json:"skus"`
+	Receipts int      `json:"receipts"`
+	Quantity int      `json:"quantity"`
+}
+
+const catalogSKUColumns = `
+	s.unique_identifier, s.prefix, s.product_category, s.manufacturer, s.product_line,
+	COALESCE(s.attributes, '{}'::jsonb), COALESCE(s.format, ''), COALESCE(s.raw, ''),
+	s.version, s.product_id, COALESCE(bought.gtins, '{}'), COALESCE(bought.receipts, 0), COALESCE(bought.quantity, 0)`
+
+// catalogSKUJoin counts the purchases of each SKU on live receipts.
+const catalogSKUJoin = `
+	LEFT JOIN LATERAL (
+		SELECT COUNT(DISTINCT i.receipt_id)::int AS receipts, SUM(i.quantity)::int AS quantity,
+			array_agg(DISTINCT i.gtin ORDER BY i.gtin) FILTER (WHERE i.gtin IS NOT NULL) AS gtins
+		FROM items i
+		JOIN receipts r ON r.id = i.receipt_id AND r.deleted_at IS NULL
+		WHERE i.sku_prefix = s.prefix AND i.sku_id = s.unique_identifier
+	) bought ON true`
+
+func (s *CatalogSKU) targets() []any {
+	return []any{&s.UniqueIdentifier, &s.Prefix, &s.ProductCategory, &s.Manufacturer,
+		&s.ProductLine, &s.Attributes, &s.Format, &s.Raw, &s.Version, &s.ProductID, &s.GTINs, &s.Receipts, &s.Quantity}
+}
+
+// whereClause builds the WHERE clause and positional arguments for the
+// filter. Attributes are matched by JSONB containment.
+func (f SKUFilter) whereClause() (string, []any) {
+	var conditions []string
+	var args []any
+
+	if f.Prefix != "" {
+		args = append(args, f.Prefix)
+		conditions = append(conditions, fmt.Sprintf("s.prefix = $%d", len(args)))
+	}
+	if f.ProductCategory != "" {
+		args = append(args, f.ProductCategory)
+		conditions = append(conditions, fmt.Sprintf("s.product_category = $%d", len(args)))
+	}
+	if f.Manufacturer != "" {
+		args = append(args, f.Manufacturer)
+		conditions = append(conditions, fmt.Sprintf("s.manufacturer = $%d", len(args)))
+	}
+	if len(f.Attributes) > 0 {
+		args = append(args, f.Attributes)
+		conditions = append(conditions, fmt.Sprintf("s.attributes @> $%d::jsonb", len(args)))
+	}
+	if f.GTIN != "" {
+		args = append(args, f.GTIN)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM items i WHERE i.sku_prefix = s.prefix AND i.sku_id = s.unique_identifier AND i.gtin = $%d)", len(args)))
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ListSKUs returns one page of the SKU catalog matching filter, ordered by
+// prefix and unique identifier, along with the total number of matches.
+func ListSKUs(db *pgxpool.Pool, filter SKUFilter, limit, offset int) ([]CatalogSKU, int, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	whereClause, args := filter.whereClause()
+	query := fmt.Sprintf(`
+		SELECT %s, COUNT(*) OVER ()
+		FROM skus s
+		%s
+		%s
+		ORDER BY s.prefix, s.unique_identifier
+		LIMIT $%d OFFSET $%d
+	`, catalogSKUColumns, catalogSKUJoin, whereClause, len(args)+1, len(args)+2)
+
+	rows, err := db.Query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		config.Log.Error("Failed to list SKUs", zap.Error(err))
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	skus := []CatalogSKU{}
+	total := 0
+	for rows.Next() {
+		var sku CatalogSKU
+		if err := rows.Scan(append(sku.targets(), &total)...); err != nil {
+			config.Log.Error("Failed to scan SKU", zap.Error(err))
+			return nil, 0, err
+		}
+		skus = append(skus, sku)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate SKUs", zap.Error(err))
+		return nil, 0, err
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(skus) == 0 && offset > 0 {
+		whereClause, args := filter.whereClause()
+		if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM skus s `+whereClause, args...).Scan(&total); err != nil {
+			config.Log.Error("Failed to count SKUs", zap.Error(err))
+			return nil, 0, err
+		}
+	}
+
+	config.Log.Info("ListSKUs executed",
+		zap.Int("skus", len(skus)),
+		zap.Int("total", total),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return skus, total, nil
+}
+
+// GetSKU returns a catalog SKU by its prefix and unique identifier, or
+// pgx.ErrNoRows if it was never seen on a receipt.
+func GetSKU(db *pgxpool.Pool, prefix, uniqueIdentifier string) (*CatalogSKU, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var sku CatalogSKU
+	err := db.QueryRow(ctx, `
+		SELECT `+catalogSKUColumns+`
+		FROM skus s
+		`+catalogSKUJoin+`
+		WHERE s.prefix = $1 AND s.unique_identifier = $2
+	`, prefix, uniqueIdentifier).Scan(sku.targets()...)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to retrieve SKU",
+				zap.String("prefix", prefix), zap.String("sku", uniqueIdentifier), zap.Error(err))
+		}
+		return nil, err
+	}
+
+	return &sku, nil
+}
+
+// GetReceiptsForSKU returns one page of the receipts that bought a SKU,
+// newest first, with all of their items, along with the total number of
+// such receipts. Deleted receipts are left out.
+func GetReceiptsForSKU(db *pgxpool.Pool, prefix, uniqueIdentifier string, limit, offset int) ([]Receipt, int, error) {
+	return getReceiptsWithItems(db, "sku_prefix = $1 AND sku_id = $2", []any{prefix, uniqueIdentifier}, limit, offset)
+}
+
+// GetReceiptsForGTIN is GetReceiptsForSKU for the receipts that bought a
+// GTIN-14, with or without a SKU.
+func GetReceiptsForGTIN(db *pgxpool.Pool, gtin string, limit, offset int) ([]Receipt, int, error) {
+	return getReceiptsWithItems(db, "gtin = $1", []any{gtin}, limit, offset)
+}
+
+// GetGTIN returns a GTIN-14 with its purchases, or pgx.ErrNoRows if it was
+// never bought on a receipt that is not deleted.
+func GetGTIN(db *pgxpool.Pool, gtin string) (*CatalogGTIN, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	product := CatalogGTIN{GTIN: gtin}
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT i.receipt_id)::int, COALESCE(SUM(i.quantity), 0)::int,
+			COALESCE(jsonb_agg(DISTINCT jsonb_build_object('prefix', i.sku_prefix, 'uniqueIdentifier', i.sku_id))
+				FILTER (WHERE i.sku_id IS NOT NULL), '[]')
+		FROM items i
+		JOIN receipts r ON r.id = i.receipt_id AND r.deleted_at IS NULL
+		WHERE i.gtin = $1
+	`, gtin).Scan(&product.Receipts, &product.Quantity, &product.SKUs)
+	if err != nil {
+		config.Log.Error("Failed to retrieve GTIN", zap.String("gtin", gtin), zap.Error(err))
+		return nil, err
+	}
+	if product.Receipts == 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	product.UPCA, _ = GTINToUPCA(gtin)
+	product.EAN13, _ = GTINToEAN13(gtin)
+	return &product, nil
+}
+
+// getReceiptsWithItems returns one page of the live receipts having an item
+// matching itemCondition (trusted SQL over the items columns, with args as
+// its parameters), newest first.
+func getReceiptsWithItems(db *pgxpool.Pool, itemCondition string, args []any, limit, offset int) ([]Receipt, int, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	matching := `
+		FROM receipts
+		WHERE deleted_at IS NULL
+			AND id IN (SELECT receipt_id FROM items WHERE ` + itemCondition + `)`
+
+	rows, err := db.Query(ctx, fmt.Sprintf(`
+		SELECT %s, COUNT(*) OVER ()
+		%s
+		ORDER BY created_at DESC, id
+		LIMIT $%d OFFSET $%d
+	`, receiptColumns, matching, len(args)+1, len(args)+2), append(args, limit, offset)...)
+	if err != nil {
+		config.Log.Error("Failed to retrieve receipts", zap.Any("match", args), zap.Error(err))
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	receipts := []Receipt{}
+	var receiptIDs []string
+	total := 0
+	for rows.Next() {
+		var row receiptRow
+		if err := rows.Scan(append(row.targets(), &total)...); err != nil {
+			config.Log.Error("Failed to scan receipt", zap.Error(err))
+			return nil, 0, err
+		}
+
+		receipt := row.result()
+		receipts = append(receipts, receipt)
+		receiptIDs = append(receiptIDs, receipt.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate receipts", zap.Error(err))
+		return nil, 0, err
+	}
+	rows.Close()
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, db, receiptIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range receipts {
+		receipts[i].Items = itemsByReceipt[receipts[i].ID]
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(receipts) == 0 && offset > 0 {
+		if err := db.QueryRow(ctx, `SELECT COUNT(*) `+matching, args...).Scan(&total); err != nil {
+			config.Log.Error("Failed to count receipts", zap.Any("match", args), zap.Error(err))
+			return nil, 0, err
+		}
+	}
+
+	config.Log.Info("getReceiptsWithItems executed",
+		zap.Any("match", args),
+		zap.Int("receipts", len(receipts)),
+		zap.Int("total", total),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return receipts, total, nil
+}
@@ -0,0 +1,399 @@
+// model/category.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrUnknownCategory is returned when a category ID, e.g. a parent or a
+	// mapping's target, does not exist.
+	ErrUnknownCategory = errors.New("unknown category")
+	// ErrDuplicateCategory is returned for a category named like one of its
+	// siblings.
+	ErrDuplicateCategory = errors.New("a category with this name already exists under the same parent")
+)
+
+// taxonomyCacheTTL bounds how long category changes made by another server
+// take to apply to new receipts; changes made through this one apply at once.
+const taxonomyCacheTTL = time.Minute
+
+// Category is a node of the category taxonomy, e.g. Chips under Snacks
+// under Food. Keywords are lowercase words or phrases that classify an item
+// description into the category. Path holds the names from the root down.
+type Category struct {
+	ID       int         `json:"id"`
+	ParentID *int        `json:"parentID,omitempty"`
+	Name     string      `json:"name"`
+	Keywords []string    `json:"keywords"`
+	Path     []string    `json:"path"`
+	Children []*Category `json:"children,omitempty"`
+}
+
+// Taxonomy is the category tree with the SKU category code mappings and the
+// keyword classifier built from it. It is read-only once built.
+type Taxonomy struct {
+	categories map[int]*Category
+	roots      []*Category
+	mappings   map[string]int
+	keywords   []categoryKeyword
+}
+
+type categoryKeyword struct {
+	words      []string
+	categoryID int
+	depth      int
+}
+
+// NewTaxonomy builds a taxonomy from its categories and the mappings from
+// SKU category codes to category IDs. A category whose parent is missing is
+// treated as a root.
+func NewTaxonomy(categories []Category, mappings map[string]int) *Taxonomy {
+	t := &Taxonomy{
+		categories: map[int]*Category{},
+		mappings:   map[string]int{},
+	}
+
+	for i := range categories {
+		category := categories[i]
+		category.Children = nil
+		t.categories[category.ID] = &category
+	}
+	ids := make([]int, 0, len(t.categories))
+	for id := range t.categories {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		category := t.categories[id]
+		if category.ParentID != nil {
+			if parent, ok := t.categories[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, category)
+				continue
+			}
+		}
+		t.roots = append(t.roots, category)
+	}
+
+	var walk func(category *Category, path []string)
+	walk = func(category *Category, path []string) {
+		category.Path = append(append([]string{}, path...), category.Name)
+		for _, keyword := range category.Keywords {
+			if words := searchWords(keyword); len(words) > 0 {
+				t.keywords = append(t.keywords, categoryKeyword{
+					words:      words,
+					categoryID: category.ID,
+					depth:      len(category.Path),
+				})
+			}
+		}
+		for _, child := range category.Children {
+			walk(child, category.Path)
+		}
+	}
+	for _, root := range t.roots {
+		walk(root, nil)
+	}
+
+	for code, id := range mappings {
+		if _, ok := t.categories[id]; ok {
+			t.mappings[code] = id
+		}
+	}
+	return t
+}
+
+// Tree returns the root categories, each with its children.
+func (t *Taxonomy) Tree() []*Category {
+	return t.roots
+}
+
+// Category returns a category by ID.
+func (t *Taxonomy) Category(id int) (*Category, bool) {
+	category, ok := t.categories[id]
+	return category, ok
+}
+
+// CategoryFor returns the category of an item: the one its SKU's category
+// code maps to, or else the one its description classifies into.
+func (t *Taxonomy) CategoryFor(item Item) (int, bool) {
+	if !item.SKU.IsZero() && item.SKU.ProductCategory != "" {
+		if id, ok := t.mappings[item.SKU.ProductCategory]; ok {
+			return id, true
+		}
+	}
+	return t.Classify(item.ShortDescription)
+}
+
+// Classify returns the category whose keyword appears in the description.
+// Keywords match whole words, in order for phrases, and also match plurals
+// ending in s or es. When several match, the longest phrase wins, then the
+// deepest category, then the keyword found first in the description.
+func (t *Taxonomy) Classify(description string) (int, bool) {
+	words := searchWords(description)
+
+	best, bestAt := -1, 0
+	for i, keyword := range t.keywords {
+		at := findKeyword(words, keyword.words)
+		if at < 0 {
+			continue
+		}
+		if best >= 0 {
+			current := t.keywords[best]
+			if len(keyword.words) < len(current.words) ||
+				len(keyword.words) == len(current.words) && keyword.depth < current.depth ||
+				len(keyword.words) == len(current.words) && keyword.depth == current.depth && at >= bestAt {
+				continue
+			}
+		}
+		best, bestAt = i, at
+	}
+
+	if best < 0 {
+		return 0, false
+	}
+	return t.keywords[best].categoryID, true
+}
+
+// findKeyword returns where the keyword's words first appear in words, in
+// order, or -1.
+func findKeyword(words, keyword []string) int {
+	for start := 0; start+len(keyword) <= len(words); start++ {
+		matched := true
+		for i, word := range keyword {
+			if !keywordMatches(words[start+i], word) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return start
+		}
+	}
+	return -1
+}
+
+func keywordMatches(word, keyword string) bool {
+	return word == keyword || word == keyword+"s" || word == keyword+"es"
+}
+
+// normalizeKeywords lowercases keywords, splits them into words the way
+// descriptions are and drops empty and repeated ones.
+func normalizeKeywords(keywords []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, keyword := range keywords {
+		keyword = strings.Join(searchWords(keyword), " ")
+		if keyword != "" && !seen[keyword] {
+			seen[keyword] = true
+			normalized = append(normalized, keyword)
+		}
+	}
+	return normalized
+}
+
+var taxonomyCache struct {
+	sync.Mutex
+	taxonomy *Taxonomy
+	loadedAt time.Time
+}
+
+// GetTaxonomy returns the category taxonomy, loading it from the database
+// at most once per taxonomyCacheTTL.
+func GetTaxonomy(db *pgxpool.Pool) (*Taxonomy, error) {
+	taxonomyCache.Lock()
+	defer taxonomyCache.Unlock()
+
+	if taxonomyCache.taxonomy != nil && time.Since(taxonomyCache.loadedAt) < taxonomyCacheTTL {
+		return taxonomyCache.taxonomy, nil
+	}
+
+	taxonomy, err := loadTaxonomy(db)
+	if err != nil {
+		return nil, err
+	}
+	taxonomyCache.taxonomy, taxonomyCache.loadedAt = taxonomy, time.Now()
+	return taxonomy, nil
+}
+
+// invalidateTaxonomy makes the next GetTaxonomy reload the taxonomy.
+func invalidateTaxonomy() {
+	taxonomyCache.Lock()
+	defer taxonomyCache.Unlock()
+	taxonomyCache.taxonomy = nil
+}
+
+func loadTaxonomy(db *pgxpool.Pool) (*Taxonomy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `SELECT id, parent_id, name, keywords FROM categories ORDER BY id`)
+	if err != nil {
+		config.Log.Error("Failed to load categories", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.ParentID, &category.Name, &category.Keywords); err != nil {
+			config.Log.Error("Failed to scan category", zap.Error(err))
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate categories", zap.Error(err))
+		return nil, err
+	}
+	rows.Close()
+
+	mappings, err := GetCategoryMappings(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTaxonomy(categories, mappings), nil
+}
+
+// CategorizeItems sets the category of every item of the receipt. Should
+// the taxonomy fail to load, the items are left uncategorized and the
+// failure logged rather than failing the receipt.
+func CategorizeItems(db *pgxpool.Pool, receipt *Receipt) {
+	taxonomy, err := GetTaxonomy(db)
+	if err != nil {
+		config.Log.Error("Failed to categorize items", zap.String("id", receipt.ID.String()), zap.Error(err))
+		for i := range receipt.Items {
+			receipt.Items[i].CategoryID = 0
+		}
+		return
+	}
+
+	for i := range receipt.Items {
+		receipt.Items[i].CategoryID, _ = taxonomy.CategoryFor(receipt.Items[i])
+	}
+}
+
+// CreateCategory stores a new category, under category.ParentID unless it
+// is nil, and sets its ID. Its keywords classify receipts submitted from
+// then on.
+func CreateCategory(db *pgxpool.Pool, category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	category.Keywords = normalizeKeywords(category.Keywords)
+	err := db.QueryRow(ctx, `
+		INSERT INTO categories (parent_id, name, keywords)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, category.ParentID, category.Name, category.Keywords).Scan(&category.ID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrUnknownCategory
+		} else if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrDuplicateCategory
+		}
+		config.Log.Error("Failed to create category", zap.String("name", category.Name), zap.Error(err))
+		return err
+	}
+
+	invalidateTaxonomy()
+	config.Log.Info("Category created", zap.Int("id", category.ID), zap.String("name", category.Name))
+	return nil
+}
+
+// GetCategoryMappings returns the category ID each SKU category code maps
+// to.
+func GetCategoryMappings(db *pgxpool.Pool) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `SELECT code, category_id FROM category_mappings`)
+	if err != nil {
+		config.Log.Error("Failed to load category mappings", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := map[string]int{}
+	for rows.Next() {
+		var code string
+		var id int
+		if err := rows.Scan(&code, &id); err != nil {
+			config.Log.Error("Failed to scan category mapping", zap.Error(err))
+			return nil, err
+		}
+		mappings[code] = id
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate category mappings", zap.Error(err))
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+// SetCategoryMapping maps a SKU category code to a category and moves the
+// stored items of SKUs with that code into it, returning how many were
+// moved.
+func SetCategoryMapping(db *pgxpool.Pool, code string, categoryID int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO category_mappings (code, category_id) VALUES ($1, $2)
+		ON CONFLICT (code) DO UPDATE SET category_id = EXCLUDED.category_id
+	`, code, categoryID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return 0, ErrUnknownCategory
+		}
+		config.Log.Error("Failed to set category mapping", zap.String("code", code), zap.Error(err))
+		return 0, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE items i SET category_id = $2
+		FROM skus s
+		WHERE s.prefix = i.sku_prefix AND s.unique_identifier = i.sku_id AND s.product_category = $1
+			AND i.category_id IS DISTINCT FROM $2
+	`, code, categoryID)
+	if err != nil {
+		config.Log.Error("Failed to recategorize items", zap.String("code", code), zap.Error(err))
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return 0, err
+	}
+
+	invalidateTaxonomy()
+	config.Log.Info("Category mapping set",
+		zap.String("code", code),
+		zap.Int("categoryID", categoryID),
+		zap.Int64("recategorized", tag.RowsAffected()))
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,155 @@
+// model/csv.go
+
+package model
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// CSVHeader is the column layout of the flat receipt export. The order is
+// part of the export contract: new columns are only ever appended.
+var CSVHeader = []string{
+	"receipt_id",
+	"retailer",
+	"purchase_date",
+	"purchase_time",
+	"total",
+	"points",
+	"created_at",
+	"item_id",
+	"short_description",
+	"quantity",
+	"price_paid",
+	"sku",
+	"sku_prefix",
+	"sku_product_category",
+	"sku_manufacturer",
+	"sku_product_line",
+	"sku_attributes",
+	"sku_unique_identifier",
+	"member_id",
+	"fraud_score",
+	"flagged",
+	"status",
+	"gtin",
+	"export_position",
+}
+
+// csvItemColumns is the number of item columns between the receipt columns
+// and the trailing receipt columns appended since.
+const csvItemColumns = 11
+
+// CSVRows flattens a receipt into one row per item, repeating the receipt
+// columns on every row. A receipt without items still yields a single row
+// so it is not lost from the export.
+func (r ExportedReceipt) CSVRows() [][]string {
+	receiptColumns := []string{
+		r.ID.String(),
+		r.Retailer,
+		r.PurchaseDate,
+		r.PurchaseTime,
+		r.Total,
+		strconv.FormatUint(uint64(r.Points), 10),
+		r.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+
+	var fraudScore, flagged = "0", "false"
+	if r.Fraud != nil {
+		fraudScore = strconv.FormatFloat(r.Fraud.Score, 'f', 2, 64)
+		flagged = strconv.FormatBool(r.Fraud.Flagged)
+	}
+	trailingColumns := []string{r.MemberID, fraudScore, flagged, r.Status}
+
+	if len(r.Items) == 0 {
+		row := append([]string{}, receiptColumns...)
+		row = append(row, make([]string, csvItemColumns)...)
+		row = append(row, trailingColumns...)
+		return [][]string{append(row, "", r.Position.String())}
+	}
+
+	rows := make([][]string, 0, len(r.Items))
+	for _, item := range r.Items {
+		row := append([]string{}, receiptColumns...)
+		row = append(row,
+			strconv.FormatUint(uint64(item.ID), 10),
+			item.ShortDescription,
+			strconv.Itoa(item.Quantity),
+			item.PricePaid,
+			item.SKU.CombinePartsToString(),
+			item.SKU.Prefix,
+			item.SKU.ProductCategory,
+			item.SKU.Manufacturer,
+			item.SKU.ProductLine,
+			skuAttributesColumn(item.SKU.Attributes),
+			item.SKU.UniqueIdentifier,
+		)
+		row = append(row, trailingColumns...)
+		rows = append(rows, append(row, item.GTIN, r.Position.String()))
+	}
+
+	return rows
+}
+
+// WriteReceiptsCSV streams every receipt matching the filter to w as CSV,
+// header first. The CSV writer is flushed after every cursor batch and
+// afterBatch (optional) is then called, e.g. to flush a gzip or HTTP writer.
+func WriteReceiptsCSV(ctx context.Context, db *pgxpool.Pool, filter ExportFilter,
+	w io.Writer, afterBatch func() error) error {
+	csvWriter := csv.NewWriter(w)
+
+	if err := csvWriter.Write(CSVHeader); err != nil {
+		config.Log.Error("Failed to write CSV header", zap.Error(err))
+		return err
+	}
+
+	err := StreamReceipts(ctx, db, filter,
+		func(receipt ExportedReceipt) error {
+			for _, row := range receipt.CSVRows() {
+				if err := csvWriter.Write(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func() error {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return err
+			}
+			if afterBatch != nil {
+				return afterBatch()
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// skuAttributesColumn renders SKU attributes as a JSON object; encoding/json
+// sorts map keys, so the column is stable across exports.
+func skuAttributesColumn(attributes map[string]string) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		config.Log.Error("Failed to encode SKU attributes", zap.Error(err))
+		return ""
+	}
+	return string(encoded)
+}
@@ -0,0 +1,223 @@
+// model/export.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// ExportBatchSize is how many receipts are fetched from the export cursor at a time.
+const ExportBatchSize = 500
+
+// ErrInvalidExportPosition is returned for an export position that is not
+// one an export handed out.
+var ErrInvalidExportPosition = errors.New("invalid export position")
+
+// ExportFilter narrows down which receipts are exported.
+// FromDate/ToDate bound the purchase date (inclusive, YYYY-MM-DD) and Since
+// only keeps receipts created after it. After is the watermark for
+// incremental pulls: the Position of the last receipt exported, after
+// which the next pull resumes. Zero values mean "no bound".
+type ExportFilter struct {
+	FromDate string
+	ToDate   string
+	Since    time.Time
+	After    ExportPosition
+}
+
+// ExportPosition is where a receipt falls in export order: the ID of the
+// transaction that stored it, then its own ID. Transaction IDs are handed
+// out in the order receipts become visible to an export, which created_at
+// is not.
+type ExportPosition struct {
+	TxID uint64
+	ID   uuid.UUID
+}
+
+// IsZero reports whether the position is before every receipt.
+func (p ExportPosition) IsZero() bool {
+	return p.TxID == 0 && p.ID == uuid.Nil
+}
+
+// String formats the position as TXID:ID, the form ParseExportPosition reads.
+func (p ExportPosition) String() string {
+	return strconv.FormatUint(p.TxID, 10) + ":" + p.ID.String()
+}
+
+// MarshalText writes the position as its String form.
+func (p ExportPosition) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// ParseExportPosition reads a position formatted by ExportPosition.String.
+func ParseExportPosition(s string) (ExportPosition, error) {
+	txID, id, ok := strings.Cut(s, ":")
+	if !ok {
+		return ExportPosition{}, fmt.Errorf("%w: %q", ErrInvalidExportPosition, s)
+	}
+	var position ExportPosition
+	var err error
+	if position.TxID, err = strconv.ParseUint(txID, 10, 63); err != nil {
+		return ExportPosition{}, fmt.Errorf("%w: %q", ErrInvalidExportPosition, s)
+	}
+	if position.ID, err = uuid.Parse(id); err != nil {
+		return ExportPosition{}, fmt.Errorf("%w: %q", ErrInvalidExportPosition, s)
+	}
+	return position, nil
+}
+
+// ExportedReceipt is a receipt as written to an export, carrying its
+// created_at and the Position clients resume incremental pulls after.
+type ExportedReceipt struct {
+	Receipt
+	CreatedAt time.Time      `json:"createdAt"`
+	Position  ExportPosition `json:"position" swaggertype:"string"`
+}
+
+// StreamReceipts walks every receipt matching the filter, in export
+// Position order, and calls fn for each one with its items loaded.
+// Rows are read through a server-side cursor in batches of ExportBatchSize,
+// so memory use stays bounded regardless of the table size.
+// afterBatch (optional) is called once each batch has been handed to fn.
+//
+// A transaction storing receipts may commit after a later one. So that no
+// receipt ever lands behind a position already handed out, the export
+// stops short of the oldest transaction still running that may write; the
+// receipts from it on are exported by the next pull. Transactions that
+// only read, like other exports, hold nothing back.
+func StreamReceipts(ctx context.Context, db *pgxpool.Pool, filter ExportFilter,
+	fn func(ExportedReceipt) error, afterBatch func() error) error {
+	startTime := time.Now()
+
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		config.Log.Error("Failed to begin export transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The cursor's own snapshot decides which transactions have finished
+	whereClause, args := filter.whereClause()
+	_, err = tx.Exec(ctx, `
+        DECLARE receipt_export NO SCROLL CURSOR FOR
+        SELECT `+receiptColumns+`, created_at, created_xid::text::bigint
+        FROM receipts
+        `+whereClause+` AND created_xid < pg_snapshot_xmin(pg_current_snapshot())
+        ORDER BY created_xid, id
+    `, args...)
+	if err != nil {
+		config.Log.Error("Failed to declare export cursor", zap.Error(err))
+		return err
+	}
+
+	exported := 0
+	for {
+		batch, err := fetchExportBatch(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		receiptIDs := make([]string, len(batch))
+		for i := range batch {
+			receiptIDs[i] = batch[i].ID.String()
+		}
+
+		itemsByReceipt, err := getItemsForReceipts(ctx, tx, receiptIDs)
+		if err != nil {
+			return err
+		}
+
+		for i := range batch {
+			batch[i].Items = itemsByReceipt[batch[i].ID]
+			if err := fn(batch[i]); err != nil {
+				return err
+			}
+		}
+		exported += len(batch)
+
+		if afterBatch != nil {
+			if err := afterBatch(); err != nil {
+				return err
+			}
+		}
+	}
+
+	config.Log.Info("StreamReceipts executed",
+		zap.Int("receipts", exported),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return nil
+}
+
+func fetchExportBatch(ctx context.Context, tx pgx.Tx) ([]ExportedReceipt, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM receipt_export", ExportBatchSize))
+	if err != nil {
+		config.Log.Error("Failed to fetch from export cursor", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []ExportedReceipt
+	for rows.Next() {
+		var row receiptRow
+		var createdAt time.Time
+		var txID int64
+		if err := rows.Scan(append(row.targets(), &createdAt, &txID)...); err != nil {
+			config.Log.Error("Failed to scan exported receipt", zap.Error(err))
+			return nil, err
+		}
+		receipt := row.result()
+		batch = append(batch, ExportedReceipt{
+			Receipt:   receipt,
+			CreatedAt: createdAt,
+			Position:  ExportPosition{TxID: uint64(txID), ID: receipt.ID},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate export cursor", zap.Error(err))
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// whereClause builds the WHERE clause and positional arguments for the
+// filter. Deleted receipts are never exported.
+func (f ExportFilter) whereClause() (string, []any) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []any
+
+	if f.FromDate != "" {
+		args = append(args, f.FromDate)
+		conditions = append(conditions, fmt.Sprintf("purchase_date >= $%d::date", len(args)))
+	}
+	if f.ToDate != "" {
+		args = append(args, f.ToDate)
+		conditions = append(conditions, fmt.Sprintf("purchase_date <= $%d::date", len(args)))
+	}
+	if !f.Since.IsZero() {
+		args = append(args, f.Since)
+		conditions = append(conditions, fmt.Sprintf("created_at > $%d", len(args)))
+	}
+	if !f.After.IsZero() {
+		args = append(args, strconv.FormatUint(f.After.TxID, 10), f.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_xid, id) > ($%d::text::xid8, $%d)", len(args)-1, len(args)))
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,103 @@
+// model/fingerprint.go
+
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// fingerprintIndex is the unique index enforcing one receipt per fingerprint.
+const fingerprintIndex = "idx_receipts_fingerprint"
+
+// DuplicateReceiptError is returned when a receipt with the same content
+// fingerprint has already been stored.
+type DuplicateReceiptError struct {
+	ExistingID uuid.UUID
+}
+
+func (e *DuplicateReceiptError) Error() string {
+	return "duplicate receipt: already submitted as " + e.ExistingID.String()
+}
+
+// Fingerprint returns a canonical hash of what identifies a paper receipt:
+// retailer, purchase date/time, total and items. It is meant to be taken
+// after CleanItemShortDescriptions and date/time normalization. Case,
+// repeated whitespace, amount formatting ("9" vs "9.00") and item order do
+// not change the fingerprint.
+func (r *Receipt) Fingerprint() string {
+	items := make([]string, len(r.Items))
+	for i, item := range r.Items {
+		items[i] = fmt.Sprintf("%s|%d|%s",
+			normalizeFingerprintText(item.ShortDescription), item.Quantity, normalizeFingerprintAmount(item.PricePaid))
+	}
+	sort.Strings(items)
+
+	canonical := strings.Join([]string{
+		normalizeFingerprintText(r.Retailer),
+		r.PurchaseDate,
+		r.PurchaseTime,
+		normalizeFingerprintAmount(r.Total),
+		strings.Join(items, "\n"),
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(hash[:])
+}
+
+func normalizeFingerprintText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+func normalizeFingerprintAmount(amount string) string {
+	value, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+	if err != nil {
+		return strings.TrimSpace(amount)
+	}
+	return strconv.FormatFloat(config.RoundToNearestCent(value), 'f', 2, 64)
+}
+
+// asDuplicateReceiptError turns a unique violation on the fingerprint index
+// into a DuplicateReceiptError pointing at the receipt already stored.
+// Any other error is returned unchanged.
+func asDuplicateReceiptError(db *pgxpool.Pool, receipt *Receipt, err error) error {
+	return asDuplicateFingerprintError(db, receipt.ID, receipt.Fingerprint(), err)
+}
+
+// asDuplicateFingerprintError is asDuplicateReceiptError for a receipt of
+// which only the ID and stored fingerprint are at hand.
+func asDuplicateFingerprintError(db *pgxpool.Pool, id uuid.UUID, fingerprint string, err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" || pgErr.ConstraintName != fingerprintIndex {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var existingID uuid.UUID
+	if lookupErr := db.QueryRow(ctx, `
+		SELECT id FROM receipts WHERE fingerprint = $1 AND deleted_at IS NULL
+	`, fingerprint).Scan(&existingID); lookupErr != nil {
+		config.Log.Error("Failed to look up duplicate receipt", zap.Error(lookupErr))
+		return err
+	}
+
+	config.Log.Info("Duplicate receipt rejected",
+		zap.String("id", id.String()), zap.String("existingID", existingID.String()))
+	return &DuplicateReceiptError{ExistingID: existingID}
+}
@@ -0,0 +1,181 @@
+// model/fraud.go
+
+package model
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// FraudAssessment is the outcome of fraud scoring for a receipt.
+type FraudAssessment struct {
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+	Flagged bool     `json:"flagged"`
+}
+
+// fillerPattern matches descriptions padded with runs of punctuation, e.g. "Chips...".
+var fillerPattern = regexp.MustCompile(`[^\w\s]{3,}`)
+
+// AssessFraud scores a prepared (cleaned, normalized and scored) receipt
+// against the rules and sets receipt.Fraud. Flagged receipts get the
+// pending status so they wait for review, unless rules.HoldFlagged is off.
+// Heuristics that need history (velocity, per-retailer outliers) query the
+// database, and compare by canonical retailer once NormalizeRetailer has
+// set it; if one of those queries fails it is skipped and logged rather
+// than failing the receipt.
+func AssessFraud(db *pgxpool.Pool, receipt *Receipt, rules config.FraudRules) FraudAssessment {
+	receipt.Status = ReceiptStatusApproved
+	if !rules.Enabled {
+		receipt.Fraud = nil
+		return FraudAssessment{Reasons: []string{}}
+	}
+
+	assessment := FraudAssessment{Reasons: []string{}}
+	add := func(weight float64, reason string) {
+		if weight > 0 {
+			assessment.Score += weight
+			assessment.Reasons = append(assessment.Reasons, reason)
+		}
+	}
+
+	for _, reason := range suspiciousPatterns(receipt) {
+		add(rules.PatternWeight, reason)
+	}
+
+	if ratio, ok := pointsPerDollar(receipt); ok && ratio > rules.MaxPointsPerDollar {
+		add(rules.PointsRatioWeight, fmt.Sprintf("%.1f points per dollar exceeds %.1f", ratio, rules.MaxPointsPerDollar))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// History leaves out deleted and rejected receipts, and the receipt
+	// itself when it is being updated
+	if receipt.MemberID != "" && rules.VelocityWeight > 0 {
+		var recent int
+		err := db.QueryRow(ctx, `
+			SELECT COUNT(*) FROM receipts
+			WHERE member_id = $1 AND created_at > $2 AND id <> $3
+				AND deleted_at IS NULL AND status <> 'rejected'
+		`, receipt.MemberID, time.Now().Add(-rules.VelocityWindow), receipt.ID).Scan(&recent)
+		if err != nil {
+			config.Log.Error("Failed to check member velocity", zap.Error(err))
+		} else if recent >= rules.VelocityMaxReceipts {
+			add(rules.VelocityWeight, fmt.Sprintf("member submitted %d receipts within %s", recent+1, rules.VelocityWindow))
+		}
+	}
+
+	// The baseline is the approved receipts of the canonical retailer, or
+	// of the printed name without one, so flagged totals do not skew it
+	if rules.OutlierWeight > 0 {
+		var samples int
+		var mean, stddev float64
+		err := db.QueryRow(ctx, `
+			SELECT COUNT(*), COALESCE(AVG(total), 0)::float8, COALESCE(STDDEV_SAMP(total), 0)::float8
+			FROM receipts
+			WHERE CASE WHEN $2::int <> 0 THEN retailer_id = $2 ELSE LOWER(retailer) = LOWER($1) END
+				AND id <> $3 AND deleted_at IS NULL AND status = 'approved'
+		`, receipt.Retailer, receipt.RetailerID, receipt.ID).Scan(&samples, &mean, &stddev)
+		if err != nil {
+			config.Log.Error("Failed to load retailer statistics", zap.Error(err))
+		} else if total, parseErr := strconv.ParseFloat(receipt.Total, 64); parseErr == nil &&
+			samples >= rules.OutlierMinSamples && stddev > 0 {
+			if zScore := (total - mean) / stddev; math.Abs(zScore) > rules.OutlierZScore {
+				add(rules.OutlierWeight, fmt.Sprintf("total is %.1f standard deviations from the retailer average", zScore))
+			}
+		}
+	}
+
+	assessment.Score = math.Round(assessment.Score*100) / 100
+	assessment.Flagged = assessment.Score >= rules.FlagThreshold
+
+	if assessment.Flagged {
+		if rules.HoldFlagged {
+			receipt.Status = ReceiptStatusPending
+		}
+		config.Log.Warn("Receipt flagged for review",
+			zap.Bool("held", rules.HoldFlagged),
+			zap.String("id", receipt.ID.String()),
+			zap.Float64("score", assessment.Score),
+			zap.Strings("reasons", assessment.Reasons))
+	}
+
+	receipt.Fraud = &assessment
+	return assessment
+}
+
+// suspiciousPatterns looks for the shapes of receipts crafted to maximize
+// points, and returns one reason per pattern found.
+func suspiciousPatterns(receipt *Receipt) []string {
+	var reasons []string
+
+	if len(receipt.Items) >= 2 {
+		allMultiplesOfThree := true
+		for _, item := range receipt.Items {
+			if len(strings.TrimSpace(item.ShortDescription))%3 != 0 {
+				allMultiplesOfThree = false
+				break
+			}
+		}
+		if allMultiplesOfThree {
+			reasons = append(reasons, "every item description length is a multiple of 3")
+		}
+	}
+
+	for _, item := range receipt.Items {
+		if fillerPattern.MatchString(item.ShortDescription) || hasRepeatedRun(item.ShortDescription, 4) {
+			reasons = append(reasons, "item description padded with filler characters")
+			break
+		}
+	}
+
+	if total, err := strconv.ParseFloat(receipt.Total, 64); err == nil && total > 0 && math.Mod(total*100, 100) == 0 {
+		reasons = append(reasons, "total is a round dollar amount")
+	}
+
+	if purchaseTime, err := time.Parse("15:04", receipt.PurchaseTime); err == nil &&
+		purchaseTime.Hour() == 14 && purchaseTime.Minute() >= 1 && purchaseTime.Minute() <= 5 {
+		reasons = append(reasons, "purchase time just after 14:00")
+	}
+
+	return reasons
+}
+
+func pointsPerDollar(receipt *Receipt) (float64, bool) {
+	total, err := strconv.ParseFloat(receipt.Total, 64)
+	if err != nil || total <= 0 {
+		return 0, false
+	}
+	return float64(receipt.Points) / total, true
+}
+
+// hasRepeatedRun reports whether text repeats one non-digit character at
+// least n times in a row, e.g. "Soda xxxx".
+func hasRepeatedRun(text string, n int) bool {
+	run := 0
+	var previous rune
+	for i, c := range text {
+		if i > 0 && c == previous && !unicode.IsSpace(c) && !unicode.IsDigit(c) {
+			run++
+		} else {
+			run = 1
+		}
+		if run >= n {
+			return true
+		}
+		previous = c
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+// model/gtin.go
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidGTIN is returned for a barcode number that is not a valid
+// UPC-A, EAN-13 or GTIN-14.
+var ErrInvalidGTIN = errors.New("invalid GTIN")
+
+// Barcode number lengths. Every one is a GTIN-14 with leading zeros dropped:
+// a UPC-A is an EAN-13 starting with 0, and an EAN-13 a GTIN-14 starting
+// with 0.
+const (
+	upcALength   = 12
+	ean13Length  = 13
+	gtin14Length = 14
+)
+
+// NormalizeGTIN validates a UPC-A, EAN-13 or GTIN-14, including its check
+// digit, and returns it as a GTIN-14, the form GTINs are stored and looked
+// up in. Spaces and dashes are ignored.
+func NormalizeGTIN(code string) (string, error) {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(code)
+
+	switch len(digits) {
+	case upcALength, ean13Length, gtin14Length:
+	default:
+		return "", fmt.Errorf("%w %q: expected 12 (UPC-A), 13 (EAN-13) or 14 (GTIN-14) digits", ErrInvalidGTIN, code)
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("%w %q: only digits are allowed", ErrInvalidGTIN, code)
+		}
+	}
+
+	body, check := digits[:len(digits)-1], int(digits[len(digits)-1]-'0')
+	if expected := gtinCheckDigit(body); check != expected {
+		return "", fmt.Errorf("%w %q: check digit should be %d", ErrInvalidGTIN, code, expected)
+	}
+
+	return strings.Repeat("0", gtin14Length-len(digits)) + digits, nil
+}
+
+// GTINToUPCA returns the UPC-A form of a GTIN-14, if it has one.
+func GTINToUPCA(gtin string) (string, bool) {
+	return shortenGTIN(gtin, upcALength)
+}
+
+// GTINToEAN13 returns the EAN-13 form of a GTIN-14, if it has one.
+func GTINToEAN13(gtin string) (string, bool) {
+	return shortenGTIN(gtin, ean13Length)
+}
+
+func shortenGTIN(gtin string, length int) (string, bool) {
+	if len(gtin) != gtin14Length {
+		return "", false
+	}
+	padding := gtin[:gtin14Length-length]
+	if strings.Trim(padding, "0") != "" {
+		return "", false
+	}
+	return gtin[len(padding):], true
+}
+
+// gtinCheckDigit computes the GS1 check digit of the digits before it:
+// weighting them 3, 1, 3, ... from the right, the check digit brings the
+// sum to a multiple of 10.
+func gtinCheckDigit(body string) int {
+	sum := 0
+	for i := 0; i < len(body); i++ {
+		digit := int(body[len(body)-1-i] - '0')
+		if i%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10 - sum%10) % 10
+}
+
+// NormalizeGTINs validates the item GTINs of the receipt and rewrites them
+// as GTIN-14s.
+func (r *Receipt) NormalizeGTINs() error {
+	for i := range r.Items {
+		if r.Items[i].GTIN == "" {
+			continue
+		}
+		gtin, err := NormalizeGTIN(r.Items[i].GTIN)
+		if err != nil {
+			return err
+		}
+		r.Items[i].GTIN = gtin
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// model/idempotency.go
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyTTL is how long a key is remembered. After that, the same
+// key may be reused for a new request.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyKeyLease is how long a request keeps its key reserved before
+// finishing. A key whose request never finished, e.g. because the process
+// crashed, can be taken over by a retry of the same request after that.
+const IdempotencyKeyLease = time.Minute
+
+// ErrIdempotencyLeaseLost is returned when a request finishes with a key
+// that a retry has taken over since, whose reservation is then left alone.
+var ErrIdempotencyLeaseLost = errors.New("idempotency key reservation was taken over")
+
+// IdempotencyRecord is what is stored for an Idempotency-Key: the hash of the
+// request that first used it and, once that request finished, its response.
+type IdempotencyRecord struct {
+	Key            string
+	RequestHash    string
+	ResponseStatus int
+	ResponseBody   json.RawMessage
+	Completed      bool
+}
+
+// IdempotencyLease is one reservation of a key. Token tells it apart from
+// the reservation of a retry that took the key over once it expired.
+type IdempotencyLease struct {
+	Key         string
+	RequestHash string
+	Token       uuid.UUID
+}
+
+// ReserveIdempotencyKey claims key for a request with the given hash for
+// IdempotencyKeyLease. A lease is returned when the caller now owns the key
+// (it was unused, had expired, or its lease on the same request had run
+// out) and must finish with CompleteIdempotencyKey or
+// ReleaseIdempotencyKey. Otherwise the existing record is returned.
+func ReserveIdempotencyKey(db *pgxpool.Pool, key, requestHash string) (lease *IdempotencyLease, existing *IdempotencyRecord, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token := config.GenerateUUID()
+	var reserved bool
+	err = db.QueryRow(ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, locked_until, lock_token)
+		VALUES ($1, $2, now() + make_interval(secs => $4), $5)
+		ON CONFLICT (key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash,
+				response_status = NULL,
+				response_body = NULL,
+				created_at = now(),
+				locked_until = EXCLUDED.locked_until,
+				lock_token = EXCLUDED.lock_token
+			WHERE idempotency_keys.created_at < $3
+				OR (idempotency_keys.response_status IS NULL
+					AND idempotency_keys.request_hash = EXCLUDED.request_hash
+					AND (idempotency_keys.locked_until IS NULL OR idempotency_keys.locked_until < now()))
+		RETURNING true
+	`, key, requestHash, time.Now().Add(-IdempotencyKeyTTL), IdempotencyKeyLease.Seconds(), token).Scan(&reserved)
+	if err == nil {
+		return &IdempotencyLease{Key: key, RequestHash: requestHash, Token: token}, nil, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		config.Log.Error("Failed to reserve idempotency key", zap.Error(err))
+		return nil, nil, err
+	}
+
+	existing = &IdempotencyRecord{Key: key}
+	var status *int
+	err = db.QueryRow(ctx, `
+		SELECT request_hash, response_status, response_body
+		FROM idempotency_keys
+		WHERE key = $1
+	`, key).Scan(&existing.RequestHash, &status, &existing.ResponseBody)
+	if err != nil {
+		config.Log.Error("Failed to retrieve idempotency key", zap.Error(err))
+		return nil, nil, err
+	}
+
+	if status != nil {
+		existing.Completed = true
+		existing.ResponseStatus = *status
+	}
+
+	return nil, existing, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request holding lease,
+// so retries get it replayed. It returns ErrIdempotencyLeaseLost, storing
+// nothing, if a retry has taken the key over.
+func CompleteIdempotencyKey(db *pgxpool.Pool, lease *IdempotencyLease, status int, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `
+		UPDATE idempotency_keys SET response_status = $4, response_body = $5, locked_until = NULL
+		WHERE key = $1 AND lock_token = $2 AND request_hash = $3
+	`, lease.Key, lease.Token, lease.RequestHash, status, body)
+	if err != nil {
+		config.Log.Error("Failed to store idempotent response", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		config.Log.Warn("Idempotency key was taken over before its response was stored", zap.String("key", lease.Key))
+		return ErrIdempotencyLeaseLost
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the reservation lease holds, e.g. when its
+// request failed with a server error, so that a retry is processed again.
+// It returns ErrIdempotencyLeaseLost, releasing nothing, if a retry has
+// taken the key over.
+func ReleaseIdempotencyKey(db *pgxpool.Pool, lease *IdempotencyLease) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND lock_token = $2 AND request_hash = $3
+	`, lease.Key, lease.Token, lease.RequestHash)
+	if err != nil {
+		config.Log.Error("Failed to release idempotency key", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		config.Log.Warn("Idempotency key was taken over before it was released", zap.String("key", lease.Key))
+		return ErrIdempotencyLeaseLost
+	}
+	return nil
+}
@@ -0,0 +1,469 @@
+// model/job.go
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Job statuses
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// JobLeaseDuration is how long a claimed job stays with its worker without
+// a heartbeat. Once its lease expires, e.g. because the instance running it
+// died, the next claim takes the job over.
+const JobLeaseDuration = 2 * time.Minute
+
+// MaxJobAttempts is how many times a job is claimed before it fails. A job
+// retried after an error waits JobRetryDelay, doubling with every attempt
+// up to JobRetryMaxDelay.
+const (
+	MaxJobAttempts   = 5
+	JobRetryDelay    = 30 * time.Second
+	JobRetryMaxDelay = 30 * time.Minute
+)
+
+// jobFailedItemError is the error of the items a failed job left pending.
+const jobFailedItemError = "Not processed: the job failed after repeated errors"
+
+// ErrJobLeaseLost is returned when a worker writes to a job it no longer
+// holds the lease of, because the lease expired and another worker claimed
+// the job.
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+// Job item statuses
+const (
+	JobItemStatusPending   = "pending"
+	JobItemStatusSucceeded = "succeeded"
+	JobItemStatusFailed    = "failed"
+)
+
+// Job is an asynchronous batch submission and its progress.
+type Job struct {
+	ID          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	Total       int        `json:"total"`
+	Processed   int        `json:"processed"`
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	Items       []JobItem  `json:"items"`
+}
+
+// JobItem is the outcome of one processed receipt of a job.
+type JobItem struct {
+	Index     int        `json:"index"`
+	Status    string     `json:"status"`
+	ReceiptID *uuid.UUID `json:"receiptID,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// PendingJobItem is a not yet processed receipt payload of a job.
+type PendingJobItem struct {
+	Index   int
+	Payload json.RawMessage
+}
+
+// JobItemResult is what a worker hands back for one job item: either the
+// prepared Receipt to store, or the Error explaining why it was rejected.
+type JobItemResult struct {
+	Index   int
+	Receipt *Receipt
+	Error   string
+}
+
+// CreateJob persists a new queued job holding the given raw receipt
+// payloads. The receipts it creates are audited as audit.
+func CreateJob(db *pgxpool.Pool, payloads []json.RawMessage, audit AuditInfo) (*Job, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	job := &Job{
+		ID:     config.GenerateUUID(),
+		Status: JobStatusQueued,
+		Total:  len(payloads),
+		Items:  []JobItem{},
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO jobs (id, status, total, actor, request_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at, updated_at
+	`, job.ID, job.Status, job.Total, audit.Actor, audit.RequestID).Scan(&job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		config.Log.Error("Failed to insert job", zap.Error(err))
+		return nil, err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"job_items"},
+		[]string{"job_id", "item_index", "payload"},
+		pgx.CopyFromSlice(len(payloads), func(i int) ([]any, error) {
+			return []any{job.ID, i, []byte(payloads[i])}, nil
+		}))
+	if err != nil {
+		config.Log.Error("Failed to insert job items", zap.Error(err))
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+
+	config.Log.Info("CreateJob executed",
+		zap.String("id", job.ID.String()),
+		zap.Int("total", job.Total),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return job, nil
+}
+
+// GetJobByID returns a job with its progress counters and the outcome of
+// every item processed so far.
+func GetJobByID(db *pgxpool.Pool, id uuid.UUID) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job := &Job{ID: id, Items: []JobItem{}}
+	err := db.QueryRow(ctx, `
+		SELECT j.status, j.attempts, j.total, j.created_at, j.updated_at, j.completed_at,
+			COUNT(*) FILTER (WHERE i.status <> 'pending'),
+			COUNT(*) FILTER (WHERE i.status = 'succeeded'),
+			COUNT(*) FILTER (WHERE i.status = 'failed')
+		FROM jobs j
+		LEFT JOIN job_items i ON i.job_id = j.id
+		WHERE j.id = $1
+		GROUP BY j.id
+	`, id).Scan(&job.Status, &job.Attempts, &job.Total, &job.CreatedAt, &job.UpdatedAt, &job.CompletedAt,
+		&job.Processed, &job.Succeeded, &job.Failed)
+	if err != nil {
+		config.Log.Error("Failed to retrieve job", zap.String("id", id.String()), zap.Error(err))
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT item_index, status, receipt_id, COALESCE(error, '')
+		FROM job_items
+		WHERE job_id = $1 AND status <> 'pending'
+		ORDER BY item_index
+	`, id)
+	if err != nil {
+		config.Log.Error("Failed to retrieve job items", zap.String("id", id.String()), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item JobItem
+		if err := rows.Scan(&item.Index, &item.Status, &item.ReceiptID, &item.Error); err != nil {
+			config.Log.Error("Failed to scan job item", zap.Error(err))
+			return nil, err
+		}
+		job.Items = append(job.Items, item)
+	}
+
+	return job, rows.Err()
+}
+
+// ClaimNextJob leases the oldest queued job due to run, or running job
+// whose lease has expired, to owner for JobLeaseDuration and returns its ID
+// and how many attempts, this one included, it has had. ok is false when
+// there is nothing to claim. SKIP LOCKED lets several workers (or
+// instances) claim concurrently without handing out a job twice.
+func ClaimNextJob(db *pgxpool.Pool, owner string) (id uuid.UUID, attempts int, ok bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.QueryRow(ctx, `
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, updated_at = now(),
+			locked_by = $1, locked_until = now() + make_interval(secs => $2)
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'queued' AND (not_before IS NULL OR not_before <= now()))
+				OR (status = 'running' AND (locked_until IS NULL OR locked_until < now()))
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, attempts
+	`, owner, JobLeaseDuration.Seconds()).Scan(&id, &attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, 0, false, nil
+	} else if err != nil {
+		config.Log.Error("Failed to claim job", zap.Error(err))
+		return uuid.Nil, 0, false, err
+	}
+
+	return id, attempts, true, nil
+}
+
+// RenewJobLease extends owner's lease of a running job by JobLeaseDuration.
+// It returns ErrJobLeaseLost when owner no longer holds the job.
+func RenewJobLease(db *pgxpool.Pool, jobID uuid.UUID, owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `
+		UPDATE jobs SET locked_until = now() + make_interval(secs => $3)
+		WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`, jobID, owner, JobLeaseDuration.Seconds())
+	if err != nil {
+		config.Log.Error("Failed to renew job lease", zap.String("id", jobID.String()), zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// GetPendingJobItems returns up to limit unprocessed items of a job, in order.
+func GetPendingJobItems(db *pgxpool.Pool, jobID uuid.UUID, limit int) ([]PendingJobItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT item_index, payload
+		FROM job_items
+		WHERE job_id = $1 AND status = 'pending'
+		ORDER BY item_index
+		LIMIT $2
+	`, jobID, limit)
+	if err != nil {
+		config.Log.Error("Failed to retrieve pending job items", zap.String("id", jobID.String()), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []PendingJobItem
+	for rows.Next() {
+		var item PendingJobItem
+		if err := rows.Scan(&item.Index, &item.Payload); err != nil {
+			config.Log.Error("Failed to scan pending job item", zap.Error(err))
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// RecordJobItemResults stores the receipts of successful results and marks
+// every item done in the same transaction, so an item is never stored twice
+// even if the process dies midway. If the combined transaction fails, the
+// results are retried one by one and receipts that still cannot be stored
+// are recorded as failed. Nothing is recorded, and ErrJobLeaseLost is
+// returned, unless owner still holds the job.
+func RecordJobItemResults(db *pgxpool.Pool, jobID uuid.UUID, owner string, results []JobItemResult) error {
+	audit, err := getJobAuditInfo(db, jobID)
+	if err != nil {
+		return err
+	}
+
+	err = recordJobItemResults(db, jobID, owner, results, audit)
+	if err == nil || errors.Is(err, ErrJobLeaseLost) {
+		return err
+	}
+	config.Log.Warn("Recording job results failed, retrying items individually",
+		zap.String("id", jobID.String()), zap.Int("items", len(results)), zap.Error(err))
+
+	for _, result := range results {
+		err := recordJobItemResults(db, jobID, owner, []JobItemResult{result}, audit)
+		if err != nil && result.Receipt != nil && !errors.Is(err, ErrJobLeaseLost) {
+			config.Log.Error("Failed to create receipt",
+				zap.String("jobID", jobID.String()), zap.Int("index", result.Index), zap.Error(err))
+
+			message := "Failed to create receipt"
+			var duplicate *DuplicateReceiptError
+			var skuConflict *SKUConflictError
+			if errors.As(asDuplicateReceiptError(db, result.Receipt, err), &duplicate) {
+				message = duplicate.Error()
+			} else if errors.As(err, &skuConflict) {
+				message = skuConflict.Error()
+			}
+			err = recordJobItemResults(db, jobID, owner, []JobItemResult{{
+				Index: result.Index,
+				Error: message,
+			}}, audit)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func recordJobItemResults(db *pgxpool.Pool, jobID uuid.UUID, owner string, results []JobItemResult, audit AuditInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Holding the job row locked keeps it from being claimed while the
+	// results are recorded
+	tag, err := tx.Exec(ctx, `
+		UPDATE jobs SET updated_at = now(), locked_until = now() + make_interval(secs => $3)
+		WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`, jobID, owner, JobLeaseDuration.Seconds())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrJobLeaseLost
+	}
+
+	// Items that already have a result are skipped, so a job taken over
+	// midway never stores an item's receipt twice
+	indexes := make([]int, len(results))
+	for i, result := range results {
+		indexes[i] = result.Index
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT item_index FROM job_items
+		WHERE job_id = $1 AND item_index = ANY($2) AND status = 'pending'
+		FOR UPDATE
+	`, jobID, indexes)
+	if err != nil {
+		return err
+	}
+	pending, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+	isPending := make(map[int]bool, len(pending))
+	for _, index := range pending {
+		isPending[index] = true
+	}
+
+	batch := &pgx.Batch{}
+	for _, result := range results {
+		if !isPending[result.Index] {
+			config.Log.Warn("Skipping job item that already has a result",
+				zap.String("jobID", jobID.String()), zap.Int("index", result.Index))
+			continue
+		}
+		if result.Receipt != nil {
+			if err := queueReceiptInserts(batch, result.Receipt, audit); err != nil {
+				return err
+			}
+			batch.Queue(`
+				UPDATE job_items SET status = 'succeeded', receipt_id = $3, error = NULL
+				WHERE job_id = $1 AND item_index = $2 AND status = 'pending'
+			`, jobID, result.Index, result.Receipt.ID)
+		} else {
+			batch.Queue(`
+				UPDATE job_items SET status = 'failed', error = $3
+				WHERE job_id = $1 AND item_index = $2 AND status = 'pending'
+			`, jobID, result.Index, result.Error)
+		}
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// getJobAuditInfo returns who submitted a job.
+func getJobAuditInfo(db *pgxpool.Pool, jobID uuid.UUID) (AuditInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var audit AuditInfo
+	err := db.QueryRow(ctx, `
+		SELECT actor, request_id FROM jobs WHERE id = $1
+	`, jobID).Scan(&audit.Actor, &audit.RequestID)
+	if err != nil {
+		config.Log.Error("Failed to retrieve job submitter", zap.String("id", jobID.String()), zap.Error(err))
+	}
+	return audit, err
+}
+
+// CompleteJob marks a job owner holds as completed and releases it. It
+// returns ErrJobLeaseLost when owner no longer holds the job.
+func CompleteJob(db *pgxpool.Pool, jobID uuid.UUID, owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `
+		UPDATE jobs SET status = 'completed', completed_at = now(), updated_at = now(),
+			locked_by = NULL, locked_until = NULL
+		WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`, jobID, owner)
+	if err != nil {
+		config.Log.Error("Failed to complete job", zap.String("id", jobID.String()), zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// RetryJob releases a job owner holds after a failed attempt, e.g. a
+// database error. The job goes back in the queue, not to be claimed again
+// before its backoff has passed, unless it has had MaxJobAttempts: then it
+// fails, along with its pending items. It returns the job's new status, or
+// ErrJobLeaseLost when owner no longer holds the job.
+func RetryJob(db *pgxpool.Pool, jobID uuid.UUID, owner string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var status string
+	err := db.QueryRow(ctx, `
+		WITH job AS (
+			UPDATE jobs SET
+				status = CASE WHEN attempts >= $3::int THEN 'failed' ELSE 'queued' END,
+				completed_at = CASE WHEN attempts >= $3::int THEN now() END,
+				not_before = now() + make_interval(secs => LEAST($4::float8 * power(2, GREATEST(attempts - 1, 0)), $5::float8)),
+				locked_by = NULL, locked_until = NULL, updated_at = now()
+			WHERE id = $1 AND status = 'running' AND locked_by = $2
+			RETURNING id, status
+		), failed_items AS (
+			UPDATE job_items SET status = 'failed', error = $6
+			WHERE job_id IN (SELECT id FROM job WHERE status = 'failed') AND status = 'pending'
+		)
+		SELECT status FROM job
+	`, jobID, owner, MaxJobAttempts, JobRetryDelay.Seconds(), JobRetryMaxDelay.Seconds(),
+		jobFailedItemError).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrJobLeaseLost
+	} else if err != nil {
+		config.Log.Error("Failed to retry job", zap.String("id", jobID.String()), zap.Error(err))
+		return "", err
+	}
+	return status, nil
+}
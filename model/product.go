@@ -0,0 +1,248 @@
+// model/product.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidPricePeriod is returned for a price history period that is not
+// one of PricePeriods.
+var ErrInvalidPricePeriod = errors.New("invalid price period")
+
+// PricePeriods are the periods a price history can be grouped by, as
+// understood by Postgres date_trunc.
+var PricePeriods = []string{"day", "week", "month", "quarter", "year"}
+
+// DefaultPricePeriod groups a price history when no period is given.
+const DefaultPricePeriod = "month"
+
+// Product is what one or more SKUs sell, under a canonical name, brand and
+// category. A SKU seen for the first time gets a product of its own, named
+// after the item it was bought as; SKUs of the same product sold by other
+// retailers can then be linked to it.
+type Product struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Brand    string    `json:"brand"`
+	Category string    `json:"category"`
+	SKUs     []SKUKey  `json:"skus"`
+}
+
+// PriceHistoryFilter narrows a product's price history. FromDate and ToDate
+// are inclusive YYYY-MM-DD purchase dates; empty fields match everything.
+type PriceHistoryFilter struct {
+	Period   string
+	FromDate string
+	ToDate   string
+	Retailer string
+}
+
+// PricePoint summarizes the unit prices a product was bought at from one
+// retailer during one period. Prices are rounded to the cent.
+type PricePoint struct {
+	PeriodStart string  `json:"periodStart"`
+	Retailer    string  `json:"retailer"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Avg         float64 `json:"avg"`
+	Purchases   int     `json:"purchases"`
+	Quantity    int     `json:"quantity"`
+}
+
+// ValidPricePeriod reports whether period is one of PricePeriods.
+func ValidPricePeriod(period string) bool {
+	for _, valid := range PricePeriods {
+		if period == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// queueProductInsert queues the statement giving a SKU stored without a
+// product a new product of its own, named name, onto batch. It runs after
+// the SKU's upsert, which holds the SKU's row lock until the transaction
+// ends, so concurrent receipts cannot both create one.
+func queueProductInsert(batch *pgx.Batch, sku SKU, name string) {
+	batch.Queue(`
+		WITH product AS (
+			INSERT INTO products (id, name, brand, category)
+			SELECT $3, $4, $5, $6
+			WHERE EXISTS (
+				SELECT 1 FROM skus
+				WHERE prefix = $1 AND unique_identifier = $2 AND product_id IS NULL
+			)
+			RETURNING id
+		)
+		UPDATE skus SET product_id = product.id
+		FROM product
+		WHERE skus.prefix = $1 AND skus.unique_identifier = $2
+	`, sku.Prefix, sku.UniqueIdentifier, config.GenerateUUID(), name, sku.Manufacturer, sku.ProductCategory)
+}
+
+// GetProduct returns a product with its SKUs, or pgx.ErrNoRows if there is
+// no such product.
+func GetProduct(db *pgxpool.Pool, id uuid.UUID) (*Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	product := Product{ID: id}
+	err := db.QueryRow(ctx, `
+		SELECT p.name, p.brand, p.category,
+			COALESCE((
+				SELECT jsonb_agg(jsonb_build_object('prefix', s.prefix, 'uniqueIdentifier', s.unique_identifier)
+					ORDER BY s.prefix, s.unique_identifier)
+				FROM skus s
+				WHERE s.product_id = p.id
+			), '[]')
+		FROM products p
+		WHERE p.id = $1
+	`, id).Scan(&product.Name, &product.Brand, &product.Category, &product.SKUs)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to retrieve product", zap.String("id", id.String()), zap.Error(err))
+		}
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// UpdateProduct replaces the canonical name, brand and category of a
+// product, returning pgx.ErrNoRows if there is no such product.
+func UpdateProduct(db *pgxpool.Pool, product *Product) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `
+		UPDATE products SET name = $2, brand = $3, category = $4, updated_at = now()
+		WHERE id = $1
+	`, product.ID, product.Name, product.Brand, product.Category)
+	if err != nil {
+		config.Log.Error("Failed to update product", zap.String("id", product.ID.String()), zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	config.Log.Info("Product updated", zap.String("id", product.ID.String()))
+	return nil
+}
+
+// LinkSKUToProduct makes a SKU one of the product's, moving it from the
+// product it belonged to. It returns pgx.ErrNoRows if either does not
+// exist.
+func LinkSKUToProduct(db *pgxpool.Pool, productID uuid.UUID, sku SKUKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `
+		UPDATE skus SET product_id = p.id
+		FROM products p
+		WHERE p.id = $1 AND skus.prefix = $2 AND skus.unique_identifier = $3
+	`, productID, sku.Prefix, sku.UniqueIdentifier)
+	if err != nil {
+		config.Log.Error("Failed to link SKU to product",
+			zap.String("id", productID.String()),
+			zap.String("prefix", sku.Prefix),
+			zap.String("sku", sku.UniqueIdentifier),
+			zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	config.Log.Info("SKU linked to product",
+		zap.String("id", productID.String()),
+		zap.String("prefix", sku.Prefix),
+		zap.String("sku", sku.UniqueIdentifier))
+	return nil
+}
+
+// GetProductPrices returns the price history of a product, derived from what
+// its items were paid on live receipts: one point per period and retailer,
+// oldest first. A unit price is an item's price paid over its quantity.
+// Rejected receipts are left out.
+func GetProductPrices(db *pgxpool.Pool, productID uuid.UUID, filter PriceHistoryFilter) ([]PricePoint, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if filter.Period == "" {
+		filter.Period = DefaultPricePeriod
+	}
+	if !ValidPricePeriod(filter.Period) {
+		return nil, fmt.Errorf("%w %q: expected one of %s", ErrInvalidPricePeriod, filter.Period,
+			strings.Join(PricePeriods, ", "))
+	}
+
+	conditions := []string{"s.product_id = $1", "r.deleted_at IS NULL", "r.status <> 'rejected'"}
+	args := []any{productID, filter.Period}
+	if filter.FromDate != "" {
+		args = append(args, filter.FromDate)
+		conditions = append(conditions, fmt.Sprintf("r.purchase_date >= $%d::date", len(args)))
+	}
+	if filter.ToDate != "" {
+		args = append(args, filter.ToDate)
+		conditions = append(conditions, fmt.Sprintf("r.purchase_date <= $%d::date", len(args)))
+	}
+	if filter.Retailer != "" {
+		args = append(args, filter.Retailer)
+		conditions = append(conditions, fmt.Sprintf("r.retailer = $%d", len(args)))
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT TO_CHAR(date_trunc($2::text, r.purchase_date::timestamp), 'YYYY-MM-DD') AS period_start, r.retailer,
+			ROUND(MIN(prices.unit_price), 2)::float8, ROUND(MAX(prices.unit_price), 2)::float8,
+			ROUND(AVG(prices.unit_price), 2)::float8, COUNT(*)::int, SUM(i.quantity)::int
+		FROM items i
+		JOIN skus s ON s.prefix = i.sku_prefix AND s.unique_identifier = i.sku_id
+		JOIN receipts r ON r.id = i.receipt_id
+		CROSS JOIN LATERAL (SELECT i.price_paid / GREATEST(i.quantity, 1) AS unit_price) prices
+		WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY period_start, r.retailer
+		ORDER BY period_start, r.retailer
+	`, args...)
+	if err != nil {
+		config.Log.Error("Failed to retrieve product prices", zap.String("id", productID.String()), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []PricePoint{}
+	for rows.Next() {
+		var point PricePoint
+		if err := rows.Scan(&point.PeriodStart, &point.Retailer, &point.Min, &point.Max, &point.Avg,
+			&point.Purchases, &point.Quantity); err != nil {
+			config.Log.Error("Failed to scan price point", zap.Error(err))
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate price points", zap.Error(err))
+		return nil, err
+	}
+
+	config.Log.Info("GetProductPrices executed",
+		zap.String("id", productID.String()),
+		zap.String("period", filter.Period),
+		zap.Int("points", len(points)),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return points, nil
+}
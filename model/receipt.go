@@ -9,7 +9,6 @@ import (
 	"strconv"
 
 	"context"
-	"encoding/json"
 	"strings"
 	"time"
 	"unicode"
@@ -17,6 +16,7 @@ import (
 
 	"rcpt-proc-challenge-ans/config"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 )
@@ -24,20 +24,61 @@ import (
 type Item struct {
 	ID               uint      `json:"id"`
 	SKU              SKU       `json:"sku"`
+	GTIN             string    `json:"gtin,omitempty"`
 	ShortDescription string    `json:"shortDescription"`
 	Quantity         int       `json:"quantity"`
 	PricePaid        string    `json:"pricePaid"`
 	ReceiptID        uuid.UUID `json:"receiptID"`
+	// CategoryID is the taxonomy category the item was put in, if any
+	CategoryID int `json:"categoryID,omitempty"`
 }
 
 type Receipt struct {
-	ID           uuid.UUID `json:"id"`
-	Retailer     string    `json:"retailer"`
-	PurchaseDate string    `json:"purchaseDate"`
-	PurchaseTime string    `json:"purchaseTime"`
-	Items        []Item    `json:"items"`
-	Total        string    `json:"total"`
-	Points       uint      `json:"points"`
+	ID           uuid.UUID        `json:"id"`
+	Retailer     string           `json:"retailer"`
+	PurchaseDate string           `json:"purchaseDate"`
+	PurchaseTime string           `json:"purchaseTime"`
+	Items        []Item           `json:"items"`
+	Total        string           `json:"total"`
+	Points       uint             `json:"points"`
+	MemberID     string           `json:"memberId,omitempty"`
+	Status       string           `json:"status"`
+	Version      int              `json:"version"`
+	Fraud        *FraudAssessment `json:"fraud,omitempty"`
+	// SKUConflicts are the SKUs this receipt defined differently than the
+	// catalog when it was last stored; they are not stored themselves
+	SKUConflicts []SKUConflict `json:"skuConflicts,omitempty"`
+	// RetailerID and CanonicalRetailer identify the canonical retailer the
+	// retailer name was normalized to, if any
+	RetailerID        int    `json:"retailerID,omitempty"`
+	CanonicalRetailer string `json:"canonicalRetailer,omitempty"`
+	// Store is the store location the receipt was printed at, if given
+	Store *Store `json:"store,omitempty"`
+	// PointsBreakdown holds the points each rule awarded, keyed by
+	// PointsRuleNames; receipts stored before breakdowns were kept have none
+	PointsBreakdown map[string]uint `json:"pointsBreakdown,omitempty"`
+}
+
+// The points rules, as named in points breakdowns.
+const (
+	PointsRuleRetailerName      = "retailerName"      // 1 per letter or digit of the retailer name
+	PointsRuleRoundDollarTotal  = "roundDollarTotal"  // 50 for a total without cents
+	PointsRuleQuarterTotal      = "quarterTotal"      // 25 for a total that is a multiple of 0.25
+	PointsRuleItemPairs         = "itemPairs"         // 5 per two items
+	PointsRuleItemDescription   = "itemDescription"   // a fifth of the price of items whose description length is a multiple of 3
+	PointsRuleOddPurchaseDay    = "oddPurchaseDay"    // 6 for an odd purchase day
+	PointsRuleAfternoonPurchase = "afternoonPurchase" // 10 for a purchase between 2:00 and 4:00 pm
+)
+
+// PointsRuleNames lists the points rules in the order they are applied.
+var PointsRuleNames = []string{
+	PointsRuleRetailerName,
+	PointsRuleRoundDollarTotal,
+	PointsRuleQuarterTotal,
+	PointsRuleItemPairs,
+	PointsRuleItemDescription,
+	PointsRuleOddPurchaseDay,
+	PointsRuleAfternoonPurchase,
 }
 
 /*
@@ -56,6 +97,9 @@ Attributes: A map for various attributes like size, color, weight, etc.
 	This allows for flexibility across different product types.
 
 UniqueIdentifier: A unique identifier within the store's system.
+Format: Empty for the SKU grammar (see sku.go), otherwise the retailer
+format the SKU was read with, or SKUFormatRaw.
+Raw: The SKU as submitted, when it is not in the SKU grammar.
 */
 type SKU struct {
 	Prefix           string            `json:"prefix"`
@@ -64,6 +108,8 @@ type SKU struct {
 	ProductLine      string            `json:"productLine"`
 	Attributes       map[string]string `json:"attributes"`
 	UniqueIdentifier string            `json:"uniqueIdentifier"`
+	Format           string            `json:"format,omitempty"`
+	Raw              string            `json:"raw,omitempty"`
 }
 
 type ReceiptStore interface {
@@ -72,6 +118,55 @@ type ReceiptStore interface {
     GetAllReceipts() ([]Receipt, error)
 }
 
+// receiptColumns is the column list every receipt read selects, in the
+// order expected by receiptRow.targets.
+const receiptColumns = `id, retailer,
+	TO_CHAR(purchase_date, 'YYYY-MM-DD') as purchase_date,
+	TO_CHAR(purchase_time, 'HH24:MI') as purchase_time,
+	total, points, COALESCE(member_id, ''),
+	status, version, fraud_score::float8, fraud_reasons, flagged,
+	COALESCE(retailer_id, 0), COALESCE((SELECT rt.name FROM retailers rt WHERE rt.id = retailer_id), ''),
+	(SELECT jsonb_build_object('id', s.id, 'retailerID', s.retailer_id, 'storeNumber', s.store_number,
+		'address', s.address, 'latitude', s.latitude, 'longitude', s.longitude)
+		FROM stores s WHERE s.id = store_id),
+	points_breakdown`
+
+// receiptRow holds a scanned receiptColumns row until it is turned into a Receipt.
+type receiptRow struct {
+	receipt Receipt
+	fraud   FraudAssessment
+}
+
+func (row *receiptRow) targets() []any {
+	return []any{
+		&row.receipt.ID, &row.receipt.Retailer, &row.receipt.PurchaseDate, &row.receipt.PurchaseTime,
+		&row.receipt.Total, &row.receipt.Points, &row.receipt.MemberID, &row.receipt.Status, &row.receipt.Version,
+		&row.fraud.Score, &row.fraud.Reasons, &row.fraud.Flagged,
+		&row.receipt.RetailerID, &row.receipt.CanonicalRetailer, &row.receipt.Store,
+		&row.receipt.PointsBreakdown,
+	}
+}
+
+// result returns the scanned receipt; the fraud assessment is only attached
+// when scoring found something.
+func (row *receiptRow) result() Receipt {
+	receipt := row.receipt
+	if row.fraud.Score > 0 || row.fraud.Flagged {
+		fraud := row.fraud
+		if fraud.Reasons == nil {
+			fraud.Reasons = []string{}
+		}
+		receipt.Fraud = &fraud
+	}
+	return receipt
+}
+
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx, so read helpers
+// can run either directly on the pool or inside a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
 
 
 // GenerateID generates a new UUID and sets it as the receipt's ID
@@ -167,87 +262,60 @@ func (receipt *Receipt) ValidateReceipt() error {
 }
 
 //func (receipt *Receipt) ValidateReceipt() error {
+
+// CalculatePoints calculates the receipt's points with the default rules.
 func (receipt *Receipt) CalculatePoints() {
+	receipt.CalculatePointsWithRules(config.DefaultPointsRules())
+}
+
+// CalculatePointsWithRules calculates the receipt's points, counting the
+// retailer name rules.RetailerName selects, and records what each rule
+// awarded in PointsBreakdown.
+func (receipt *Receipt) CalculatePointsWithRules(rules config.PointsRules) {
 	// Points Calculation
-	points := uint(0)
+	breakdown := map[string]uint{}
 
 	// add 1 pt for every alphaNumeric char in retailer name..
-	points += calculatePointsFromRetailerAlphaNumChar(receipt.Retailer)
+	retailer := receipt.Retailer
+	if rules.RetailerName == config.PointsRetailerCanonical && receipt.CanonicalRetailer != "" {
+		retailer = receipt.CanonicalRetailer
+	}
+	breakdown[PointsRuleRetailerName] = calculatePointsFromRetailerAlphaNumChar(retailer)
 
-	// If the total is a multiple of 0.25, add 25 pts.
-	points += calculatePointsFromTotal(receipt.Total)
+	// If the total is a round dollar amount, add 50 pts; if it is a
+	// multiple of 0.25, add 25 pts.
+	breakdown[PointsRuleRoundDollarTotal] = calculatePointsFromRoundDollarTotal(receipt.Total)
+	breakdown[PointsRuleQuarterTotal] = calculatePointsFromQuarterTotal(receipt.Total)
 
 	// add 5 points for every TWO items in the receipt.
 	// 3/2 -> 1 (discards .5)
-	points += (uint(((len(receipt.Items) / 2) * 5)))
+	breakdown[PointsRuleItemPairs] = uint((len(receipt.Items) / 2) * 5)
 
 	// go through items w/ pre-trimmed descriptions.
-	points += calculatePointsFromItemPriceAndDesc(receipt.Items)
+	breakdown[PointsRuleItemDescription] = calculatePointsFromItemPriceAndDesc(receipt.Items)
 
 	/*
 		Processing date + time.
 	*/
 	// Parse the purchaseDate and check if the day is odd or even.
-	points += calculatePointsFromPurchaseDate(receipt.PurchaseDate)
+	breakdown[PointsRuleOddPurchaseDay] = calculatePointsFromPurchaseDate(receipt.PurchaseDate)
 
 	// Parse the purchaseTime and check if between
 	// after startTime && before endTime.
-	points += calculatePointsFromPurchaseTime(receipt.PurchaseTime)
-
-	receipt.Points = points
-}
-
-func (s *SKU) ParseSKU(skuString string) error {
-	// Assuming SKU string format:
-	// "WMT-GROC-NESTLE-CHOC-WEIGHT-100G-67890"
-	skuParts := strings.Split(skuString, "-")
-	if len(skuParts) < 5 { // Minimum: Prefix, Category, Manufacturer, ProductLine, UniqueID
-		skuParsingErr := errors.New("invalid SKU format")
-		config.Log.Error("Invalid Purchase Date", zap.Error(skuParsingErr))
-		return skuParsingErr
-	}
-
-	s.Prefix = skuParts[0]
-	s.ProductCategory = skuParts[1]
-	s.Manufacturer = skuParts[2]
-	s.ProductLine = skuParts[3]
-
-	s.Attributes = make(map[string]string)
-	for i := 4; i < len(skuParts)-1; i += 2 {
-		if i+1 < len(skuParts) {
-			s.Attributes[skuParts[i]] = skuParts[i+1]
-		}
-	}
-
-	s.UniqueIdentifier = skuParts[len(skuParts)-1]
-
-	return nil
-}
-
-func (s SKU) CombinePartsToString() string {
-	skuParts := []string{s.Prefix, s.ProductCategory, s.Manufacturer, s.ProductLine}
-
-	for key, value := range s.Attributes {
-		skuParts = append(skuParts, key, value)
-	}
-
-	skuParts = append(skuParts, s.UniqueIdentifier)
-
-	return strings.Join(skuParts, "-")
-}
+	breakdown[PointsRuleAfternoonPurchase] = calculatePointsFromPurchaseTime(receipt.PurchaseTime)
 
-func (s *SKU) UnmarshalJSON(data []byte) error {
-	var skuString string
-	if err := json.Unmarshal(data, &skuString); err != nil {
-		return err
+	points := uint(0)
+	for _, rulePoints := range breakdown {
+		points += rulePoints
 	}
-
-	return s.ParseSKU(skuString)
+	receipt.Points = points
+	receipt.PointsBreakdown = breakdown
 }
 
-
-// AddReceipt inserts a new receipt and its associated items into the database
-func AddReceipt(db *pgxpool.Pool, receipt *Receipt) error {
+// AddReceipt inserts a new receipt and its associated items into the database.
+// A receipt whose content fingerprint is already stored is rejected with a
+// *DuplicateReceiptError. The creation is recorded in the audit log as audit.
+func AddReceipt(db *pgxpool.Pool, receipt *Receipt, audit AuditInfo) error {
 	//return db.Create(receipt).Error
 	startTime := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -260,56 +328,15 @@ func AddReceipt(db *pgxpool.Pool, receipt *Receipt) error {
 	}
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, `
-		INSERT INTO receipts (id, retailer, purchase_date, purchase_time, total, points)
-		VALUES ($1, $2, $3::date, $4::time, $5, $6)
-	`, receipt.ID, receipt.Retailer, receipt.PurchaseDate, receipt.PurchaseTime, receipt.Total, receipt.Points)
-	if err != nil {
-		config.Log.Error("Failed to insert receipt", zap.Error(err))
+	batch := &pgx.Batch{}
+	if err := queueReceiptInserts(batch, receipt, audit); err != nil {
 		return err
 	}
 
-	for _, item := range receipt.Items {
-		item.ReceiptID = receipt.ID
-		var sku SKU
-
-		// Parse the SKU string into the SKU struct
-		if err := sku.ParseSKU(item.SKU.CombinePartsToString()); err != nil {
-			config.Log.Error("Failed to parse SKU", zap.Error(err))
-			return err
-		}
-
-		// Check if SKU already exists
-		var skuExists bool
-		err = tx.QueryRow(ctx, `
-            SELECT EXISTS(SELECT 1 FROM skus WHERE unique_identifier = $1)
-        `, sku.UniqueIdentifier).Scan(&skuExists)
-		if err != nil {
-			config.Log.Error("Failed to check SKU existence", zap.Error(err))
-			return err
-		}
-
-		// Insert SKU if it doesn't exist
-		if !skuExists {
-			_, err = tx.Exec(ctx, `
-                INSERT INTO skus (unique_identifier, prefix, product_category, manufacturer, product_line, attributes)
-                VALUES ($1, $2, $3, $4, $5, $6)
-            `, sku.UniqueIdentifier, sku.Prefix, sku.ProductCategory, sku.Manufacturer, sku.ProductLine, sku.Attributes)
-			if err != nil {
-				config.Log.Error("Failed to insert SKU", zap.Error(err))
-				return err
-			}
-		}
-
-		// Insert the item with the SKU's unique identifier
-		_, err = tx.Exec(ctx, `
-            INSERT INTO items (short_description, quantity, price_paid, receipt_id, sku_id)
-            VALUES ($1, $2, $3, $4, $5)
-        `, item.ShortDescription, item.Quantity, item.PricePaid, item.ReceiptID, sku.UniqueIdentifier)
-		if err != nil {
-			config.Log.Error("Failed to insert item", zap.Error(err))
-			return err
-		}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		config.Log.Error("Failed to insert receipt", zap.String("id", receipt.ID.String()), zap.Error(err))
+		tx.Rollback(ctx)
+		return asDuplicateReceiptError(db, receipt, err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -323,53 +350,106 @@ func AddReceipt(db *pgxpool.Pool, receipt *Receipt) error {
 	return nil
 }
 
+// queueReceiptInserts queues the statements that store a receipt, its SKUs,
+// its items and its creation audit entry onto batch. Both AddReceipt and
+// AddReceipts go through here so single and bulk submissions are stored
+// identically.
+func queueReceiptInserts(batch *pgx.Batch, receipt *Receipt, audit AuditInfo) error {
+	fraud := FraudAssessment{Reasons: []string{}}
+	if receipt.Fraud != nil {
+		fraud = *receipt.Fraud
+	}
+	if receipt.Status == "" {
+		receipt.Status = ReceiptStatusApproved
+	}
+	receipt.Version = 1
+
+	queueStoreUpsert(batch, receipt)
+	batch.Queue(`
+		INSERT INTO receipts (id, retailer, purchase_date, purchase_time, total, points, fingerprint,
+			member_id, status, version, fraud_score, fraud_reasons, flagged, retailer_id, store_id, points_breakdown)
+		VALUES ($1, $2, $3::date, $4::time, $5, $6, $7, NULLIF($8, ''), $9, $10, $11, $12, $13, NULLIF($14, 0),
+			(SELECT id FROM stores WHERE retailer_id = $14 AND store_key = $15), $16)
+	`, receipt.ID, receipt.Retailer, receipt.PurchaseDate, receipt.PurchaseTime, receipt.Total, receipt.Points, receipt.Fingerprint(),
+		receipt.MemberID, receipt.Status, receipt.Version, fraud.Score, fraud.Reasons, fraud.Flagged, receipt.RetailerID,
+		storeKey(receipt.Store), pointsBreakdown(receipt))
+
+	if err := queueItemInserts(batch, receipt); err != nil {
+		return err
+	}
+
+	queueAuditEntry(batch, receipt.ID, AuditActionCreate, audit, receiptDiff(nil, receipt))
+	return nil
+}
+
+// pointsBreakdown returns the receipt's points breakdown as stored, empty
+// rather than null when there is none.
+func pointsBreakdown(receipt *Receipt) map[string]uint {
+	if receipt.PointsBreakdown == nil {
+		return map[string]uint{}
+	}
+	return receipt.PointsBreakdown
+}
+
+// queueItemInserts queues the statements that store a receipt's SKUs and
+// items onto batch.
+func queueItemInserts(batch *pgx.Batch, receipt *Receipt) error {
+	// Conflicts are collected anew each time the batch runs
+	receipt.SKUConflicts = nil
+
+	for _, item := range receipt.Items {
+		item.ReceiptID = receipt.ID
+		sku := item.SKU
+
+		// Insert the item alone when it has no SKU
+		if sku.IsZero() {
+			batch.Queue(`
+                INSERT INTO items (short_description, quantity, price_paid, receipt_id, gtin, category_id)
+                VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0))
+            `, item.ShortDescription, item.Quantity, item.PricePaid, item.ReceiptID, item.GTIN, item.CategoryID)
+			continue
+		}
+		if sku.Attributes == nil {
+			sku.Attributes = map[string]string{}
+		}
+
+		queueSKUUpsert(batch, receipt, sku)
+		queueProductInsert(batch, sku, item.ShortDescription)
+
+		// Insert the item with the SKU's prefix and unique identifier
+		batch.Queue(`
+            INSERT INTO items (short_description, quantity, price_paid, receipt_id, sku_prefix, sku_id, gtin, category_id)
+            VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, 0))
+        `, item.ShortDescription, item.Quantity, item.PricePaid, item.ReceiptID, sku.Prefix, sku.UniqueIdentifier, item.GTIN,
+			item.CategoryID)
+	}
+
+	return nil
+}
 
 func GetReceiptByID(db *pgxpool.Pool, id uuid.UUID) (*Receipt, error) {
 	startTime := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	receipt := &Receipt{ID: id}
+	var row receiptRow
 	err := db.QueryRow(ctx, `
-		SELECT retailer, 
-			TO_CHAR(purchase_date, 'YYYY-MM-DD') as purchase_date, 
-			TO_CHAR(purchase_time, 'HH24:MI') as purchase_time, 
-			total, points
+		SELECT `+receiptColumns+`
 		FROM receipts
-		WHERE id = $1
-	`, id).Scan(&receipt.Retailer, &receipt.PurchaseDate, &receipt.PurchaseTime, &receipt.Total, &receipt.Points)
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id).Scan(row.targets()...)
 	if err != nil {
 		config.Log.Error("Failed to retrieve receipt", zap.String("id", id.String()), zap.Error(err))
 		return nil, err
 	}
+	result := row.result()
+	receipt := &result
 
-	rows, err := db.Query(ctx, `
-        SELECT i.id, i.short_description, i.quantity, i.price_paid, s.unique_identifier, s.prefix, s.product_category, s.manufacturer, s.product_line, s.attributes
-        FROM items i
-        JOIN skus s ON i.sku_id = s.unique_identifier
-        WHERE i.receipt_id = $1
-    `, id)
+	itemsByReceipt, err := getItemsForReceipts(ctx, db, []string{id.String()})
 	if err != nil {
-		config.Log.Error("Failed to retrieve items for receipt", zap.String("receipt_id", id.String()), zap.Error(err))
 		return nil, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var item Item
-		var sku SKU
-		err := rows.Scan(
-			&item.ID, &item.ShortDescription, &item.Quantity, &item.PricePaid,
-			&sku.UniqueIdentifier, &sku.Prefix, &sku.ProductCategory, &sku.Manufacturer, &sku.ProductLine, &sku.Attributes)
-		if err != nil {
-			config.Log.Error("Failed to scan item", zap.Error(err))
-			return nil, err
-		}
-
-		item.ReceiptID = id // Set the ReceiptID to the current receipt's ID
-		item.SKU = sku      // Set
-		receipt.Items = append(receipt.Items, item)
-	}
+	receipt.Items = itemsByReceipt[id]
 
 	executionTime := time.Since(startTime)
 	config.Log.Info("GetReceiptByID executed", zap.Duration("duration", executionTime))
@@ -377,17 +457,17 @@ func GetReceiptByID(db *pgxpool.Pool, id uuid.UUID) (*Receipt, error) {
 	return receipt, nil
 }
 
+// GetAllReceipts loads every receipt along with its items using two queries:
+// one for the receipts and one batched items query keyed on the receipt IDs.
 func GetAllReceipts(db *pgxpool.Pool) ([]Receipt, error) {
 	startTime := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	rows, err := db.Query(ctx, `
-        SELECT id, retailer, 
-               TO_CHAR(purchase_date, 'YYYY-MM-DD') as purchase_date, 
-               TO_CHAR(purchase_time, 'HH24:MI') as purchase_time, 
-               total, points
+        SELECT `+receiptColumns+`
         FROM receipts
+        WHERE deleted_at IS NULL
     `)
 	if err != nil {
 		config.Log.Error("Failed to retrieve receipts", zap.Error(err))
@@ -396,52 +476,90 @@ func GetAllReceipts(db *pgxpool.Pool) ([]Receipt, error) {
 	defer rows.Close()
 
 	var receipts []Receipt
+	var receiptIDs []string
 
 	for rows.Next() {
-		var receipt Receipt
-		err := rows.Scan(&receipt.ID, &receipt.Retailer, &receipt.PurchaseDate, &receipt.PurchaseTime, &receipt.Total, &receipt.Points)
-		if err != nil {
+		var row receiptRow
+		if err := rows.Scan(row.targets()...); err != nil {
 			config.Log.Error("Failed to scan receipt", zap.Error(err))
 			return nil, err
 		}
 
-		// Fetch items for this receipt
-		itemRows, err := db.Query(ctx, `
-            SELECT i.id, i.short_description, i.quantity, i.price_paid, s.unique_identifier, s.prefix, s.product_category, s.manufacturer, s.product_line, s.attributes
-            FROM items i
-            JOIN skus s ON i.sku_id = s.unique_identifier
-            WHERE i.receipt_id = $1
-        `, receipt.ID)
+		receipt := row.result()
+		receipts = append(receipts, receipt)
+		receiptIDs = append(receiptIDs, receipt.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate receipts", zap.Error(err))
+		return nil, err
+	}
+	rows.Close()
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, db, receiptIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range receipts {
+		receipts[i].Items = itemsByReceipt[receipts[i].ID]
+	}
+
+	executionTime := time.Since(startTime)
+	config.Log.Info("GetAllReceipts executed",
+		zap.Int("receipts", len(receipts)),
+		zap.Duration("duration", executionTime))
+
+	return receipts, nil
+}
+
+// getItemsForReceipts fetches the items (with their SKUs) for a set of receipts
+// in a single query and groups them by receipt ID.
+func getItemsForReceipts(ctx context.Context, db querier, receiptIDs []string) (map[uuid.UUID][]Item, error) {
+	itemsByReceipt := make(map[uuid.UUID][]Item, len(receiptIDs))
+	if len(receiptIDs) == 0 {
+		return itemsByReceipt, nil
+	}
+
+	rows, err := db.Query(ctx, `
+        SELECT i.receipt_id, i.id, i.short_description, i.quantity, i.price_paid, COALESCE(i.gtin, ''), COALESCE(i.category_id, 0),
+            s.unique_identifier, COALESCE(s.prefix, ''), COALESCE(s.product_category, ''), COALESCE(s.manufacturer, ''),
+            COALESCE(s.product_line, ''), s.attributes, COALESCE(s.format, ''), COALESCE(s.raw, '')
+        FROM items i
+        LEFT JOIN skus s ON s.prefix = i.sku_prefix AND s.unique_identifier = i.sku_id
+        WHERE i.receipt_id = ANY($1::uuid[])
+        ORDER BY i.receipt_id, i.id
+    `, receiptIDs)
+	if err != nil {
+		config.Log.Error("Failed to retrieve items for receipts", zap.Int("receipts", len(receiptIDs)), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item Item
+		var sku SKU
+		var skuID *string
+		err := rows.Scan(
+			&item.ReceiptID, &item.ID, &item.ShortDescription, &item.Quantity, &item.PricePaid, &item.GTIN, &item.CategoryID,
+			&skuID, &sku.Prefix, &sku.ProductCategory, &sku.Manufacturer, &sku.ProductLine, &sku.Attributes, &sku.Format, &sku.Raw)
 		if err != nil {
-			config.Log.Error("Failed to retrieve items for receipt", zap.String("receipt_id", receipt.ID.String()), zap.Error(err))
+			config.Log.Error("Failed to scan item", zap.Error(err))
 			return nil, err
 		}
-		defer itemRows.Close()
-
-		for itemRows.Next() {
-			var item Item
-			var sku SKU
-			err := itemRows.Scan(
-				&item.ID, &item.ShortDescription, &item.Quantity, &item.PricePaid,
-				&sku.UniqueIdentifier, &sku.Prefix, &sku.ProductCategory, &sku.Manufacturer, &sku.ProductLine, &sku.Attributes)
-			if err != nil {
-				config.Log.Error("Failed to scan item", zap.Error(err))
-				return nil, err
-			}
 
-			item.ReceiptID = receipt.ID // Set the ReceiptID to the current receipt's ID
+		// Items without a SKU keep the zero SKU
+		if skuID != nil {
+			sku.UniqueIdentifier = *skuID
 			item.SKU = sku
-			receipt.Items = append(receipt.Items, item)
 		}
-		//itemRows.Close()
-
-		receipts = append(receipts, receipt)
+		itemsByReceipt[item.ReceiptID] = append(itemsByReceipt[item.ReceiptID], item)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate items", zap.Error(err))
+		return nil, err
 	}
 
-	executionTime := time.Since(startTime)
-	config.Log.Info("GetAllReceipts executed", zap.Duration("duration", executionTime))
-
-	return receipts, nil
+	return itemsByReceipt, nil
 }
 
 // Helper Functions:
@@ -513,6 +631,27 @@ func calculatePointsFromRetailerAlphaNumChar(retailer string) uint {
 }
 
 func calculatePointsFromTotal(total string) uint {
+	return calculatePointsFromRoundDollarTotal(total) + calculatePointsFromQuarterTotal(total)
+}
+
+func calculatePointsFromRoundDollarTotal(total string) uint {
+	points := uint(0)
+
+	totalFloat, err := strconv.ParseFloat(total, 64)
+	if err != nil {
+		config.Log.Error("Error parsing total", zap.String("total", total), zap.Error(err))
+		return points
+	}
+
+	if math.Mod(totalFloat*100, 100) == 0 {
+		points += 50
+	}
+
+	config.Log.Info("Calculated points from round dollar total", zap.String("total", total), zap.Uint("points", points))
+	return points
+}
+
+func calculatePointsFromQuarterTotal(total string) uint {
 	points := uint(0)
 
 	totalFloat, err := strconv.ParseFloat(total, 64)
@@ -523,12 +662,9 @@ func calculatePointsFromTotal(total string) uint {
 
 	if math.Mod(totalFloat, 0.25) == 0 {
 		points += 25
-		if math.Mod(totalFloat*100, 100) == 0 {
-			points += 50
-		}
 	}
 
-	config.Log.Info("Calculated points from total", zap.String("total", total), zap.Uint("points", points))
+	config.Log.Info("Calculated points from quarter total", zap.String("total", total), zap.Uint("points", points))
 	return points
 }
 
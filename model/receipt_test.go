@@ -3,19 +3,29 @@
 package model
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"testing"
 
 	"fmt"
+	"math"
+	"math/rand"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"rcpt-proc-challenge-ans/config"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pressly/goose/v3"
 	"go.uber.org/zap"
 )
 
@@ -103,191 +113,2410 @@ func TestCalculatePoints(t *testing.T) {
 			if receipt.Points != testCase.ExpectedPoints {
 				t.Errorf("Test case %s failed. Expected %d points, got %d", testCase.Name, testCase.ExpectedPoints, receipt.Points)
 			}
+
+            // Every rule is in the breakdown, which adds up to the points
+            sum := uint(0)
+            for _, rule := range PointsRuleNames {
+                points, ok := receipt.PointsBreakdown[rule]
+                if !ok {
+                    t.Errorf("Test case %s failed. Rule %s missing from breakdown %v", testCase.Name, rule, receipt.PointsBreakdown)
+                }
+                sum += points
+            }
+            if len(receipt.PointsBreakdown) != len(PointsRuleNames) || sum != receipt.Points {
+                t.Errorf("Test case %s failed. Breakdown %v does not add up to %d points", testCase.Name, receipt.PointsBreakdown, receipt.Points)
+            }
 		})
 	}
 }
 
 
-func TestCleanItemShortDescription(t *testing.T) {
-    testCases := []struct {
-        name     string
-        input    []Item
-        expected []Item
-    }{
-        {
-            name: "Clean descriptions",
-            input: []Item{
-                {ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  "},
-                {ShortDescription: "Mountain Dew 12PK"},
-            },
-            expected: []Item{
-                {ShortDescription: "Klarbrunn 12-PK 12 FL OZ"},
-                {ShortDescription: "Mountain Dew 12PK"},
-            },
-        },
-    }
+func TestCleanItemShortDescription(t *testing.T) {
+    testCases := []struct {
+        name     string
+        input    []Item
+        expected []Item
+    }{
+        {
+            name: "Clean descriptions",
+            input: []Item{
+                {ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  "},
+                {ShortDescription: "Mountain Dew 12PK"},
+            },
+            expected: []Item{
+                {ShortDescription: "Klarbrunn 12-PK 12 FL OZ"},
+                {ShortDescription: "Mountain Dew 12PK"},
+            },
+        },
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            receipt := Receipt{Items: tc.input}
+            receipt.CleanItemShortDescriptions()
+            
+            if !reflect.DeepEqual(receipt.Items, tc.expected) {
+                t.Errorf("Expected %v, got %v", tc.expected, receipt.Items)
+            }
+        })
+    }
+}
+
+// Calculation Tests:
+func TestCalculatePointsFromRetailerAlphaNumChar(t *testing.T) {
+    testCases := []struct {
+        retailer string
+        expected uint
+    }{
+        {"Target", 6},
+        {"123", 3},
+        {"Walmart!", 7},
+        {"", 0},
+    }
+
+    for _, tc := range testCases {
+        result := calculatePointsFromRetailerAlphaNumChar(tc.retailer)
+        if result != tc.expected {
+            t.Errorf("For retailer %s, expected %d, got %d", tc.retailer, tc.expected, result)
+        }
+    }
+}
+
+func TestCalculatePointsFromTotal(t *testing.T) {
+    testCases := []struct {
+        total    string
+        expected uint
+    }{
+        {"10.00", 75},
+        {"10.25", 25},
+        {"10.50", 25},
+        {"10.75", 25},
+        {"10.99", 0},
+    }
+
+    for _, tc := range testCases {
+        result := calculatePointsFromTotal(tc.total)
+        if result != tc.expected {
+            t.Errorf("For total %s, expected %d, got %d", tc.total, tc.expected, result)
+        }
+    }
+}
+
+func TestReceiptFingerprint(t *testing.T) {
+    base := Receipt{
+        Retailer:     "M&M Corner Market",
+        PurchaseDate: "2022-03-20",
+        PurchaseTime: "14:33",
+        Total:        "9.00",
+        Items: []Item{
+            {ShortDescription: "Gatorade", Quantity: 1, PricePaid: "2.25"},
+            {ShortDescription: "Doritos Nacho Cheese", Quantity: 1, PricePaid: "6.75"},
+        },
+    }
+
+    // Same paper receipt, typed differently
+    retyped := Receipt{
+        Retailer:     "m&m  corner market",
+        PurchaseDate: "2022-03-20",
+        PurchaseTime: "14:33",
+        Total:        "9",
+        Items: []Item{
+            {ShortDescription: "DORITOS Nacho Cheese", Quantity: 1, PricePaid: "6.750"},
+            {ShortDescription: "Gatorade", Quantity: 1, PricePaid: "2.25"},
+        },
+    }
+
+    if base.Fingerprint() != retyped.Fingerprint() {
+        t.Errorf("Expected equivalent receipts to share a fingerprint")
+    }
+
+    different := base
+    different.PurchaseTime = "14:34"
+    if base.Fingerprint() == different.Fingerprint() {
+        t.Errorf("Expected a different purchase time to change the fingerprint")
+    }
+
+    different = base
+    different.Items = []Item{base.Items[0], {ShortDescription: "Doritos Nacho Cheese", Quantity: 2, PricePaid: "6.75"}}
+    if base.Fingerprint() == different.Fingerprint() {
+        t.Errorf("Expected a different item quantity to change the fingerprint")
+    }
+}
+
+func TestAssessFraud(t *testing.T) {
+    // History-based heuristics are switched off so no database is needed
+    rules := config.DefaultFraudRules()
+    rules.OutlierWeight = 0
+
+    testCases := []struct {
+        name          string
+        receipt       Receipt
+        expectFlagged bool
+    }{
+        {
+            name: "Ordinary receipt",
+            receipt: Receipt{
+                Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", Total: "35.35",
+                Items: []Item{
+                    {ShortDescription: "Mountain Dew 12PK", PricePaid: "6.49"},
+                    {ShortDescription: "Emils Cheese Pizza", PricePaid: "12.25"},
+                    {ShortDescription: "Knorr Creamy Chicken", PricePaid: "1.26"},
+                    {ShortDescription: "Doritos Nacho Cheese", PricePaid: "3.35"},
+                    {ShortDescription: "Klarbrunn 12-PK 12 FL OZ", PricePaid: "12.00"},
+                },
+            },
+            expectFlagged: false,
+        },
+        {
+            name: "Crafted to maximize points",
+            receipt: Receipt{
+                Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "14:01", Total: "2.00",
+                Items: []Item{
+                    {ShortDescription: "Gum...", PricePaid: "1.00"},
+                    {ShortDescription: "Mint xx", PricePaid: "1.00"},
+                },
+            },
+            expectFlagged: true,
+        },
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            receipt := tc.receipt
+            receipt.CalculatePoints()
+            assessment := AssessFraud(nil, &receipt, rules)
+
+            if assessment.Flagged != tc.expectFlagged {
+                t.Errorf("Expected flagged=%v, got %+v", tc.expectFlagged, assessment)
+            }
+            expectStatus := ReceiptStatusApproved
+            if tc.expectFlagged {
+                expectStatus = ReceiptStatusPending
+            }
+            if receipt.Status != expectStatus {
+                t.Errorf("Expected status %q, got %q", expectStatus, receipt.Status)
+            }
+            if receipt.Fraud == nil || receipt.Fraud.Score != assessment.Score {
+                t.Errorf("Expected the assessment to be set on the receipt")
+            }
+        })
+    }
+
+    t.Run("Flagged but not held", func(t *testing.T) {
+        unheld := rules
+        unheld.HoldFlagged = false
+        receipt := testCases[1].receipt
+        receipt.CalculatePoints()
+        assessment := AssessFraud(nil, &receipt, unheld)
+
+        if !assessment.Flagged || receipt.Fraud == nil || !receipt.Fraud.Flagged {
+            t.Errorf("Expected the receipt to stay flagged, got %+v", assessment)
+        }
+        if receipt.Status != ReceiptStatusApproved {
+            t.Errorf("Expected status %q, got %q", ReceiptStatusApproved, receipt.Status)
+        }
+    })
+}
+
+func TestReceiptDiff(t *testing.T) {
+    before := Receipt{
+        Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", Total: "6.49", Points: 6,
+        Items: []Item{{ID: 1, ShortDescription: "Mountain Dew 12PK", Quantity: 1, PricePaid: "6.49"}},
+    }
+    after := before
+    after.Retailer = "Target Store"
+    after.Items = []Item{{ID: 7, ShortDescription: "Mountain Dew 12PK", Quantity: 1, PricePaid: "6.49"}}
+
+    diff := receiptDiff(&before, &after)
+    if len(diff) != 1 {
+        t.Fatalf("Expected only the retailer to change, got %+v", diff)
+    }
+    if change := diff["retailer"]; change.From != "Target" || change.To != "Target Store" {
+        t.Errorf("Unexpected retailer change %+v", change)
+    }
+
+    if created := receiptDiff(nil, &after); created["retailer"].From != nil || created["retailer"].To != "Target Store" {
+        t.Errorf("Expected creation to diff against nothing, got %+v", created["retailer"])
+    }
+}
+
+/*
+	Test Search:
+*/
+func TestParseSearchQuery(t *testing.T) {
+    testCases := []struct {
+        input    string
+        expected string
+    }{
+        {input: "Doritos", expected: "(doritos)"},
+        {input: "  doritos   pizza ", expected: "(doritos) & (pizza)"},
+        {input: `"Nacho Cheese" dor*`, expected: "(nacho <-> cheese) & (dor:*)"},
+        {input: `"nacho chee*"`, expected: "(nacho <-> chee:*)"},
+        {input: "12-PK", expected: "(12 <-> pk)"},
+        {input: `'; DROP TABLE receipts; --`, expected: "(drop) & (table) & (receipts)"},
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.input, func(t *testing.T) {
+            query, err := ParseSearchQuery(tc.input)
+            if err != nil {
+                t.Fatalf("Failed to parse query: %v", err)
+            }
+            if query.TSQuery() != tc.expected {
+                t.Errorf("Expected %q, got %q", tc.expected, query.TSQuery())
+            }
+        })
+    }
+
+    if _, err := ParseSearchQuery(` "" * `); !errors.Is(err, ErrEmptySearchQuery) {
+        t.Errorf("Expected ErrEmptySearchQuery, got %v", err)
+    }
+}
+
+func TestSearchReceiptsInMemory(t *testing.T) {
+    for _, tc := range SearchTestCases {
+        t.Run(tc.Query, func(t *testing.T) {
+            query, err := ParseSearchQuery(tc.Query)
+            if err != nil {
+                t.Fatalf("Failed to parse query: %v", err)
+            }
+
+            results, total := SearchReceiptsInMemory(SearchTestReceipts, query, 10, 0)
+            retailers := []string{}
+            for _, result := range results {
+                retailers = append(retailers, result.Receipt.Retailer)
+            }
+            if !reflect.DeepEqual(retailers, tc.Expected) || total != len(tc.Expected) {
+                t.Errorf("Expected %v, got %v (total %d)", tc.Expected, retailers, total)
+            }
+        })
+    }
+}
+
+/*
+	Test SKU Methods:
+*/
+func TestSKUParsing(t *testing.T) {
+    for _, tc := range SKUParsingTestCases {
+        t.Run(tc.Name, func(t *testing.T) {
+            var sku SKU
+            err := sku.ParseSKU(tc.Input)
+            if tc.ExpectError {
+                if err == nil {
+                    t.Errorf("Expected error for input %s, got nil", tc.Input)
+                }
+            } else {
+                if err != nil {
+                    t.Fatalf("Failed to parse SKU %s: %v", tc.Input, err)
+                }
+                if !reflect.DeepEqual(sku, tc.Expected) {
+                    t.Errorf("Expected %+v, got %+v", tc.Expected, sku)
+                }
+            }
+        })
+    }
+}
+
+// TestSKURoundTrip checks that any SKU ParseSKU can represent serializes
+// the same way every time and parses back to itself, directly and via JSON.
+func TestSKURoundTrip(t *testing.T) {
+    random := rand.New(rand.NewSource(1))
+    // Separators, escapes and multi-byte runes are over-represented on purpose
+    alphabet := []rune("AZ09-\\ é")
+    randomPart := func(minLength int) string {
+        part := make([]rune, minLength+random.Intn(6))
+        for i := range part {
+            part[i] = alphabet[random.Intn(len(alphabet))]
+        }
+        return string(part)
+    }
+
+    for i := 0; i < 1000; i++ {
+        sku := SKU{
+            Prefix:           randomPart(0),
+            ProductCategory:  randomPart(0),
+            Manufacturer:     randomPart(0),
+            ProductLine:      randomPart(0),
+            Attributes:       map[string]string{},
+            UniqueIdentifier: randomPart(1),
+        }
+        for n := random.Intn(4); n > 0; n-- {
+            sku.Attributes[randomPart(1)] = randomPart(0)
+        }
+
+        encoded := sku.CombinePartsToString()
+        if again := sku.CombinePartsToString(); again != encoded {
+            t.Fatalf("Expected a stable serialization of %+v, got %q and %q", sku, encoded, again)
+        }
+
+        var parsed SKU
+        if err := parsed.ParseSKU(encoded); err != nil {
+            t.Fatalf("Failed to parse %q: %v", encoded, err)
+        }
+        if !reflect.DeepEqual(parsed, sku) {
+            t.Fatalf("Expected %q to parse back to %+v, got %+v", encoded, sku, parsed)
+        }
+
+        data, err := json.Marshal(sku)
+        if err != nil {
+            t.Fatalf("Failed to marshal %+v: %v", sku, err)
+        }
+        var unmarshaled SKU
+        if err := json.Unmarshal(data, &unmarshaled); err != nil {
+            t.Fatalf("Failed to unmarshal %s: %v", data, err)
+        }
+        if !reflect.DeepEqual(unmarshaled, sku) {
+            t.Fatalf("Expected %s to unmarshal to %+v, got %+v", data, sku, unmarshaled)
+        }
+    }
+}
+
+func TestParseRetailerSKU(t *testing.T) {
+    testCases := []struct {
+        name     string
+        input    string
+        retailer string
+        expected SKU
+    }{
+        {
+            name:     "SKU grammar",
+            input:    "WMT-GROC-NESTLE-CHOC-WEIGHT-100G-67890",
+            retailer: "Target",
+            expected: SKU{Prefix: "WMT", ProductCategory: "GROC", Manufacturer: "NESTLE", ProductLine: "CHOC",
+                Attributes: map[string]string{"WEIGHT": "100G"}, UniqueIdentifier: "67890"},
+        },
+        {
+            name:     "Walmart item number",
+            input:    "552315461",
+            retailer: "Walmart Supercenter #1234",
+            expected: SKU{Prefix: "WMT", Attributes: map[string]string{}, UniqueIdentifier: "552315461",
+                Format: "walmart-item", Raw: "552315461"},
+        },
+        {
+            name:     "Target DPCI",
+            input:    "071-03-0612",
+            retailer: "TARGET",
+            expected: SKU{Prefix: "TGT", ProductCategory: "071", ProductLine: "03", Attributes: map[string]string{},
+                UniqueIdentifier: "071-03-0612", Format: "target-dpci", Raw: "071-03-0612"},
+        },
+        {
+            name:     "Target DPCI keyed by prefix",
+            input:    "TGT-071-03-0612",
+            expected: SKU{Prefix: "TGT", ProductCategory: "071", ProductLine: "03", Attributes: map[string]string{},
+                UniqueIdentifier: "071-03-0612", Format: "target-dpci", Raw: "TGT-071-03-0612"},
+        },
+        {
+            name:     "Target TCIN",
+            input:    "13288347",
+            retailer: "Target",
+            expected: SKU{Prefix: "TGT", Attributes: map[string]string{}, UniqueIdentifier: "13288347",
+                Format: "target-tcin", Raw: "13288347"},
+        },
+        {
+            name:     "Amazon ASIN",
+            input:    "b07xj8c8f5",
+            retailer: "Amazon.com",
+            expected: SKU{Prefix: "AMZ", Attributes: map[string]string{}, UniqueIdentifier: "B07XJ8C8F5",
+                Format: "amazon-asin", Raw: "b07xj8c8f5"},
+        },
+        {
+            name:     "Amazon ISBN-10 keyed by prefix",
+            input:    "AMZ-030640615X",
+            expected: SKU{Prefix: "AMZ", Attributes: map[string]string{}, UniqueIdentifier: "030640615X",
+                Format: "amazon-asin", Raw: "AMZ-030640615X"},
+        },
+        {
+            name:     "Format of another retailer",
+            input:    "552315461",
+            retailer: "Target",
+            expected: SKU{Prefix: "TGT", Attributes: map[string]string{}, UniqueIdentifier: "552315461",
+                Format: SKUFormatRaw, Raw: "552315461"},
+        },
+        {
+            name:     "Unknown retailer",
+            input:    "ABC/123 XL",
+            retailer: "Corner Store",
+            expected: SKU{Prefix: "CORNERSTORE", Attributes: map[string]string{}, UniqueIdentifier: "ABC/123 XL",
+                Format: SKUFormatRaw, Raw: "ABC/123 XL"},
+        },
+        {
+            name:     "Another unknown retailer",
+            input:    "ABC/123 XL",
+            retailer: "Bob's Hardware",
+            expected: SKU{Prefix: "BOBSHARDWARE", Attributes: map[string]string{}, UniqueIdentifier: "ABC/123 XL",
+                Format: SKUFormatRaw, Raw: "ABC/123 XL"},
+        },
+        {
+            name:     "No retailer",
+            input:    "ABC/123 XL",
+            expected: SKU{Attributes: map[string]string{}, UniqueIdentifier: "ABC/123 XL",
+                Format: SKUFormatRaw, Raw: "ABC/123 XL"},
+        },
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            sku, err := ParseRetailerSKU(tc.input, tc.retailer)
+            if err != nil {
+                t.Fatalf("Failed to parse %q: %v", tc.input, err)
+            }
+            if !reflect.DeepEqual(sku, tc.expected) {
+                t.Errorf("Expected %+v, got %+v", tc.expected, sku)
+            }
+            if sku.CombinePartsToString() != tc.input {
+                t.Errorf("Expected %q to serialize as submitted, got %q", tc.input, sku.CombinePartsToString())
+            }
+        })
+    }
+
+    for _, input := range []string{"", strings.Repeat("X", 256)} {
+        if _, err := ParseRetailerSKU(input, ""); !errors.Is(err, ErrInvalidSKU) {
+            t.Errorf("Expected ErrInvalidSKU for a SKU of length %d, got %v", len(input), err)
+        }
+    }
+
+    // Decoding does not know the retailer yet; ResolveSKUs catches up
+    var receipt Receipt
+    err := json.Unmarshal([]byte(`{"retailer": "Target", "items": [{"sku": "071-03-0612"}]}`), &receipt)
+    if err != nil {
+        t.Fatalf("Failed to decode receipt: %v", err)
+    }
+    if receipt.Items[0].SKU.Format != SKUFormatRaw {
+        t.Errorf("Expected a raw SKU before resolving, got %q", receipt.Items[0].SKU.Format)
+    }
+    receipt.ResolveSKUs()
+    if receipt.Items[0].SKU.Format != "target-dpci" {
+        t.Errorf("Expected a Target DPCI after resolving, got %q", receipt.Items[0].SKU.Format)
+    }
+}
+
+func TestNormalizeGTIN(t *testing.T) {
+    testCases := []struct {
+        input    string
+        expected string
+    }{
+        {input: "012000001291", expected: "00012000001291"},
+        {input: "0 12000 00129 1", expected: "00012000001291"},
+        {input: "4006381333931", expected: "04006381333931"},
+        {input: "10012000001298", expected: "10012000001298"},
+        {input: "012000001292"},
+        {input: "40063813339"},
+        {input: "4006381333931X"},
+    }
+
+    for _, tc := range testCases {
+        t.Run(tc.input, func(t *testing.T) {
+            gtin, err := NormalizeGTIN(tc.input)
+            if tc.expected == "" {
+                if !errors.Is(err, ErrInvalidGTIN) {
+                    t.Errorf("Expected ErrInvalidGTIN, got %q (err=%v)", gtin, err)
+                }
+                return
+            }
+            if err != nil || gtin != tc.expected {
+                t.Errorf("Expected %q, got %q (err=%v)", tc.expected, gtin, err)
+            }
+        })
+    }
+
+    if upc, ok := GTINToUPCA("00012000001291"); !ok || upc != "012000001291" {
+        t.Errorf("Expected UPC-A 012000001291, got %q", upc)
+    }
+    if ean, ok := GTINToEAN13("04006381333931"); !ok || ean != "4006381333931" {
+        t.Errorf("Expected EAN-13 4006381333931, got %q", ean)
+    }
+    if _, ok := GTINToUPCA("04006381333931"); ok {
+        t.Errorf("Expected no UPC-A form of an EAN-13 outside the UPC range")
+    }
+    if _, ok := GTINToEAN13("10012000001298"); ok {
+        t.Errorf("Expected no EAN-13 form of a GTIN-14 with a packaging indicator")
+    }
+
+    // Items may come without a SKU
+    var item Item
+    if err := json.Unmarshal([]byte(`{"shortDescription": "Paper Bag", "gtin": "012000001291"}`), &item); err != nil {
+        t.Fatalf("Failed to decode item without a SKU: %v", err)
+    }
+    if !item.SKU.IsZero() {
+        t.Errorf("Expected the zero SKU, got %+v", item.SKU)
+    }
+    encoded, _ := json.Marshal(item)
+    if !strings.Contains(string(encoded), `"sku":null`) {
+        t.Errorf("Expected a null SKU, got %s", encoded)
+    }
+}
+
+/*
+	Test Export Methods:
+*/
+func TestTaxonomy(t *testing.T) {
+    food, snacks, beverages := 1, 2, 3
+    taxonomy := NewTaxonomy([]Category{
+        {ID: 5, ParentID: &beverages, Name: "Soda", Keywords: []string{"soda", "cola", "dr pepper"}},
+        {ID: food, Name: "Food", Keywords: []string{"food"}},
+        {ID: snacks, ParentID: &food, Name: "Snacks", Keywords: []string{"snack", "chips"}},
+        {ID: beverages, ParentID: &food, Name: "Beverages", Keywords: []string{"drink"}},
+        {ID: 4, ParentID: &snacks, Name: "Chips", Keywords: []string{"doritos", "chip"}},
+        {ID: 6, Name: "Household", Keywords: []string{"paper towels"}},
+    }, map[string]int{"BVRG": beverages, "GONE": 99})
+
+    if chips, ok := taxonomy.Category(4); !ok || !reflect.DeepEqual(chips.Path, []string{"Food", "Snacks", "Chips"}) {
+        t.Errorf("Expected the path Food > Snacks > Chips, got %+v", chips)
+    }
+    if tree := taxonomy.Tree(); len(tree) != 2 || tree[0].Name != "Food" || len(tree[0].Children) != 2 {
+        t.Errorf("Expected the roots Food and Household, got %+v", tree)
+    }
+
+    testCases := []struct {
+        name     string
+        item     Item
+        expected int
+    }{
+        {"mapped SKU code", Item{SKU: SKU{ProductCategory: "BVRG", UniqueIdentifier: "1"}, ShortDescription: "Doritos"}, beverages},
+        {"unmapped SKU code", Item{SKU: SKU{ProductCategory: "MISC", UniqueIdentifier: "1"}, ShortDescription: "Doritos"}, 4},
+        {"mapping to a missing category", Item{SKU: SKU{ProductCategory: "GONE", UniqueIdentifier: "1"}, ShortDescription: "Cola"}, 5},
+        {"deepest category wins", Item{ShortDescription: "Doritos Snack Pack"}, 4},
+        {"plural", Item{ShortDescription: "Fruit Snacks"}, snacks},
+        {"plural of a deeper keyword", Item{ShortDescription: "Tortilla Chips"}, 4},
+        {"phrase", Item{ShortDescription: "Bounty Paper Towels 6ct"}, 6},
+        {"phrase beats word", Item{ShortDescription: "Dr Pepper Drink"}, 5},
+        {"first found wins a tie", Item{ShortDescription: "Cola Soda"}, 5},
+        {"phrase out of order", Item{ShortDescription: "Towels Paper"}, 0},
+        {"no keyword", Item{ShortDescription: "Mystery Item"}, 0},
+        {"part of a word", Item{ShortDescription: "Chipotle Sauce"}, 0},
+    }
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            if id, _ := taxonomy.CategoryFor(tc.item); id != tc.expected {
+                t.Errorf("Expected category %d for %+v, got %d", tc.expected, tc.item, id)
+            }
+        })
+    }
+
+    if keywords := normalizeKeywords([]string{" Paper-Towels ", "paper towels", "", "COLA"}); !reflect.DeepEqual(keywords, []string{"paper towels", "cola"}) {
+        t.Errorf("Unexpected normalized keywords %v", keywords)
+    }
+}
+
+func TestRetailerNormalizer(t *testing.T) {
+    normalizer := NewRetailerNormalizer([]Retailer{
+        {ID: 1, Name: "Target", Aliases: []string{"target*", "SuperTarget*"}},
+        {ID: 2, Name: "Walmart", Aliases: []string{"walmart*", "*"}},
+        {ID: 3, Name: "M&M Corner Market", Aliases: []string{"m&m corner*", "mm?corner*"}},
+        {ID: 4, Name: "Target Optical", Aliases: []string{"targetoptical*"}},
+    })
+
+    testCases := []struct {
+        raw      string
+        expected int
+    }{
+        {"Target", 1},
+        {"TARGET #1234", 1},
+        {"SuperTarget", 1},
+        {"Target Optical #12", 4},
+        {"Walmart Supercenter", 2},
+        {"M&M Corner Market", 3},
+        {"MM Corner Market", 3},
+        {"M & M CORNER MARKET #2", 3},
+        {"MMX Corner Market", 3},
+        {"Corner Store", 0},
+        {"#1234", 0},
+    }
+    for _, tc := range testCases {
+        t.Run(tc.raw, func(t *testing.T) {
+            retailer, ok := normalizer.Normalize(tc.raw)
+            if retailer.ID != tc.expected || ok != (tc.expected != 0) {
+                t.Errorf("Expected retailer %d for %q, got %+v (ok=%v)", tc.expected, tc.raw, retailer, ok)
+            }
+        })
+    }
+
+    if aliases := normalizeRetailerAliases([]string{"Target-*", "target*", "*", "?*", ""}); !reflect.DeepEqual(aliases, []string{"target*"}) {
+        t.Errorf("Unexpected normalized aliases %v", aliases)
+    }
+
+    receipt := Receipt{Retailer: "TARGET #1234", CanonicalRetailer: "Target", Total: "1.01",
+        PurchaseDate: "2022-01-02", PurchaseTime: "08:00"}
+    receipt.CalculatePoints()
+    if receipt.Points != 10 {
+        t.Errorf("Expected the raw name to earn 10 points, got %d", receipt.Points)
+    }
+    receipt.CalculatePointsWithRules(config.PointsRules{RetailerName: config.PointsRetailerCanonical})
+    if receipt.Points != 6 {
+        t.Errorf("Expected the canonical name to earn 6 points, got %d", receipt.Points)
+    }
+}
+
+func TestStoreLocation(t *testing.T) {
+    coordinate := func(value float64) *float64 { return &value }
+
+    testCases := []struct {
+        name  string
+        store *Store
+        valid bool
+    }{
+        {"no store", nil, true},
+        {"store number only", &Store{StoreNumber: " 1234 "}, true},
+        {"address with coordinates", &Store{Address: "1 Main St", Latitude: coordinate(44.97), Longitude: coordinate(-93.27)}, true},
+        {"neither number nor address", &Store{Latitude: coordinate(44.97), Longitude: coordinate(-93.27)}, false},
+        {"latitude alone", &Store{StoreNumber: "1", Latitude: coordinate(44.97)}, false},
+        {"latitude out of range", &Store{StoreNumber: "1", Latitude: coordinate(91), Longitude: coordinate(0)}, false},
+        {"longitude out of range", &Store{StoreNumber: "1", Latitude: coordinate(0), Longitude: coordinate(-181)}, false},
+        {"coordinates not a number", &Store{StoreNumber: "1", Latitude: coordinate(math.NaN()), Longitude: coordinate(0)}, false},
+        {"store number too long", &Store{StoreNumber: strings.Repeat("1", 51)}, false},
+        {"address too long", &Store{Address: strings.Repeat("Main St ", 100)}, false},
+    }
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            receipt := Receipt{Store: tc.store}
+            err := receipt.NormalizeStore()
+            if tc.valid && err != nil {
+                t.Errorf("Expected a valid store, got %v", err)
+            } else if !tc.valid && !errors.Is(err, ErrInvalidStore) {
+                t.Errorf("Expected ErrInvalidStore, got %v", err)
+            }
+        })
+    }
+
+    if key := storeKey(&Store{StoreNumber: "T-1234", Address: "1 Main St"}); key != "#t1234" {
+        t.Errorf("Expected the store number to identify the store, got %q", key)
+    }
+    if key := storeKey(&Store{Address: "1  Main St."}); key != "@1 main st" {
+        t.Errorf("Expected the address to identify the store, got %q", key)
+    }
+
+    // Minneapolis to St. Paul, about 14 km apart
+    if distance := DistanceKm(44.9778, -93.2650, 44.9537, -93.0900); math.Abs(distance-14) > 1 {
+        t.Errorf("Expected about 14 km, got %.2f", distance)
+    }
+    if distance := DistanceKm(10, 20, 10, 20); distance != 0 {
+        t.Errorf("Expected no distance between a point and itself, got %f", distance)
+    }
+
+    // A degree of longitude is half as long at 60 degrees north
+    latSpan, lonSpan := searchSpans(60, 10)
+    if math.Abs(latSpan-0.0899) > 0.001 || math.Abs(lonSpan-2*latSpan) > 0.001 {
+        t.Errorf("Expected spans of about 0.09 and 0.18 degrees, got %f and %f", latSpan, lonSpan)
+    }
+    if _, lonSpan := searchSpans(-89.95, 10); lonSpan != 180 {
+        t.Errorf("Expected every longitude within reach of the pole, got %f", lonSpan)
+    }
+}
+
+func TestSpendInMemory(t *testing.T) {
+    food, snacks := 1, 2
+    taxonomy := NewTaxonomy([]Category{
+        {ID: food, Name: "Food"},
+        {ID: snacks, ParentID: &food, Name: "Snacks"},
+        {ID: 3, Name: "Household"},
+    }, nil)
+
+    receipts := []Receipt{
+        {Retailer: "TARGET #1234", CanonicalRetailer: "Target", PurchaseDate: "2024-03-04", Total: "10.00", MemberID: "m1",
+            Items: []Item{{PricePaid: "6.00", CategoryID: snacks}, {PricePaid: "4.00", CategoryID: food}}},
+        {Retailer: "Target", CanonicalRetailer: "Target", PurchaseDate: "2024-03-10", Total: "5.50",
+            Items: []Item{{PricePaid: "5.50", CategoryID: 3}}},
+        {Retailer: "Corner Shop", PurchaseDate: "2024-04-01", Total: "2.25", MemberID: "m1",
+            Items: []Item{{PricePaid: "2.25"}}},
+        {Retailer: "Target", CanonicalRetailer: "Target", PurchaseDate: "2024-03-05", Total: "99.00", Status: ReceiptStatusRejected,
+            Items: []Item{{PricePaid: "99.00", CategoryID: snacks}}},
+    }
+
+    testCases := []struct {
+        name     string
+        filter   SpendFilter
+        total    float64
+        expected []SpendGroup
+    }{
+        {"by retailer", SpendFilter{GroupBy: SpendByRetailer}, 17.75, []SpendGroup{
+            {Key: "Target", Total: 15.5, Receipts: 2, Items: 3},
+            {Key: "Corner Shop", Total: 2.25, Receipts: 1, Items: 1},
+        }},
+        {"by member", SpendFilter{GroupBy: SpendByMember}, 17.75, []SpendGroup{
+            {Key: "m1", Total: 12.25, Receipts: 2, Items: 3},
+            {Key: "", Total: 5.5, Receipts: 1, Items: 1},
+        }},
+        {"by week", SpendFilter{GroupBy: SpendByWeek}, 17.75, []SpendGroup{
+            {Key: "2024-03-04", Total: 15.5, Receipts: 2, Items: 3},
+            {Key: "2024-04-01", Total: 2.25, Receipts: 1, Items: 1},
+        }},
+        {"by month within a range", SpendFilter{FromDate: "2024-03-05", ToDate: "2024-04-30"}, 7.75, []SpendGroup{
+            {Key: "2024-03-01", Total: 5.5, Receipts: 1, Items: 1},
+            {Key: "2024-04-01", Total: 2.25, Receipts: 1, Items: 1},
+        }},
+        {"by category, rolled up", SpendFilter{GroupBy: SpendByCategory}, 17.75, []SpendGroup{
+            {Key: "Food", CategoryID: food, Total: 10, Receipts: 1, Items: 2},
+            {Key: "Food > Snacks", CategoryID: snacks, Total: 6, Receipts: 1, Items: 1},
+            {Key: "Household", CategoryID: 3, Total: 5.5, Receipts: 1, Items: 1},
+            {Key: "Uncategorized", Total: 2.25, Receipts: 1, Items: 1},
+        }},
+        {"of a member", SpendFilter{GroupBy: SpendByRetailer, MemberID: "m1"}, 12.25, []SpendGroup{
+            {Key: "Target", Total: 10, Receipts: 1, Items: 2},
+            {Key: "Corner Shop", Total: 2.25, Receipts: 1, Items: 1},
+        }},
+    }
+    for _, tc := range testCases {
+        t.Run(tc.name, func(t *testing.T) {
+            report, err := SpendInMemory(receipts, taxonomy, tc.filter)
+            if err != nil {
+                t.Fatalf("Failed to report spend: %v", err)
+            }
+            if report.Total != tc.total || !reflect.DeepEqual(report.Groups, tc.expected) {
+                t.Errorf("Expected %.2f in %+v, got %.2f in %+v", tc.total, tc.expected, report.Total, report.Groups)
+            }
+        })
+    }
+
+    if _, err := SpendInMemory(receipts, nil, SpendFilter{GroupBy: "hour"}); !errors.Is(err, ErrInvalidSpendGrouping) {
+        t.Errorf("Expected ErrInvalidSpendGrouping, got %v", err)
+    }
+}
+
+func TestPointsReportInMemory(t *testing.T) {
+    receipts := []Receipt{
+        {Retailer: "TARGET #1234", CanonicalRetailer: "Target", PurchaseDate: "2024-03-04", Points: 30,
+            PointsBreakdown: map[string]uint{PointsRuleRetailerName: 6, PointsRuleItemPairs: 10, PointsRuleOddPurchaseDay: 0, PointsRuleQuarterTotal: 14}},
+        {Retailer: "Target", CanonicalRetailer: "Target", PurchaseDate: "2024-03-10", Points: 16,
+            PointsBreakdown: map[string]uint{PointsRuleRetailerName: 6, PointsRuleItemPairs: 10, PointsRuleOddPurchaseDay: 0}},
+        // Stored before breakdowns were kept
+        {Retailer: "Corner Shop", PurchaseDate: "2024-04-01", Points: 60},
+        {Retailer: "Target", CanonicalRetailer: "Target", PurchaseDate: "2024-03-05", Points: 500, Status: ReceiptStatusRejected},
+    }
+    for i := range receipts[:3] {
+        receipts[i].Status = ReceiptStatusApproved
+    }
+
+    report, err := PointsReportInMemory(receipts, PointsFilter{})
+    if err != nil {
+        t.Fatalf("Failed to report points: %v", err)
+    }
+
+    if report.Period != SpendByMonth || report.Receipts != 3 || report.Points != 106 || report.Average != 35.33 {
+        t.Errorf("Expected 106 points on 3 receipts by month, got %+v", report)
+    }
+    expectedRules := []RulePoints{
+        {Rule: PointsRuleUnattributed, Points: 60, Receipts: 1, Share: 0.566},
+        {Rule: PointsRuleItemPairs, Points: 20, Receipts: 2, Share: 0.1887},
+        {Rule: PointsRuleQuarterTotal, Points: 14, Receipts: 1, Share: 0.1321},
+        {Rule: PointsRuleRetailerName, Points: 12, Receipts: 2, Share: 0.1132},
+        {Rule: PointsRuleOddPurchaseDay, Points: 0, Receipts: 0, Share: 0},
+    }
+    if !reflect.DeepEqual(report.Rules, expectedRules) {
+        t.Errorf("Expected rules %+v, got %+v", expectedRules, report.Rules)
+    }
+    expectedRetailers := []PointsGroup{
+        {Key: "Corner Shop", Receipts: 1, Points: 60, Average: 60, Rules: map[string]int{PointsRuleUnattributed: 60}},
+        {Key: "Target", Receipts: 2, Points: 46, Average: 23, Rules: map[string]int{
+            PointsRuleRetailerName: 12, PointsRuleItemPairs: 20, PointsRuleOddPurchaseDay: 0, PointsRuleQuarterTotal: 14}},
+    }
+    if !reflect.DeepEqual(report.Retailers, expectedRetailers) {
+        t.Errorf("Expected retailers %+v, got %+v", expectedRetailers, report.Retailers)
+    }
+    if len(report.Periods) != 2 || report.Periods[0].Key != "2024-03-01" || report.Periods[0].Points != 46 ||
+        report.Periods[1].Key != "2024-04-01" || report.Periods[1].Points != 60 {
+        t.Errorf("Expected March then April, got %+v", report.Periods)
+    }
+    expectedHistogram := []PointsBucket{
+        {Min: 0, Max: 24, Receipts: 1},
+        {Min: 25, Max: 49, Receipts: 1},
+        {Min: 50, Max: 74, Receipts: 1},
+    }
+    if !reflect.DeepEqual(report.Histogram, expectedHistogram) {
+        t.Errorf("Expected histogram %+v, got %+v", expectedHistogram, report.Histogram)
+    }
+
+    // Weeks start on Monday; dates and bucket widths narrow the report
+    report, _ = PointsReportInMemory(receipts, PointsFilter{Period: SpendByWeek, ToDate: "2024-03-31", BucketWidth: 10})
+    if report.Receipts != 2 || len(report.Periods) != 1 || report.Periods[0].Key != "2024-03-04" ||
+        len(report.Histogram) != 4 || report.Histogram[1].Receipts != 1 || report.Histogram[3].Receipts != 1 {
+        t.Errorf("Expected 2 receipts in the weeks of March, got %+v", report)
+    }
+
+    // An empty report has empty lists
+    report, _ = PointsReportInMemory(nil, PointsFilter{})
+    if report.Receipts != 0 || report.Average != 0 || report.Rules == nil || report.Histogram == nil || len(report.Histogram) != 0 {
+        t.Errorf("Expected an empty report, got %+v", report)
+    }
+
+    if _, err := PointsReportInMemory(receipts, PointsFilter{Period: SpendByRetailer}); !errors.Is(err, ErrInvalidPointsPeriod) {
+        t.Errorf("Expected ErrInvalidPointsPeriod, got %v", err)
+    }
+    if _, err := PointsReportInMemory(receipts, PointsFilter{BucketWidth: -5}); err == nil {
+        t.Error("Expected an error for a negative bucket width")
+    }
+}
+
+func TestExportedReceiptCSVRows(t *testing.T) {
+    receiptID := uuid.MustParse("7fb1377b-b223-49d9-a31a-5a02701dd310")
+    receipt := ExportedReceipt{
+        Receipt: Receipt{
+            ID:           receiptID,
+            Retailer:     "M&M Corner Market",
+            PurchaseDate: "2022-03-20",
+            PurchaseTime: "14:33",
+            Total:        "9.00",
+            Points:       109,
+            Status:       ReceiptStatusApproved,
+            Items: []Item{
+                {
+                    ID: 1,
+                    SKU: SKU{
+                        Prefix:           "MMC",
+                        ProductCategory:  "BVRG",
+                        Manufacturer:     "PEPSICO",
+                        ProductLine:      "GATORADE",
+                        Attributes:       map[string]string{"SIZE": "20OZ"},
+                        UniqueIdentifier: "00001",
+                    },
+                    GTIN:             "00012000001291",
+                    ShortDescription: `Gatorade, "Cool Blue"`,
+                    Quantity:         4,
+                    PricePaid:        "9.00",
+                    ReceiptID:        receiptID,
+                },
+                {
+                    ID:               2,
+                    ShortDescription: "Paper Bag",
+                    Quantity:         1,
+                    PricePaid:        "0.00",
+                    ReceiptID:        receiptID,
+                },
+            },
+        },
+        CreatedAt: time.Date(2024, 8, 20, 2, 15, 12, 0, time.UTC),
+        Position:  ExportPosition{TxID: 7543, ID: receiptID},
+    }
+
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    writer.Write(CSVHeader)
+    writer.WriteAll(receipt.CSVRows())
+
+    expected := "receipt_id,retailer,purchase_date,purchase_time,total,points,created_at,item_id,short_description,quantity,price_paid,sku,sku_prefix,sku_product_category,sku_manufacturer,sku_product_line,sku_attributes,sku_unique_identifier,member_id,fraud_score,flagged,status,gtin,export_position\n" +
+        `7fb1377b-b223-49d9-a31a-5a02701dd310,M&M Corner Market,2022-03-20,14:33,9.00,109,2024-08-20T02:15:12Z,1,"Gatorade, ""Cool Blue""",4,9.00,MMC-BVRG-PEPSICO-GATORADE-SIZE-20OZ-00001,MMC,BVRG,PEPSICO,GATORADE,"{""SIZE"":""20OZ""}",00001,,0,false,approved,00012000001291,7543:7fb1377b-b223-49d9-a31a-5a02701dd310` + "\n" +
+        `7fb1377b-b223-49d9-a31a-5a02701dd310,M&M Corner Market,2022-03-20,14:33,9.00,109,2024-08-20T02:15:12Z,2,Paper Bag,1,0.00,,,,,,,,,0,false,approved,,7543:7fb1377b-b223-49d9-a31a-5a02701dd310` + "\n"
+
+    if buf.String() != expected {
+        t.Errorf("Expected CSV:\n%s\ngot:\n%s", expected, buf.String())
+    }
+
+    receipt.Items = nil
+    rows := receipt.CSVRows()
+    if len(rows) != 1 || len(rows[0]) != len(CSVHeader) || rows[0][len(CSVHeader)-1] != receipt.Position.String() {
+        t.Errorf("Expected a single %d-column row for a receipt without items, got %v", len(CSVHeader), rows)
+    }
+
+    position, err := ParseExportPosition(receipt.Position.String())
+    if err != nil || position != receipt.Position {
+        t.Errorf("Expected position %v to read back, got %v (err=%v)", receipt.Position, position, err)
+    }
+    for _, input := range []string{"", "7543", "x:" + receiptID.String(), "7543:not-a-uuid", "-1:" + receiptID.String()} {
+        if _, err := ParseExportPosition(input); !errors.Is(err, ErrInvalidExportPosition) {
+            t.Errorf("Expected ErrInvalidExportPosition for %q, got %v", input, err)
+        }
+    }
+}
+
+/*
+	Test Endpoint Methods: 
+	GET, 
+	POST
+*/
+func TestDatabaseOperations(t *testing.T) {
+	// Truncate tables before this test
+	if err := truncateTables(config.DB); err != nil {
+		t.Fatalf("Failed to truncate tables: %v", err)
+	}
+
+    t.Run("TestAddReceipt", func(t *testing.T) {
+        receipt := createTestReceipt()
+
+        // Log the receipt before adding
+        receiptJSON, _ := json.MarshalIndent(receipt, "", "  ")
+        t.Logf("Adding receipt:\n%s", string(receiptJSON))
+
+        err := AddReceipt(config.DB, receipt, testAudit)
+        if err != nil {
+            t.Errorf("Failed to add receipt: %v", err)
+        }
+    })
+
+    t.Run("TestGetReceiptByID", func(t *testing.T) {
+        receipt := createTestReceipt()
+        err := AddReceipt(config.DB, receipt, testAudit)
+        if err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        fetchedReceipt, err := GetReceiptByID(config.DB, receipt.ID)
+        if err != nil {
+            t.Errorf("Failed to get receipt by ID: %v", err)
+        }
+
+		// Log the fetched receipt
+        fetchedJSON, _ := json.MarshalIndent(fetchedReceipt, "", "  ")
+        t.Logf("Fetched receipt:\n%s", string(fetchedJSON))
+
+        if !reflect.DeepEqual(receipt, fetchedReceipt) {
+            t.Errorf("Fetched receipt does not match original")
+
+			// Log both receipts for comparison
+            originalJSON, _ := json.MarshalIndent(receipt, "", "  ")
+            t.Logf("Original receipt:\n%s", string(originalJSON))
+            t.Logf("Fetched receipt:\n%s", string(fetchedJSON))
+        }
+    })
+
+    t.Run("TestGetAllReceipts", func(t *testing.T) {
+        // Clear existing receipts
+        _, err := config.DB.Exec(context.Background(), "DELETE FROM items; DELETE FROM receipts;")
+        if err != nil {
+            t.Fatalf("Failed to clear existing receipts: %v", err)
+        }
+
+        // Add multiple receipts
+        for i := 0; i < 3; i++ {
+            receipt := createTestReceipt()
+            err := AddReceipt(config.DB, receipt, testAudit)
+            if err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
+            }
+        }
+
+        receipts, err := GetAllReceipts(config.DB)
+        if err != nil {
+            t.Errorf("Failed to get all receipts: %v", err)
+        }
+
+		// Log all fetched receipts
+        for i, r := range receipts {
+            receiptJSON, _ := json.MarshalIndent(r, "", "  ")
+            t.Logf("Receipt %d:\n%s", i+1, string(receiptJSON))
+        }
+
+        if len(receipts) != 3 {
+            t.Errorf("Expected 3 receipts, got %d", len(receipts))
+        }
+    })
+
+    t.Run("TestAddDuplicateReceipt", func(t *testing.T) {
+        receipt := createTestReceipt()
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        duplicate := *receipt
+        duplicate.ID = config.GenerateUUID()
+        err := AddReceipt(config.DB, &duplicate, testAudit)
+
+        var duplicateErr *DuplicateReceiptError
+        if !errors.As(err, &duplicateErr) {
+            t.Fatalf("Expected a DuplicateReceiptError, got %v", err)
+        }
+        if duplicateErr.ExistingID != receipt.ID {
+            t.Errorf("Expected duplicate to point at %s, got %s", receipt.ID, duplicateErr.ExistingID)
+        }
+    })
+
+    t.Run("TestAddReceipts", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        receipts := []*Receipt{createTestReceipt(), createTestReceipt(), createTestReceipt()}
+        // Reusing an ID makes the last receipt fail on its own
+        receipts[2].ID = receipts[0].ID
+
+        errs := AddReceipts(config.DB, receipts, testAudit)
+        if len(errs) != len(receipts) {
+            t.Fatalf("Expected %d results, got %d", len(receipts), len(errs))
+        }
+        if errs[0] != nil || errs[1] != nil {
+            t.Errorf("Expected the first two receipts to be stored, got %v, %v", errs[0], errs[1])
+        }
+        if errs[2] == nil {
+            t.Errorf("Expected the duplicate receipt to fail")
+        }
+
+        stored, err := GetAllReceipts(config.DB)
+        if err != nil {
+            t.Fatalf("Failed to get all receipts: %v", err)
+        }
+        if len(stored) != 2 {
+            t.Errorf("Expected 2 stored receipts, got %d", len(stored))
+        }
+    })
+
+    t.Run("TestJobLifecycle", func(t *testing.T) {
+        if _, err := config.DB.Exec(context.Background(), "TRUNCATE TABLE jobs CASCADE"); err != nil {
+            t.Fatalf("Failed to truncate jobs: %v", err)
+        }
+
+        payloads := []json.RawMessage{
+            json.RawMessage(`{"retailer": "Target"}`),
+            json.RawMessage(`{"retailer": ""}`),
+        }
+        job, err := CreateJob(config.DB, payloads, testAudit)
+        if err != nil {
+            t.Fatalf("Failed to create job: %v", err)
+        }
+
+        claimedID, attempts, ok, err := ClaimNextJob(config.DB, "worker-a")
+        if err != nil || !ok || claimedID != job.ID || attempts != 1 {
+            t.Fatalf("Expected to claim job %s, got %s (ok=%v, err=%v)", job.ID, claimedID, ok, err)
+        }
+        if _, _, ok, _ := ClaimNextJob(config.DB, "worker-b"); ok {
+            t.Errorf("Expected a running job not to be claimed twice")
+        }
+        if err := RenewJobLease(config.DB, job.ID, "worker-a"); err != nil {
+            t.Errorf("Failed to renew job lease: %v", err)
+        }
+
+        // Once the lease expires another worker takes the job over, and the
+        // first one can no longer write to it
+        if _, err := config.DB.Exec(context.Background(),
+            "UPDATE jobs SET locked_until = now() - interval '1 second' WHERE id = $1", job.ID); err != nil {
+            t.Fatalf("Failed to expire job lease: %v", err)
+        }
+        claimedID, attempts, ok, err = ClaimNextJob(config.DB, "worker-b")
+        if err != nil || !ok || claimedID != job.ID || attempts != 2 {
+            t.Fatalf("Expected to take over job %s, got %s (ok=%v, err=%v)", job.ID, claimedID, ok, err)
+        }
+        err = RecordJobItemResults(config.DB, job.ID, "worker-a", []JobItemResult{{Index: 1, Error: "stale"}})
+        if !errors.Is(err, ErrJobLeaseLost) {
+            t.Errorf("Expected ErrJobLeaseLost for the stale worker, got %v", err)
+        }
+        if err := RenewJobLease(config.DB, job.ID, "worker-a"); !errors.Is(err, ErrJobLeaseLost) {
+            t.Errorf("Expected ErrJobLeaseLost renewing a lost lease, got %v", err)
+        }
+
+        pending, err := GetPendingJobItems(config.DB, job.ID, 10)
+        if err != nil || len(pending) != 2 {
+            t.Fatalf("Expected 2 pending items, got %d (err=%v)", len(pending), err)
+        }
+
+        receipt := createTestReceipt()
+        err = RecordJobItemResults(config.DB, job.ID, "worker-b", []JobItemResult{
+            {Index: 0, Receipt: receipt},
+            {Index: 1, Error: "retailer cannot be empty"},
+        })
+        if err != nil {
+            t.Fatalf("Failed to record job results: %v", err)
+        }
+
+        // Recording an item again, as a job taken over midway might, stores nothing
+        again := createTestReceipt()
+        err = RecordJobItemResults(config.DB, job.ID, "worker-b", []JobItemResult{{Index: 0, Receipt: again}})
+        if err != nil {
+            t.Fatalf("Failed to record job results again: %v", err)
+        }
+        if _, err := GetReceiptByID(config.DB, again.ID); !errors.Is(err, pgx.ErrNoRows) {
+            t.Errorf("Expected item 0 not to be stored twice, got %v", err)
+        }
+
+        if err := CompleteJob(config.DB, job.ID, "worker-b"); err != nil {
+            t.Fatalf("Failed to complete job: %v", err)
+        }
+
+        fetched, err := GetJobByID(config.DB, job.ID)
+        if err != nil {
+            t.Fatalf("Failed to get job: %v", err)
+        }
+        if fetched.Status != JobStatusCompleted || fetched.Processed != 2 || fetched.Succeeded != 1 || fetched.Failed != 1 {
+            t.Errorf("Unexpected job progress: %+v", fetched)
+        }
+        if len(fetched.Items) != 2 || fetched.Items[0].ReceiptID == nil || *fetched.Items[0].ReceiptID != receipt.ID {
+            t.Errorf("Expected item 0 to point at receipt %s, got %+v", receipt.ID, fetched.Items)
+        }
+
+        // A job retried after an error waits out its backoff, and fails
+        // along with its pending items once it has used up its attempts
+        retried, err := CreateJob(config.DB, payloads, testAudit)
+        if err != nil {
+            t.Fatalf("Failed to create job: %v", err)
+        }
+        if _, _, ok, _ := ClaimNextJob(config.DB, "worker-a"); !ok {
+            t.Fatalf("Expected to claim job %s", retried.ID)
+        }
+        if status, err := RetryJob(config.DB, retried.ID, "worker-a"); err != nil || status != JobStatusQueued {
+            t.Fatalf("Expected the job to be queued again, got %q (err=%v)", status, err)
+        }
+        if _, _, ok, _ := ClaimNextJob(config.DB, "worker-a"); ok {
+            t.Errorf("Expected a retried job to wait out its backoff")
+        }
+        if _, err := config.DB.Exec(context.Background(),
+            "UPDATE jobs SET not_before = NULL, attempts = $2 WHERE id = $1", retried.ID, MaxJobAttempts-1); err != nil {
+            t.Fatalf("Failed to skip the backoff: %v", err)
+        }
+        if _, attempts, ok, _ := ClaimNextJob(config.DB, "worker-a"); !ok || attempts != MaxJobAttempts {
+            t.Fatalf("Expected to claim the last attempt, got attempt %d (ok=%v)", attempts, ok)
+        }
+        if status, err := RetryJob(config.DB, retried.ID, "worker-a"); err != nil || status != JobStatusFailed {
+            t.Fatalf("Expected the job to fail, got %q (err=%v)", status, err)
+        }
+        fetched, err = GetJobByID(config.DB, retried.ID)
+        if err != nil {
+            t.Fatalf("Failed to get job: %v", err)
+        }
+        if fetched.Status != JobStatusFailed || fetched.CompletedAt == nil || fetched.Failed != 2 ||
+            fetched.Items[0].Error != jobFailedItemError {
+            t.Errorf("Expected a failed job with failed items, got %+v", fetched)
+        }
+    })
+
+    t.Run("TestIdempotencyKeys", func(t *testing.T) {
+        key := "test-" + config.GenerateUUID().String()
+
+        lease, _, err := ReserveIdempotencyKey(config.DB, key, "hash-a")
+        if err != nil || lease == nil {
+            t.Fatalf("Expected to reserve a new key, got lease=%v err=%v", lease, err)
+        }
+
+        retry, existing, err := ReserveIdempotencyKey(config.DB, key, "hash-a")
+        if err != nil || retry != nil || existing.Completed {
+            t.Fatalf("Expected an in-progress record, got lease=%v existing=%+v err=%v", retry, existing, err)
+        }
+
+        // Once its lease expires, a retry of the same request takes an
+        // unfinished key over; a different request still may not
+        if _, err := config.DB.Exec(context.Background(),
+            "UPDATE idempotency_keys SET locked_until = now() - interval '1 second' WHERE key = $1", key); err != nil {
+            t.Fatalf("Failed to expire key lease: %v", err)
+        }
+        if other, _, _ := ReserveIdempotencyKey(config.DB, key, "hash-b"); other != nil {
+            t.Errorf("Expected a different request not to take the key over")
+        }
+        retry, _, _ = ReserveIdempotencyKey(config.DB, key, "hash-a")
+        if retry == nil {
+            t.Fatalf("Expected a retry to take over a key whose lease expired")
+        }
+
+        // The request that lost its lease can neither release the retry's
+        // reservation nor store its response over it
+        if err := ReleaseIdempotencyKey(config.DB, lease); !errors.Is(err, ErrIdempotencyLeaseLost) {
+            t.Errorf("Expected ErrIdempotencyLeaseLost releasing a lost lease, got %v", err)
+        }
+        if err := CompleteIdempotencyKey(config.DB, lease, 200, []byte(`{"id": "stale"}`)); !errors.Is(err, ErrIdempotencyLeaseLost) {
+            t.Errorf("Expected ErrIdempotencyLeaseLost completing a lost lease, got %v", err)
+        }
+
+        if err := CompleteIdempotencyKey(config.DB, retry, 200, []byte(`{"id": "abc"}`)); err != nil {
+            t.Fatalf("Failed to complete key: %v", err)
+        }
+
+        other, existing, err := ReserveIdempotencyKey(config.DB, key, "hash-b")
+        if err != nil || other != nil {
+            t.Fatalf("Expected the key to stay taken, got lease=%v err=%v", other, err)
+        }
+        if !existing.Completed || existing.ResponseStatus != 200 || existing.RequestHash != "hash-a" ||
+            !bytes.Contains(existing.ResponseBody, []byte("abc")) {
+            t.Errorf("Unexpected stored record: %+v", existing)
+        }
+
+        if err := ReleaseIdempotencyKey(config.DB, retry); err != nil {
+            t.Fatalf("Failed to release key: %v", err)
+        }
+        if other, _, _ := ReserveIdempotencyKey(config.DB, key, "hash-b"); other == nil {
+            t.Errorf("Expected a released key to be reservable again")
+        }
+    })
+
+    t.Run("TestStreamReceipts", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        for i := 0; i < 3; i++ {
+            if err := AddReceipt(config.DB, createTestReceipt(), testAudit); err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
+            }
+        }
+
+        var exported []ExportedReceipt
+        err := StreamReceipts(context.Background(), config.DB, ExportFilter{},
+            func(receipt ExportedReceipt) error {
+                exported = append(exported, receipt)
+                return nil
+            }, nil)
+        if err != nil {
+            t.Fatalf("Failed to stream receipts: %v", err)
+        }
+
+        if len(exported) != 3 {
+            t.Fatalf("Expected 3 exported receipts, got %d", len(exported))
+        }
+        for _, receipt := range exported {
+            if len(receipt.Items) != 2 {
+                t.Errorf("Expected 2 items on receipt %s, got %d", receipt.ID, len(receipt.Items))
+            }
+        }
+
+        // Resuming after the last position should yield nothing new
+        var incremental int
+        err = StreamReceipts(context.Background(), config.DB, ExportFilter{After: exported[2].Position},
+            func(receipt ExportedReceipt) error {
+                incremental++
+                return nil
+            }, nil)
+        if err != nil {
+            t.Fatalf("Failed to stream receipts after watermark: %v", err)
+        }
+        if incremental != 0 {
+            t.Errorf("Expected 0 receipts after watermark, got %d", incremental)
+        }
+
+        // Receipts stored together share a transaction; resuming after the
+        // first of them by ID still yields the others
+        batch := []*Receipt{createTestReceipt(), createTestReceipt(), createTestReceipt()}
+        for _, err := range AddReceipts(config.DB, batch, testAudit) {
+            if err != nil {
+                t.Fatalf("Failed to add receipts: %v", err)
+            }
+        }
+        var resumed []ExportedReceipt
+        err = StreamReceipts(context.Background(), config.DB, ExportFilter{After: exported[2].Position},
+            func(receipt ExportedReceipt) error {
+                resumed = append(resumed, receipt)
+                return nil
+            }, nil)
+        if err != nil || len(resumed) != 3 || resumed[0].Position.TxID != resumed[2].Position.TxID {
+            t.Fatalf("Expected 3 receipts stored together, got %d (%v)", len(resumed), err)
+        }
+        incremental = 0
+        err = StreamReceipts(context.Background(), config.DB, ExportFilter{After: resumed[0].Position},
+            func(receipt ExportedReceipt) error {
+                incremental++
+                return nil
+            }, nil)
+        if err != nil {
+            t.Fatalf("Failed to stream receipts after watermark: %v", err)
+        }
+        if incremental != 2 {
+            t.Errorf("Expected 2 receipts after the first of the batch, got %d", incremental)
+        }
+
+        // A receipt whose transaction is still open holds back everything
+        // stored after it, until it commits
+        tx, err := config.DB.Begin(context.Background())
+        if err != nil {
+            t.Fatalf("Failed to begin transaction: %v", err)
+        }
+        defer tx.Rollback(context.Background())
+        if _, err := tx.Exec(context.Background(), `SELECT pg_current_xact_id()`); err != nil {
+            t.Fatalf("Failed to assign a transaction ID: %v", err)
+        }
+        if err := AddReceipt(config.DB, createTestReceipt(), testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        incremental = 0
+        err = StreamReceipts(context.Background(), config.DB, ExportFilter{After: resumed[2].Position},
+            func(receipt ExportedReceipt) error {
+                incremental++
+                return nil
+            }, nil)
+        if err != nil || incremental != 0 {
+            t.Errorf("Expected the receipt behind the open transaction to wait, got %d (err=%v)", incremental, err)
+        }
+        tx.Rollback(context.Background())
+        incremental = 0
+        err = StreamReceipts(context.Background(), config.DB, ExportFilter{After: resumed[2].Position},
+            func(receipt ExportedReceipt) error {
+                incremental++
+                return nil
+            }, nil)
+        if err != nil || incremental != 1 {
+            t.Errorf("Expected the receipt once the open transaction ended, got %d (err=%v)", incremental, err)
+        }
+    })
+
+    t.Run("TestFraudHistory", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        rules := config.DefaultFraudRules()
+        rules.PatternWeight, rules.PointsRatioWeight, rules.OutlierWeight = 0, 0, 0
+        rules.VelocityMaxReceipts, rules.VelocityWeight = 1, 1
+        hasReason := func(assessment FraudAssessment, prefix string) bool {
+            for _, reason := range assessment.Reasons {
+                if strings.HasPrefix(reason, prefix) {
+                    return true
+                }
+            }
+            return false
+        }
+
+        // Deleted and rejected receipts do not count towards velocity
+        deleted, rejected := createTestReceipt(), createTestReceipt()
+        deleted.MemberID, rejected.MemberID = "velocity", "velocity"
+        rejected.Status = ReceiptStatusRejected
+        for _, receipt := range []*Receipt{deleted, rejected} {
+            if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
+            }
+        }
+        if err := DeleteReceipt(config.DB, deleted.ID, testAudit); err != nil {
+            t.Fatalf("Failed to delete receipt: %v", err)
+        }
+        next := createTestReceipt()
+        next.MemberID = "velocity"
+        if hasReason(AssessFraud(config.DB, next, rules), "member submitted") {
+            t.Errorf("Expected deleted and rejected receipts not to count towards velocity")
+        }
+        if err := AddReceipt(config.DB, next, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        if !hasReason(AssessFraud(config.DB, &Receipt{MemberID: "velocity", Total: "1.00"}, rules), "member submitted") {
+            t.Errorf("Expected an approved receipt to count towards velocity")
+        }
+
+        // The baseline is the canonical retailer's approved receipts, under
+        // whatever name they were printed
+        retailer := &Retailer{Name: "Outlier " + config.GenerateUUID().String()[:8]}
+        if err := CreateRetailer(config.DB, retailer); err != nil {
+            t.Fatalf("Failed to create retailer: %v", err)
+        }
+        rules = config.DefaultFraudRules()
+        rules.PatternWeight, rules.PointsRatioWeight, rules.VelocityWeight = 0, 0, 0
+        rules.OutlierMinSamples, rules.OutlierZScore, rules.OutlierWeight = 3, 3, 1
+        for i, total := range []string{"10.00", "11.00", "12.00", "10.50", "900.00"} {
+            receipt := createTestReceipt()
+            receipt.Retailer = fmt.Sprintf("%s #%d", retailer.Name, i)
+            receipt.RetailerID = retailer.ID
+            receipt.Total = total
+            if total == "900.00" {
+                receipt.Status = ReceiptStatusPending
+            }
+            if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
+            }
+        }
+        outlier := &Receipt{Retailer: retailer.Name, RetailerID: retailer.ID, Total: "100.00"}
+        if !hasReason(AssessFraud(config.DB, outlier, rules), "total is") {
+            t.Errorf("Expected a total far from the approved receipts to be an outlier, got %+v", outlier.Fraud)
+        }
+    })
+
+    t.Run("TestReviewReceipt", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        flagged := createTestReceipt()
+        flagged.Status = ReceiptStatusPending
+        flagged.Fraud = &FraudAssessment{Score: 1.5, Reasons: []string{"total is a round dollar amount"}, Flagged: true}
+        if err := AddReceipt(config.DB, flagged, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        if err := AddReceipt(config.DB, createTestReceipt(), testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        pending, err := GetPendingReceipts(config.DB, 10)
+        if err != nil {
+            t.Fatalf("Failed to get pending receipts: %v", err)
+        }
+        if len(pending) != 1 || pending[0].ID != flagged.ID {
+            t.Fatalf("Expected only the flagged receipt to be pending, got %d receipts", len(pending))
+        }
+
+        review, err := ReviewReceipt(config.DB, flagged.ID, ReceiptStatusRejected, "Fabricated receipt", "alice", testAudit)
+        if err != nil {
+            t.Fatalf("Failed to review receipt: %v", err)
+        }
+        if review.Decision != ReceiptStatusRejected {
+            t.Errorf("Expected decision %q, got %q", ReceiptStatusRejected, review.Decision)
+        }
+
+        // A decided receipt cannot be reviewed again
+        _, err = ReviewReceipt(config.DB, flagged.ID, ReceiptStatusApproved, "", "bob", testAudit)
+        if !errors.Is(err, ErrReceiptNotPending) {
+            t.Errorf("Expected ErrReceiptNotPending, got %v", err)
+        }
+
+        fetched, err := GetReceiptByID(config.DB, flagged.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt by ID: %v", err)
+        }
+        if fetched.Status != ReceiptStatusRejected {
+            t.Errorf("Expected status %q, got %q", ReceiptStatusRejected, fetched.Status)
+        }
+
+        decisions, err := GetReviewDecisions(config.DB, flagged.ID)
+        if err != nil {
+            t.Fatalf("Failed to get review decisions: %v", err)
+        }
+        if len(decisions) != 1 || decisions[0].Reason != "Fabricated receipt" {
+            t.Errorf("Expected one recorded decision, got %+v", decisions)
+        }
+    })
+
+    t.Run("TestUpdateReceipt", func(t *testing.T) {
+        receipt := createTestReceipt()
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        updated := *receipt
+        updated.Retailer = receipt.Retailer + " Corrected"
+        updated.CalculatePoints()
+        if err := UpdateReceipt(config.DB, &updated, 1, testAudit); err != nil {
+            t.Fatalf("Failed to update receipt: %v", err)
+        }
+        if updated.Version != 2 {
+            t.Errorf("Expected version 2, got %d", updated.Version)
+        }
+
+        // A second update based on the old version must be refused
+        stale := updated
+        err := UpdateReceipt(config.DB, &stale, 1, testAudit)
+        var conflict *VersionConflictError
+        if !errors.As(err, &conflict) || conflict.CurrentVersion != 2 {
+            t.Errorf("Expected a VersionConflictError at version 2, got %v", err)
+        }
+
+        fetched, err := GetReceiptByID(config.DB, receipt.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt by ID: %v", err)
+        }
+        if fetched.Retailer != updated.Retailer || len(fetched.Items) != len(receipt.Items) {
+            t.Errorf("Expected the updated receipt to be stored, got %+v", fetched)
+        }
+
+        var snapshots int
+        err = config.DB.QueryRow(context.Background(),
+            "SELECT COUNT(*) FROM receipt_versions WHERE receipt_id = $1 AND version = 1", receipt.ID).Scan(&snapshots)
+        if err != nil || snapshots != 1 {
+            t.Errorf("Expected the previous version to be recorded, got %d (%v)", snapshots, err)
+        }
+
+        // A pending receipt that now scores clean still waits for review
+        if _, err := config.DB.Exec(context.Background(),
+            "UPDATE receipts SET status = 'pending' WHERE id = $1", receipt.ID); err != nil {
+            t.Fatalf("Failed to hold receipt for review: %v", err)
+        }
+        clean := updated
+        clean.Status = ReceiptStatusApproved
+        if err := UpdateReceipt(config.DB, &clean, 2, testAudit); err != nil {
+            t.Fatalf("Failed to update receipt: %v", err)
+        }
+        if clean.Status != ReceiptStatusPending {
+            t.Errorf("Expected the receipt to stay pending, got %s", clean.Status)
+        }
+    })
+
+    t.Run("TestSearchReceipts", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        receipt := createTestReceipt()
+        receipt.Items[0].ShortDescription = "Doritos Nacho Cheese"
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        if err := AddReceipt(config.DB, createTestReceipt(), testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        for _, input := range []string{"doritos", `"nacho cheese"`, "dor*"} {
+            query, err := ParseSearchQuery(input)
+            if err != nil {
+                t.Fatalf("Failed to parse query: %v", err)
+            }
+            results, total, err := SearchReceipts(config.DB, query, 10, 0)
+            if err != nil {
+                t.Fatalf("Failed to search receipts: %v", err)
+            }
+            if total != 1 || len(results) != 1 || results[0].Receipt.ID != receipt.ID {
+                t.Errorf("Expected %q to find only the Doritos receipt, got %d results", input, total)
+            }
+        }
+
+        // The database must agree with the in-memory matcher; ranks are
+        // computed differently, so only the matching receipts are compared
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+        for _, searchReceipt := range SearchTestReceipts {
+            receipt := createTestReceipt()
+            receipt.Retailer = searchReceipt.Retailer
+            receipt.Items = receipt.Items[:len(searchReceipt.Items)]
+            for i, item := range searchReceipt.Items {
+                receipt.Items[i].ShortDescription = item.ShortDescription
+            }
+            if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
+            }
+        }
+
+        for _, tc := range SearchTestCases {
+            query, err := ParseSearchQuery(tc.Query)
+            if err != nil {
+                t.Fatalf("Failed to parse query: %v", err)
+            }
+            results, total, err := SearchReceipts(config.DB, query, 10, 0)
+            if err != nil {
+                t.Fatalf("Failed to search receipts: %v", err)
+            }
+            retailers := []string{}
+            for _, result := range results {
+                retailers = append(retailers, result.Receipt.Retailer)
+            }
+            sort.Strings(retailers)
+            if !reflect.DeepEqual(retailers, tc.Expected) || total != len(tc.Expected) {
+                t.Errorf("Expected %q to find %v, got %v (total %d)", tc.Query, tc.Expected, retailers, total)
+            }
+        }
+    })
+
+    t.Run("TestSKUCatalog", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        receipt := createTestReceipt()
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        skus, total, err := ListSKUs(config.DB, SKUFilter{
+            ProductCategory: "GROC",
+            Attributes:      map[string]string{"SIZE": "LRG"},
+        }, 10, 0)
+        if err != nil {
+            t.Fatalf("Failed to list SKUs: %v", err)
+        }
+        if total != 1 || len(skus) != 1 || skus[0].UniqueIdentifier != "12345" || skus[0].Receipts != 1 {
+            t.Errorf("Expected only SKU 12345 bought on 1 receipt, got %+v", skus)
+        }
+
+        if _, total, _ := ListSKUs(config.DB, SKUFilter{Attributes: map[string]string{"SIZE": "SML"}}, 10, 0); total != 0 {
+            t.Errorf("Expected no SKU of size SML, got %d", total)
+        }
+
+        receipts, total, err := GetReceiptsForSKU(config.DB, "TST", "67890", 10, 0)
+        if err != nil {
+            t.Fatalf("Failed to get receipts for SKU: %v", err)
+        }
+        if total != 1 || receipts[0].ID != receipt.ID || len(receipts[0].Items) != 2 {
+            t.Errorf("Expected the test receipt with both items, got %+v", receipts)
+        }
+
+        if _, err := GetSKU(config.DB, "TST", "UNKNOWN"); !errors.Is(err, pgx.ErrNoRows) {
+            t.Errorf("Expected pgx.ErrNoRows for an unknown SKU, got %v", err)
+        }
+
+        // The same identifier under another prefix is another SKU
+        otherRetailer := createTestReceipt()
+        otherRetailer.Items = otherRetailer.Items[:1]
+        otherRetailer.Items[0].SKU.Prefix = "OTH"
+        otherRetailer.Items[0].SKU.ProductCategory = "HOME"
+        if err := AddReceipt(config.DB, otherRetailer, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        if len(otherRetailer.SKUConflicts) != 0 {
+            t.Errorf("Expected no conflict across prefixes, got %v", otherRetailer.SKUConflicts)
+        }
+        for prefix, category := range map[string]string{"TST": "GROC", "OTH": "HOME"} {
+            sku, err := GetSKU(config.DB, prefix, "12345")
+            if err != nil || sku.ProductCategory != category || sku.Receipts != 1 {
+                t.Errorf("Expected %s-12345 in %s bought once, got %+v (err=%v)", prefix, category, sku, err)
+            }
+        }
+        if _, total, _ := GetReceiptsForSKU(config.DB, "OTH", "12345", 10, 0); total != 1 {
+            t.Errorf("Expected one receipt for OTH-12345, got %d", total)
+        }
+
+        // A barcode with and without a SKU
+        withGTIN := createTestReceipt()
+        withGTIN.Items[0].GTIN = "00012000001291"
+        withGTIN.Items[1].SKU = SKU{}
+        withGTIN.Items[1].GTIN = "00012000001291"
+        if err := AddReceipt(config.DB, withGTIN, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt with GTINs: %v", err)
+        }
+
+        fetched, err := GetReceiptByID(config.DB, withGTIN.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if !fetched.Items[1].SKU.IsZero() || fetched.Items[1].GTIN != "00012000001291" {
+            t.Errorf("Expected an item without a SKU, got %+v", fetched.Items[1])
+        }
+
+        product, err := GetGTIN(config.DB, "00012000001291")
+        if err != nil {
+            t.Fatalf("Failed to get GTIN: %v", err)
+        }
+        if product.Receipts != 1 || product.Quantity != 3 || !reflect.DeepEqual(product.SKUs, []SKUKey{{Prefix: "TST", UniqueIdentifier: "12345"}}) {
+            t.Errorf("Unexpected GTIN purchases %+v", product)
+        }
+
+        skus, _, err = ListSKUs(config.DB, SKUFilter{GTIN: "00012000001291"}, 10, 0)
+        if err != nil || len(skus) != 1 || skus[0].UniqueIdentifier != "12345" {
+            t.Errorf("Expected SKU 12345 for the GTIN, got %+v (err=%v)", skus, err)
+        }
+    })
+
+    t.Run("TestSKUConflicts", func(t *testing.T) {
+        defer func(policy string) { config.Catalog.SKUConflictPolicy = policy }(config.Catalog.SKUConflictPolicy)
+
+        // SKUs outlive truncation, so every run defines a SKU of its own
+        uid := "C" + config.GenerateUUID().String()[:8]
+        addWithSize := func(policy, size string) (*Receipt, error) {
+            config.Catalog.SKUConflictPolicy = policy
+            receipt := createTestReceipt()
+            receipt.Items = receipt.Items[:1]
+            receipt.Items[0].SKU = SKU{
+                Prefix:           "TST",
+                ProductCategory:  "CNFL",
+                Manufacturer:     "TESTBRAND",
+                ProductLine:      "PROD",
+                Attributes:       map[string]string{"SIZE": size},
+                UniqueIdentifier: uid,
+            }
+            return receipt, AddReceipt(config.DB, receipt, testAudit)
+        }
+        storedSize := func() (string, int) {
+            sku, err := GetSKU(config.DB, "TST", uid)
+            if err != nil {
+                t.Fatalf("Failed to get SKU: %v", err)
+            }
+            return sku.Attributes["SIZE"], sku.Version
+        }
+
+        if receipt, err := addWithSize(config.SKUConflictKeepFirst, "SML"); err != nil || len(receipt.SKUConflicts) != 0 {
+            t.Fatalf("Expected the first definition to be stored, got %v (err=%v)", receipt.SKUConflicts, err)
+        }
+        if receipt, err := addWithSize(config.SKUConflictKeepFirst, "SML"); err != nil || len(receipt.SKUConflicts) != 0 {
+            t.Errorf("Expected the same definition not to conflict, got %v (err=%v)", receipt.SKUConflicts, err)
+        }
+
+        receipt, err := addWithSize(config.SKUConflictKeepFirst, "MED")
+        if err != nil || len(receipt.SKUConflicts) != 1 || receipt.SKUConflicts[0].Resolution != SKUConflictKeptStored {
+            t.Errorf("Expected a kept_stored conflict, got %v (err=%v)", receipt.SKUConflicts, err)
+        }
+        if size, version := storedSize(); size != "SML" || version != 1 {
+            t.Errorf("Expected SML version 1 to be kept, got %s version %d", size, version)
+        }
+
+        var conflict *SKUConflictError
+        if _, err := addWithSize(config.SKUConflictReject, "MED"); !errors.As(err, &conflict) {
+            t.Errorf("Expected a SKUConflictError, got %v", err)
+        } else if conflict.Conflict.Stored.Attributes["SIZE"] != "SML" || conflict.Conflict.Resolution != SKUConflictRejected {
+            t.Errorf("Unexpected conflict %+v", conflict.Conflict)
+        }
+
+        if _, err := addWithSize(config.SKUConflictLastWriteWins, "MED"); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        if size, version := storedSize(); size != "MED" || version != 1 {
+            t.Errorf("Expected MED version 1 after last write wins, got %s version %d", size, version)
+        }
+
+        receipt, err = addWithSize(config.SKUConflictVersion, "LRG")
+        if err != nil || len(receipt.SKUConflicts) != 1 || receipt.SKUConflicts[0].Version != 2 {
+            t.Errorf("Expected a conflict resolved as version 2, got %v (err=%v)", receipt.SKUConflicts, err)
+        }
+        if size, version := storedSize(); size != "LRG" || version != 2 {
+            t.Errorf("Expected LRG version 2, got %s version %d", size, version)
+        }
+
+        var superseded string
+        if err := config.DB.QueryRow(context.Background(), `
+            SELECT attributes->>'SIZE' FROM sku_versions WHERE prefix = 'TST' AND unique_identifier = $1 AND version = 1
+        `, uid).Scan(&superseded); err != nil || superseded != "MED" {
+            t.Errorf("Expected version 1 to be kept as MED, got %q (err=%v)", superseded, err)
+        }
+    })
+
+    t.Run("TestProductPrices", func(t *testing.T) {
+        // SKUs and products outlive truncation, so every run uses SKUs of its own
+        uid := "P" + config.GenerateUUID().String()[:8]
+        addPurchase := func(prefix, date string, quantity int, price string) {
+            receipt := createTestReceipt()
+            receipt.Retailer = "Price Store"
+            receipt.PurchaseDate = date
+            receipt.Items = receipt.Items[:1]
+            receipt.Items[0].SKU = SKU{Prefix: prefix, ProductCategory: "SNCK", Manufacturer: "TESTBRAND",
+                ProductLine: "CHIP", UniqueIdentifier: uid}
+            receipt.Items[0].Quantity = quantity
+            receipt.Items[0].PricePaid = price
+            if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
+            }
+        }
+        addPurchase("TST", "2024-01-15", 2, "10.00")
+        addPurchase("TST", "2024-01-20", 1, "4.00")
+        addPurchase("OTH", "2024-02-03", 1, "6.00")
+
+        sku, err := GetSKU(config.DB, "TST", uid)
+        if err != nil || sku.ProductID == nil {
+            t.Fatalf("Expected the SKU to get a product, got %+v (err=%v)", sku, err)
+        }
+        product, err := GetProduct(config.DB, *sku.ProductID)
+        if err != nil {
+            t.Fatalf("Failed to get product: %v", err)
+        }
+        if product.Name != "Test Product Large" || product.Brand != "TESTBRAND" || product.Category != "SNCK" {
+            t.Errorf("Expected a product named after the item, got %+v", product)
+        }
+
+        // The other retailer's SKU of the same product
+        if err := LinkSKUToProduct(config.DB, product.ID, SKUKey{Prefix: "OTH", UniqueIdentifier: uid}); err != nil {
+            t.Fatalf("Failed to link SKU: %v", err)
+        }
+        if err := LinkSKUToProduct(config.DB, product.ID, SKUKey{Prefix: "OTH", UniqueIdentifier: "UNKNOWN"}); !errors.Is(err, pgx.ErrNoRows) {
+            t.Errorf("Expected pgx.ErrNoRows linking an unknown SKU, got %v", err)
+        }
+
+        prices, err := GetProductPrices(config.DB, product.ID, PriceHistoryFilter{Period: "month"})
+        if err != nil {
+            t.Fatalf("Failed to get prices: %v", err)
+        }
+        expected := []PricePoint{
+            {PeriodStart: "2024-01-01", Retailer: "Price Store", Min: 4, Max: 5, Avg: 4.5, Purchases: 2, Quantity: 3},
+            {PeriodStart: "2024-02-01", Retailer: "Price Store", Min: 6, Max: 6, Avg: 6, Purchases: 1, Quantity: 1},
+        }
+        if !reflect.DeepEqual(prices, expected) {
+            t.Errorf("Expected prices %+v, got %+v", expected, prices)
+        }
+
+        prices, err = GetProductPrices(config.DB, product.ID, PriceHistoryFilter{Period: "year", FromDate: "2024-01-16"})
+        if err != nil || len(prices) != 1 || prices[0].Purchases != 2 || prices[0].Min != 4 || prices[0].Max != 6 {
+            t.Errorf("Expected one yearly point of 2 purchases, got %+v (err=%v)", prices, err)
+        }
+
+        if _, err := GetProductPrices(config.DB, product.ID, PriceHistoryFilter{Period: "fortnight"}); !errors.Is(err, ErrInvalidPricePeriod) {
+            t.Errorf("Expected ErrInvalidPricePeriod, got %v", err)
+        }
+    })
+
+    t.Run("TestCategories", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        // Categories outlive truncation, so every run adds a tree of its own
+        root := &Category{Name: "Root " + config.GenerateUUID().String()[:8]}
+        if err := CreateCategory(config.DB, root); err != nil {
+            t.Fatalf("Failed to create category: %v", err)
+        }
+        child := &Category{ParentID: &root.ID, Name: "Gadgets", Keywords: []string{"Gizmo", "gizmo"}}
+        if err := CreateCategory(config.DB, child); err != nil {
+            t.Fatalf("Failed to create category: %v", err)
+        }
+        if !reflect.DeepEqual(child.Keywords, []string{"gizmo"}) {
+            t.Errorf("Expected normalized keywords, got %v", child.Keywords)
+        }
+        if err := CreateCategory(config.DB, &Category{ParentID: &root.ID, Name: "Gadgets"}); !errors.Is(err, ErrDuplicateCategory) {
+            t.Errorf("Expected ErrDuplicateCategory, got %v", err)
+        }
+        missing := -1
+        if err := CreateCategory(config.DB, &Category{ParentID: &missing, Name: "Orphan"}); !errors.Is(err, ErrUnknownCategory) {
+            t.Errorf("Expected ErrUnknownCategory, got %v", err)
+        }
+
+        receipt := createTestReceipt()
+        receipt.Items[1].SKU = SKU{}
+        receipt.Items[1].ShortDescription = "Blue Gizmo"
+        CategorizeItems(config.DB, receipt)
+        if receipt.Items[1].CategoryID != child.ID {
+            t.Errorf("Expected the item without a SKU in %d, got %d", child.ID, receipt.Items[1].CategoryID)
+        }
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        // Mapping the code of the first item's SKU moves the stored item
+        moved, err := SetCategoryMapping(config.DB, receipt.Items[0].SKU.ProductCategory, root.ID)
+        if err != nil || moved != 1 {
+            t.Errorf("Expected 1 item recategorized, got %d (err=%v)", moved, err)
+        }
+        fetched, err := GetReceiptByID(config.DB, receipt.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if fetched.Items[0].CategoryID != root.ID || fetched.Items[1].CategoryID != child.ID {
+            t.Errorf("Unexpected item categories %d and %d", fetched.Items[0].CategoryID, fetched.Items[1].CategoryID)
+        }
+        if _, err := SetCategoryMapping(config.DB, "NOPE", missing); !errors.Is(err, ErrUnknownCategory) {
+            t.Errorf("Expected ErrUnknownCategory, got %v", err)
+        }
+    })
+
+    t.Run("TestRetailers", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        // Retailers outlive truncation, so every run adds one of its own
+        suffix := config.GenerateUUID().String()[:8]
+        receipt := createTestReceipt()
+        receipt.Retailer = "Shop " + suffix + " #42"
+        NormalizeRetailer(config.DB, receipt)
+        if receipt.RetailerID != 0 {
+            t.Errorf("Expected no canonical retailer yet, got %d", receipt.RetailerID)
+        }
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        // Creating the retailer links new receipts at once, stored ones
+        // once relinked
+        retailer := &Retailer{Name: "Shop " + suffix, Aliases: []string{"Shop" + suffix + "*", "shop" + suffix + "*"}}
+        if err := CreateRetailer(config.DB, retailer); err != nil {
+            t.Fatalf("Failed to create retailer: %v", err)
+        }
+        if !reflect.DeepEqual(retailer.Aliases, []string{"shop" + suffix + "*"}) {
+            t.Errorf("Expected normalized aliases, got %v", retailer.Aliases)
+        }
+        next := createTestReceipt()
+        next.Retailer = "SHOP " + suffix + " #7"
+        NormalizeRetailer(config.DB, next)
+        if next.RetailerID != retailer.ID {
+            t.Errorf("Expected a new receipt linked to %d, got %d", retailer.ID, next.RetailerID)
+        }
+
+        rules := config.DefaultPointsRules()
+        rules.RetailerName = config.PointsRetailerCanonical
+        relink, err := RelinkReceipts(config.DB, rules)
+        if err != nil {
+            t.Fatalf("Failed to relink receipts: %v", err)
+        }
+        if relink != (RetailerRelink{Relinked: 1, Recalculated: 1}) {
+            t.Errorf("Expected 1 relinked and recalculated receipt, got %+v", relink)
+        }
+        fetched, err := GetReceiptByID(config.DB, receipt.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if fetched.RetailerID != retailer.ID || fetched.CanonicalRetailer != retailer.Name || fetched.Version != receipt.Version+1 {
+            t.Errorf("Expected version %d linked to %+v, got %+v", receipt.Version+1, retailer, fetched)
+        }
+        expected := *fetched
+        expected.CalculatePointsWithRules(rules)
+        if fetched.Points != expected.Points {
+            t.Errorf("Expected %d points for the canonical name, got %d", expected.Points, fetched.Points)
+        }
+        history, err := GetReceiptHistory(config.DB, receipt.ID)
+        if err != nil || len(history) != 3 || history[1].Action != AuditActionRelink || history[2].Action != AuditActionPointsRecalculated {
+            t.Errorf("Expected the relink and recalculation audited, got %+v (err=%v)", history, err)
+        }
+        if relink, err := RelinkReceipts(config.DB, rules); err != nil || relink != (RetailerRelink{}) {
+            t.Errorf("Expected nothing left to relink, got %+v (err=%v)", relink, err)
+        }
+
+        if err := CreateRetailer(config.DB, &Retailer{Name: retailer.Name}); !errors.Is(err, ErrDuplicateRetailer) {
+            t.Errorf("Expected ErrDuplicateRetailer, got %v", err)
+        }
+        if err := DeleteRetailer(config.DB, retailer.ID); !errors.Is(err, ErrRetailerInUse) {
+            t.Errorf("Expected ErrRetailerInUse while a receipt is linked, got %v", err)
+        }
+
+        // A more specific retailer takes the receipts over, stores included
+        stored := createTestReceipt()
+        stored.Retailer = "Shop " + suffix + " #43"
+        stored.PurchaseTime = "09:43"
+        NormalizeRetailer(config.DB, stored)
+        stored.Store = &Store{StoreNumber: "43"}
+        if err := AddReceipt(config.DB, stored, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        outlet := &Retailer{Name: "Outlet " + suffix, Aliases: []string{"shop" + suffix + "4*"}}
+        if err := CreateRetailer(config.DB, outlet); err != nil {
+            t.Fatalf("Failed to create retailer: %v", err)
+        }
+        if relink, err := RelinkReceipts(config.DB, config.DefaultPointsRules()); err != nil || relink != (RetailerRelink{Relinked: 2}) {
+            t.Errorf("Expected 2 relinked receipts, got %+v (err=%v)", relink, err)
+        }
+        fetched, err = GetReceiptByID(config.DB, stored.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if fetched.RetailerID != outlet.ID || fetched.Store == nil || fetched.Store.RetailerID != outlet.ID || fetched.Store.StoreNumber != "43" {
+            t.Errorf("Expected the receipt and its store moved to %d, got %d and %+v", outlet.ID, fetched.RetailerID, fetched.Store)
+        }
+
+        // Narrowing the aliases unlinks the receipts again, except the one
+        // with a store
+        outlet.Aliases = nil
+        if err := UpdateRetailer(config.DB, outlet); err != nil {
+            t.Fatalf("Failed to update retailer: %v", err)
+        }
+        retailer.Aliases = nil
+        if err := UpdateRetailer(config.DB, retailer); err != nil {
+            t.Fatalf("Failed to update retailer: %v", err)
+        }
+        if err := UpdateRetailer(config.DB, &Retailer{ID: -1, Name: "Missing " + suffix}); !errors.Is(err, pgx.ErrNoRows) {
+            t.Errorf("Expected pgx.ErrNoRows, got %v", err)
+        }
+        if relink, err := RelinkReceipts(config.DB, config.DefaultPointsRules()); err != nil || relink != (RetailerRelink{Relinked: 1, Kept: 1}) {
+            t.Errorf("Expected 1 relinked and 1 kept receipt, got %+v (err=%v)", relink, err)
+        }
+
+        // Both retailers keep a store; only one without any can be deleted
+        for _, id := range []int{retailer.ID, outlet.ID} {
+            if err := DeleteRetailer(config.DB, id); !errors.Is(err, ErrRetailerInUse) {
+                t.Errorf("Expected ErrRetailerInUse while a store is linked, got %v", err)
+            }
+        }
+        unused := &Retailer{Name: "Unused " + suffix}
+        if err := CreateRetailer(config.DB, unused); err != nil {
+            t.Fatalf("Failed to create retailer: %v", err)
+        }
+        if err := DeleteRetailer(config.DB, unused.ID); err != nil {
+            t.Fatalf("Failed to delete retailer: %v", err)
+        }
+        if _, err := GetRetailer(config.DB, unused.ID); !errors.Is(err, pgx.ErrNoRows) {
+            t.Errorf("Expected pgx.ErrNoRows, got %v", err)
+        }
+    })
+
+    t.Run("TestStores", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        // Retailers and stores outlive truncation, so every run adds its own
+        retailer := &Retailer{Name: "Chain " + config.GenerateUUID().String()[:8]}
+        if err := CreateRetailer(config.DB, retailer); err != nil {
+            t.Fatalf("Failed to create retailer: %v", err)
+        }
+        latitude, longitude := 44.9778, -93.2650
+
+        near := createTestReceipt()
+        near.Retailer = retailer.Name
+        near.RetailerID = retailer.ID
+        near.PurchaseTime = "07:00"
+        near.Store = &Store{StoreNumber: "12"}
+        if err := AddReceipt(config.DB, near, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
 
-    for _, tc := range testCases {
-        t.Run(tc.name, func(t *testing.T) {
-            receipt := Receipt{Items: tc.input}
-            receipt.CleanItemShortDescriptions()
-            
-            if !reflect.DeepEqual(receipt.Items, tc.expected) {
-                t.Errorf("Expected %v, got %v", tc.expected, receipt.Items)
-            }
-        })
-    }
-}
+        // A later receipt from the same store fills in its location
+        again := createTestReceipt()
+        again.Retailer = retailer.Name
+        again.RetailerID = retailer.ID
+        again.PurchaseTime = "07:01"
+        again.Store = &Store{StoreNumber: "#12", Address: "1 Main St", Latitude: &latitude, Longitude: &longitude}
+        if err := AddReceipt(config.DB, again, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        if again.Store.ID != near.Store.ID || again.Store.StoreNumber != "12" {
+            t.Errorf("Expected both receipts at store %d, got %+v", near.Store.ID, again.Store)
+        }
 
-// Calculation Tests:
-func TestCalculatePointsFromRetailerAlphaNumChar(t *testing.T) {
-    testCases := []struct {
-        retailer string
-        expected uint
-    }{
-        {"Target", 6},
-        {"123", 3},
-        {"Walmart!", 7},
-        {"", 0},
-    }
+        farLatitude, farLongitude := 41.8781, -87.6298
+        far := createTestReceipt()
+        far.Retailer = retailer.Name
+        far.RetailerID = retailer.ID
+        far.PurchaseTime = "07:02"
+        far.Store = &Store{StoreNumber: "99", Latitude: &farLatitude, Longitude: &farLongitude}
+        if err := AddReceipt(config.DB, far, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
 
-    for _, tc := range testCases {
-        result := calculatePointsFromRetailerAlphaNumChar(tc.retailer)
-        if result != tc.expected {
-            t.Errorf("For retailer %s, expected %d, got %d", tc.retailer, tc.expected, result)
+        stores, total, err := ListStores(config.DB, retailer.ID, 10, 0)
+        if err != nil || total != 2 || len(stores) != 2 || stores[0].Address != "1 Main St" {
+            t.Errorf("Expected the stores 12 and 99, got %+v (total %d, err=%v)", stores, total, err)
         }
-    }
-}
 
-func TestCalculatePointsFromTotal(t *testing.T) {
-    testCases := []struct {
-        total    string
-        expected uint
-    }{
-        {"10.00", 75},
-        {"10.25", 25},
-        {"10.50", 25},
-        {"10.75", 25},
-        {"10.99", 0},
-    }
+        fetched, err := GetReceiptByID(config.DB, near.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if fetched.Store == nil || fetched.Store.ID != near.Store.ID || fetched.Store.Latitude == nil {
+            t.Errorf("Expected the receipt at the located store, got %+v", fetched.Store)
+        }
 
-    for _, tc := range testCases {
-        result := calculatePointsFromTotal(tc.total)
-        if result != tc.expected {
-            t.Errorf("For total %s, expected %d, got %d", tc.total, tc.expected, result)
+        results, total, err := GetReceiptsNear(config.DB, latitude+0.01, longitude, 5, 10, 0)
+        if err != nil {
+            t.Fatalf("Failed to get nearby receipts: %v", err)
+        }
+        if total != 2 || len(results) != 2 || results[0].DistanceKm > 5 {
+            t.Errorf("Expected the 2 receipts of store 12, got %+v (total %d)", results, total)
+        }
+        if _, total, err := GetReceiptsNear(config.DB, latitude, longitude, 1000, 10, 5); err != nil || total != 3 {
+            t.Errorf("Expected 3 receipts within 1000 km, got %d (err=%v)", total, err)
         }
-    }
-}
 
-/*
-	Test SKU Methods:
-*/
-func TestSKUParsing(t *testing.T) {
-    for _, tc := range SKUParsingTestCases {
-        t.Run(tc.Name, func(t *testing.T) {
-            var sku SKU
-            err := sku.ParseSKU(tc.Input)
-            if tc.ExpectError {
-                if err == nil {
-                    t.Errorf("Expected error for input %s, got nil", tc.Input)
-                }
-            } else {
-                if err != nil {
-                    t.Fatalf("Failed to parse SKU %s: %v", tc.Input, err)
-                }
-                if !reflect.DeepEqual(sku, tc.Expected) {
-                    t.Errorf("Expected %+v, got %+v", tc.Expected, sku)
-                }
+        // Distances are measured across the antimeridian
+        fijiLatitude, fijiLongitude := -16.5, 179.99
+        fiji := createTestReceipt()
+        fiji.Retailer = retailer.Name
+        fiji.RetailerID = retailer.ID
+        fiji.PurchaseTime = "07:03"
+        fiji.Store = &Store{StoreNumber: "180", Latitude: &fijiLatitude, Longitude: &fijiLongitude}
+        if err := AddReceipt(config.DB, fiji, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        results, total, err = GetReceiptsNear(config.DB, fijiLatitude, -179.99, 5, 10, 0)
+        if err != nil || total != 1 || results[0].Receipt.ID != fiji.ID {
+            t.Errorf("Expected the receipt across the antimeridian, got %+v (total %d, err=%v)", results, total, err)
+        }
+
+        // The stores keep their retailer from being deleted
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+        if err := DeleteRetailer(config.DB, retailer.ID); !errors.Is(err, ErrRetailerInUse) {
+            t.Errorf("Expected ErrRetailerInUse while stores are linked, got %v", err)
+        }
+    })
+
+    t.Run("TestSpend", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        var receipts []Receipt
+        for i, memberID := range []string{"spender", "spender", ""} {
+            receipt := createTestReceipt()
+            receipt.PurchaseDate = fmt.Sprintf("2024-05-0%d", i+1)
+            receipt.MemberID = memberID
+            CategorizeItems(config.DB, receipt)
+            if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
             }
-        })
-    }
-}
+            receipts = append(receipts, *receipt)
+        }
 
-/*
-	Test Endpoint Methods: 
-	GET, 
-	POST
-*/
-func TestDatabaseOperations(t *testing.T) {
-	// Truncate tables before this test
-	if err := truncateTables(config.DB); err != nil {
-		t.Fatalf("Failed to truncate tables: %v", err)
-	}
+        taxonomy, err := GetTaxonomy(config.DB)
+        if err != nil {
+            t.Fatalf("Failed to load taxonomy: %v", err)
+        }
 
-    t.Run("TestAddReceipt", func(t *testing.T) {
-        receipt := createTestReceipt()
+        // The database and in-memory reports agree
+        for _, groupBy := range SpendGroupings {
+            filter := SpendFilter{GroupBy: groupBy, FromDate: "2024-05-02"}
+            report, err := GetSpend(config.DB, filter)
+            if err != nil {
+                t.Fatalf("Failed to report spend by %s: %v", groupBy, err)
+            }
+            expected, _ := SpendInMemory(receipts, taxonomy, filter)
+            if !reflect.DeepEqual(report, expected) {
+                t.Errorf("Expected %+v by %s, got %+v", expected, groupBy, report)
+            }
+        }
+    })
 
-        // Log the receipt before adding
-        receiptJSON, _ := json.MarshalIndent(receipt, "", "  ")
-        t.Logf("Adding receipt:\n%s", string(receiptJSON))
+    t.Run("TestPointsReport", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
 
-        err := AddReceipt(config.DB, receipt)
+        var receipts []Receipt
+        for i := 1; i <= 4; i++ {
+            receipt := createTestReceipt()
+            receipt.PurchaseDate = fmt.Sprintf("2024-06-0%d", i)
+            receipt.PurchaseTime = fmt.Sprintf("1%d:00", i+2)
+            receipt.CalculatePoints()
+            switch i {
+            case 3:
+                // Stored before breakdowns were kept
+                receipt.PointsBreakdown = nil
+            case 4:
+                receipt.Status = ReceiptStatusPending
+            }
+            if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+                t.Fatalf("Failed to add receipt: %v", err)
+            }
+            if receipt.Status == ReceiptStatusApproved {
+                receipts = append(receipts, *receipt)
+            }
+        }
+
+        stored, err := GetReceiptByID(config.DB, receipts[0].ID)
         if err != nil {
-            t.Errorf("Failed to add receipt: %v", err)
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if !reflect.DeepEqual(stored.PointsBreakdown, receipts[0].PointsBreakdown) {
+            t.Errorf("Expected breakdown %v, got %v", receipts[0].PointsBreakdown, stored.PointsBreakdown)
+        }
+
+        // The database and in-memory reports agree, pending receipts left out
+        for _, period := range PointsPeriods {
+            filter := PointsFilter{Period: period, FromDate: "2024-06-01", BucketWidth: 10}
+            report, err := GetPointsReport(config.DB, filter)
+            if err != nil {
+                t.Fatalf("Failed to report points by %s: %v", period, err)
+            }
+            expected, _ := PointsReportInMemory(receipts, filter)
+            if !reflect.DeepEqual(report, expected) {
+                t.Errorf("Expected %+v by %s, got %+v", expected, period, report)
+            }
         }
     })
 
-    t.Run("TestGetReceiptByID", func(t *testing.T) {
+    t.Run("TestBackfillPoints", func(t *testing.T) {
+        if err := truncateTables(config.DB); err != nil {
+            t.Fatalf("Failed to truncate tables: %v", err)
+        }
+
+        rules := config.DefaultPointsRules()
         receipt := createTestReceipt()
-        err := AddReceipt(config.DB, receipt)
-        if err != nil {
+        receipt.CalculatePointsWithRules(rules)
+        expected := receipt.PointsBreakdown
+        // Stored before breakdowns were kept
+        receipt.PointsBreakdown = nil
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+        awarded := createTestReceipt()
+        awarded.CalculatePointsWithRules(rules)
+        awarded.Points += 10
+        awarded.PointsBreakdown = nil
+        if err := AddReceipt(config.DB, awarded, testAudit); err != nil {
             t.Fatalf("Failed to add receipt: %v", err)
         }
 
-        fetchedReceipt, err := GetReceiptByID(config.DB, receipt.ID)
+        backfill, err := BackfillPoints(config.DB, rules, false)
         if err != nil {
-            t.Errorf("Failed to get receipt by ID: %v", err)
+            t.Fatalf("Failed to backfill points: %v", err)
+        }
+        if backfill != (PointsBackfill{Filled: 1, Mismatched: 1}) {
+            t.Errorf("Expected 1 filled and 1 mismatched receipt, got %+v", backfill)
+        }
+        stored, err := GetReceiptByID(config.DB, receipt.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if !reflect.DeepEqual(stored.PointsBreakdown, expected) {
+            t.Errorf("Expected breakdown %v, got %v", expected, stored.PointsBreakdown)
         }
 
-		// Log the fetched receipt
-        fetchedJSON, _ := json.MarshalIndent(fetchedReceipt, "", "  ")
-        t.Logf("Fetched receipt:\n%s", string(fetchedJSON))
-
-        if !reflect.DeepEqual(receipt, fetchedReceipt) {
-            t.Errorf("Fetched receipt does not match original")
+        // Only the receipt awarded other points is left to recalculate
+        backfill, err = BackfillPoints(config.DB, rules, true)
+        if err != nil {
+            t.Fatalf("Failed to backfill points: %v", err)
+        }
+        if backfill != (PointsBackfill{Recalculated: 1}) {
+            t.Errorf("Expected 1 recalculated receipt, got %+v", backfill)
+        }
+        stored, err = GetReceiptByID(config.DB, awarded.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt: %v", err)
+        }
+        if stored.Points != awarded.Points-10 || len(stored.PointsBreakdown) == 0 {
+            t.Errorf("Expected %d recalculated points with a breakdown, got %d and %v",
+                awarded.Points-10, stored.Points, stored.PointsBreakdown)
+        }
 
-			// Log both receipts for comparison
-            originalJSON, _ := json.MarshalIndent(receipt, "", "  ")
-            t.Logf("Original receipt:\n%s", string(originalJSON))
-            t.Logf("Fetched receipt:\n%s", string(fetchedJSON))
+        // The recalculation made a new version, keeping the one it replaced
+        if stored.Version != awarded.Version+1 {
+            t.Errorf("Expected version %d, got %d", awarded.Version+1, stored.Version)
+        }
+        var snapshotPoints int
+        err = config.DB.QueryRow(context.Background(),
+            "SELECT (snapshot->>'points')::int FROM receipt_versions WHERE receipt_id = $1 AND version = $2",
+            awarded.ID, awarded.Version).Scan(&snapshotPoints)
+        if err != nil || snapshotPoints != int(awarded.Points) {
+            t.Errorf("Expected a snapshot with %d points, got %d (err=%v)", awarded.Points, snapshotPoints, err)
+        }
+        if filled, err := GetReceiptByID(config.DB, receipt.ID); err != nil || filled.Version != receipt.Version {
+            t.Errorf("Expected a filled breakdown to keep version %d, got %+v (err=%v)", receipt.Version, filled, err)
         }
     })
 
-    t.Run("TestGetAllReceipts", func(t *testing.T) {
-        // Clear existing receipts
-        _, err := config.DB.Exec(context.Background(), "DELETE FROM items; DELETE FROM receipts;")
-        if err != nil {
-            t.Fatalf("Failed to clear existing receipts: %v", err)
+    t.Run("TestSoftDeleteAndPurge", func(t *testing.T) {
+        receipt := createTestReceipt()
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
         }
 
-        // Add multiple receipts
-        for i := 0; i < 3; i++ {
-            receipt := createTestReceipt()
-            err := AddReceipt(config.DB, receipt)
-            if err != nil {
-                t.Fatalf("Failed to add receipt: %v", err)
-            }
+        if err := DeleteReceipt(config.DB, receipt.ID, testAudit); err != nil {
+            t.Fatalf("Failed to delete receipt: %v", err)
+        }
+        if _, err := GetReceiptByID(config.DB, receipt.ID); !errors.Is(err, pgx.ErrNoRows) {
+            t.Errorf("Expected a deleted receipt to be hidden, got %v", err)
         }
 
-        receipts, err := GetAllReceipts(config.DB)
+        if err := RestoreReceipt(config.DB, receipt.ID, time.Hour, testAudit); err != nil {
+            t.Fatalf("Failed to restore receipt: %v", err)
+        }
+        if _, err := GetReceiptByID(config.DB, receipt.ID); err != nil {
+            t.Errorf("Expected a restored receipt to be visible, got %v", err)
+        }
+        if err := RestoreReceipt(config.DB, receipt.ID, time.Hour, testAudit); !errors.Is(err, ErrReceiptNotDeleted) {
+            t.Errorf("Expected ErrReceiptNotDeleted, got %v", err)
+        }
+
+        // With no restore window the deleted receipt is purged straight away
+        if err := DeleteReceipt(config.DB, receipt.ID, testAudit); err != nil {
+            t.Fatalf("Failed to delete receipt: %v", err)
+        }
+        rules := config.DefaultRetentionRules()
+        rules.RestoreWindow = 0
+        purged, err := PurgeReceipts(config.DB, rules)
         if err != nil {
-            t.Errorf("Failed to get all receipts: %v", err)
+            t.Fatalf("Failed to purge receipts: %v", err)
+        }
+        if purged != 1 {
+            t.Errorf("Expected 1 purged receipt, got %d", purged)
+        }
+        if err := RestoreReceipt(config.DB, receipt.ID, time.Hour, testAudit); !errors.Is(err, pgx.ErrNoRows) {
+            t.Errorf("Expected a purged receipt to be gone, got %v", err)
         }
 
-		// Log all fetched receipts
-        for i, r := range receipts {
-            receiptJSON, _ := json.MarshalIndent(r, "", "  ")
-            t.Logf("Receipt %d:\n%s", i+1, string(receiptJSON))
+        // The audit log outlives the purged receipt
+        history, err := GetReceiptHistory(config.DB, receipt.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt history: %v", err)
         }
+        var actions []string
+        for _, entry := range history {
+            actions = append(actions, entry.Action)
+        }
+        expected := []string{AuditActionCreate, AuditActionDelete, AuditActionRestore, AuditActionDelete, AuditActionPurge}
+        if !reflect.DeepEqual(actions, expected) {
+            t.Errorf("Expected history %v, got %v", expected, actions)
+        }
+        if history[0].Actor != testAudit.Actor || history[0].RequestID != testAudit.RequestID {
+            t.Errorf("Expected the creation to be audited as %+v, got %+v", testAudit, history[0])
+        }
+    })
 
-        if len(receipts) != 3 {
-            t.Errorf("Expected 3 receipts, got %d", len(receipts))
+    t.Run("TestAuditAppendOnly", func(t *testing.T) {
+        receipt := createTestReceipt()
+        if err := AddReceipt(config.DB, receipt, testAudit); err != nil {
+            t.Fatalf("Failed to add receipt: %v", err)
+        }
+
+        ctx := context.Background()
+        if _, err := config.DB.Exec(ctx, `UPDATE receipt_audit SET actor = 'someone else' WHERE receipt_id = $1`, receipt.ID); err == nil {
+            t.Errorf("Expected updating the audit log to fail")
+        }
+        if _, err := config.DB.Exec(ctx, `DELETE FROM receipt_audit WHERE receipt_id = $1`, receipt.ID); err == nil {
+            t.Errorf("Expected deleting from the audit log to fail")
+        }
+
+        history, err := GetReceiptHistory(config.DB, receipt.ID)
+        if err != nil {
+            t.Fatalf("Failed to get receipt history: %v", err)
+        }
+        if len(history) != 1 || history[0].Actor != testAudit.Actor {
+            t.Errorf("Expected the creation entry to be unchanged, got %+v", history)
         }
     })
 }
 
+/*
+	Benchmarks:
+	GetAllReceipts against a seeded dataset, compared with the
+	previous one-items-query-per-receipt strategy.
+*/
+const benchmarkReceiptCount = 200
+
+func BenchmarkGetAllReceipts(b *testing.B) {
+	seedBenchmarkReceipts(b, benchmarkReceiptCount)
+
+	b.Run("BatchedItems", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			receipts, err := GetAllReceipts(config.DB)
+			if err != nil {
+				b.Fatalf("Failed to get all receipts: %v", err)
+			}
+			if len(receipts) != benchmarkReceiptCount {
+				b.Fatalf("Expected %d receipts, got %d", benchmarkReceiptCount, len(receipts))
+			}
+		}
+	})
+
+	b.Run("ItemsPerReceipt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			receipts, err := getAllReceiptsItemsPerReceipt(config.DB)
+			if err != nil {
+				b.Fatalf("Failed to get all receipts: %v", err)
+			}
+			if len(receipts) != benchmarkReceiptCount {
+				b.Fatalf("Expected %d receipts, got %d", benchmarkReceiptCount, len(receipts))
+			}
+		}
+	})
+}
+
+func seedBenchmarkReceipts(b *testing.B, count int) {
+	b.Helper()
+
+	if err := truncateTables(config.DB); err != nil {
+		b.Fatalf("Failed to truncate tables: %v", err)
+	}
+
+	for i := 0; i < count; i++ {
+		if err := AddReceipt(config.DB, createTestReceipt(), testAudit); err != nil {
+			b.Fatalf("Failed to seed receipt: %v", err)
+		}
+	}
+}
+
+// getAllReceiptsItemsPerReceipt reproduces the N+1 loading strategy that
+// GetAllReceipts used to have, so the benchmark has a baseline to compare to.
+func getAllReceiptsItemsPerReceipt(db *pgxpool.Pool) ([]Receipt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+        SELECT id, retailer,
+               TO_CHAR(purchase_date, 'YYYY-MM-DD') as purchase_date,
+               TO_CHAR(purchase_time, 'HH24:MI') as purchase_time,
+               total, points
+        FROM receipts
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receipts []Receipt
+	for rows.Next() {
+		var receipt Receipt
+		if err := rows.Scan(&receipt.ID, &receipt.Retailer, &receipt.PurchaseDate, &receipt.PurchaseTime, &receipt.Total, &receipt.Points); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	rows.Close()
+
+	for i := range receipts {
+		itemRows, err := db.Query(ctx, `
+            SELECT i.id, i.short_description, i.quantity, i.price_paid, s.unique_identifier, s.prefix, s.product_category, s.manufacturer, s.product_line, s.attributes
+            FROM items i
+            JOIN skus s ON s.prefix = i.sku_prefix AND s.unique_identifier = i.sku_id
+            WHERE i.receipt_id = $1
+        `, receipts[i].ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for itemRows.Next() {
+			var item Item
+			if err := itemRows.Scan(
+				&item.ID, &item.ShortDescription, &item.Quantity, &item.PricePaid,
+				&item.SKU.UniqueIdentifier, &item.SKU.Prefix, &item.SKU.ProductCategory, &item.SKU.Manufacturer, &item.SKU.ProductLine, &item.SKU.Attributes); err != nil {
+				itemRows.Close()
+				return nil, err
+			}
+			item.ReceiptID = receipts[i].ID
+			receipts[i].Items = append(receipts[i].Items, item)
+		}
+		itemRows.Close()
+	}
+
+	return receipts, nil
+}
+
+// testReceiptCount makes every receipt from createTestReceipt distinct, so
+// they do not trip the duplicate (fingerprint) check.
+var testReceiptCount int
+
+// testAudit is who the tests make their changes as.
+var testAudit = AuditInfo{Actor: "test", RequestID: "test-request"}
+
 func createTestReceipt() *Receipt {
 	receiptID := config.GenerateUUID()
+	testReceiptCount++
 
 	// Retrieve the current count of items
     itemsCount, err := GetItemsCount(config.DB)
@@ -297,7 +2526,7 @@ func createTestReceipt() *Receipt {
 
     return &Receipt{
         ID:           receiptID,
-        Retailer:     "Test Store",
+        Retailer:     fmt.Sprintf("Test Store %d", testReceiptCount),
         PurchaseDate: time.Now().Format("2006-01-02"),
         PurchaseTime: time.Now().Format("15:04"),
         Items: []Item{
@@ -370,7 +2599,7 @@ func setupTestDatabase() (*pgxpool.Pool, error) {
         return nil, fmt.Errorf("error connecting to the database: %v", err)
     }
 
-    // Optional: Set up the database schema
+    // Set up the database schema
     if err := setupTestSchema(pool); err != nil {
         return nil, fmt.Errorf("error setting up test schema: %v", err)
     }
@@ -378,38 +2607,18 @@ func setupTestDatabase() (*pgxpool.Pool, error) {
     return pool, nil
 }
 
+// setupTestSchema applies the goose migrations in db/migrations, so the
+// tests run against the same schema, triggers and backfills as production.
 func setupTestSchema(pool *pgxpool.Pool) error {
-    // Create necessary tables for testing
-    _, err := pool.Exec(context.Background(), `
-        CREATE TABLE IF NOT EXISTS receipts (
-            id UUID PRIMARY KEY,
-            retailer VARCHAR(255) NOT NULL,
-            purchase_date DATE NOT NULL,
-            purchase_time TIME NOT NULL,
-            total DECIMAL(10, 2) NOT NULL,
-            points INTEGER NOT NULL
-        );
-
-        CREATE TABLE IF NOT EXISTS skus (
-            unique_identifier VARCHAR(255) PRIMARY KEY,
-            prefix VARCHAR(50) NOT NULL,
-            product_category VARCHAR(50) NOT NULL,
-            manufacturer VARCHAR(50) NOT NULL,
-            product_line VARCHAR(50) NOT NULL,
-            attributes JSONB
-        );
-
-        CREATE TABLE IF NOT EXISTS items (
-            id SERIAL PRIMARY KEY,
-            short_description VARCHAR(255) NOT NULL,
-            quantity INTEGER NOT NULL,
-            price_paid DECIMAL(10, 2) NOT NULL,
-            receipt_id UUID REFERENCES receipts(id),
-            sku_id VARCHAR(255) REFERENCES skus(unique_identifier)
-        );
-    `)
+    db := stdlib.OpenDBFromPool(pool)
+    defer db.Close()
+
+    if err := goose.SetDialect("postgres"); err != nil {
+        return err
+    }
+    goose.SetLogger(goose.NopLogger())
 
-    return err
+    return goose.Up(db, "../db/migrations")
 }
 
 func tearDownTestDatabase(pool *pgxpool.Pool) {
@@ -254,5 +254,59 @@ var SKUParsingTestCases = []struct {
         Input:       "TGT-GROC",
         ExpectError: true,
     },
+    {
+        Name:  "Escaped separator",
+        Input: `WMT-BVRG-COKE-SODA-SIZE-2\-LITER-00042`,
+        Expected: SKU{
+            Prefix:           "WMT",
+            ProductCategory:  "BVRG",
+            Manufacturer:     "COKE",
+            ProductLine:      "SODA",
+            Attributes:       map[string]string{"SIZE": "2-LITER"},
+            UniqueIdentifier: "00042",
+        },
+        ExpectError: false,
+    },
+    {
+        Name:        "Attribute without a value",
+        Input:       "WMT-GROC-NESTLE-CHOC-WEIGHT-67890",
+        ExpectError: true,
+    },
+    {
+        Name:        "Repeated attribute",
+        Input:       "WMT-GROC-NESTLE-CHOC-WEIGHT-100G-WEIGHT-200G-67890",
+        ExpectError: true,
+    },
+    {
+        Name:        "Empty unique identifier",
+        Input:       "WMT-GROC-NESTLE-CHOC-",
+        ExpectError: true,
+    },
+    {
+        Name:        "Dangling escape",
+        Input:       `WMT-GROC-NESTLE-CHOC-67890\`,
+        ExpectError: true,
+    },
     // Add more SKU parsing test cases...
-}
\ No newline at end of file
+}
+// SearchTestReceipts are searched by the SearchTestCases, both in memory
+// and in the database.
+var SearchTestReceipts = []Receipt{
+    {Retailer: "Target", Items: []Item{{ShortDescription: "Doritos Nacho Cheese"}, {ShortDescription: "Emils Cheese Pizza"}}},
+    {Retailer: "Walgreens", Items: []Item{{ShortDescription: "Pepsi - 12-oz"}}},
+    {Retailer: "Doritos Outlet", Items: []Item{{ShortDescription: "Doritos Cool Ranch"}}},
+}
+
+var SearchTestCases = []struct {
+    Query    string
+    Expected []string
+}{
+    {Query: "doritos", Expected: []string{"Doritos Outlet", "Target"}},
+    {Query: `"nacho cheese"`, Expected: []string{"Target"}},
+    {Query: `"cheese nacho"`, Expected: []string{}},
+    {Query: `"cheese emils"`, Expected: []string{}},
+    {Query: "pep*", Expected: []string{"Walgreens"}},
+    {Query: "target pizza", Expected: []string{"Target"}},
+    {Query: "nacho pizza", Expected: []string{"Target"}},
+    {Query: "doritos pepsi", Expected: []string{}},
+}
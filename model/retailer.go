@@ -0,0 +1,506 @@
+// model/retailer.go
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrDuplicateRetailer is returned for a retailer named like another one.
+	ErrDuplicateRetailer = errors.New("a retailer with this name already exists")
+	// ErrRetailerInUse is returned when deleting a retailer that stores or
+	// receipts are still linked to.
+	ErrRetailerInUse = errors.New("the retailer still has stores or receipts linked to it")
+)
+
+// retailerCacheTTL bounds how long retailer changes made by another server
+// take to apply to new receipts; changes made through this one apply at once.
+const retailerCacheTTL = time.Minute
+
+// Retailer is a canonical retailer, e.g. Target, that receipts printed under
+// several names ("TARGET #1234", "SuperTarget") are linked to. Names and
+// aliases are compared the way retailerKey normalizes them: lowercased, with
+// only letters and digits kept. Aliases are patterns over that form, where *
+// matches any run of characters and ? a single one, e.g. target*.
+type Retailer struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// RetailerNormalizer links raw retailer names to canonical retailers. It is
+// read-only once built.
+type RetailerNormalizer struct {
+	byKey    map[string]*Retailer
+	patterns []retailerPattern
+}
+
+type retailerPattern struct {
+	pattern  string
+	literals int
+	retailer *Retailer
+}
+
+// NewRetailerNormalizer builds a normalizer from the canonical retailers.
+func NewRetailerNormalizer(retailers []Retailer) *RetailerNormalizer {
+	n := &RetailerNormalizer{byKey: map[string]*Retailer{}}
+
+	for i := range retailers {
+		retailer := retailers[i]
+		if key := retailerKey(retailer.Name); key != "" {
+			if _, taken := n.byKey[key]; !taken {
+				n.byKey[key] = &retailer
+			}
+		}
+		for _, alias := range normalizeRetailerAliases(retailer.Aliases) {
+			n.patterns = append(n.patterns, retailerPattern{
+				pattern:  alias,
+				literals: len(strings.NewReplacer("*", "", "?", "").Replace(alias)),
+				retailer: &retailer,
+			})
+		}
+	}
+	return n
+}
+
+// Normalize returns the canonical retailer of a raw retailer name. A
+// retailer's own name wins; otherwise the alias with the most literal
+// characters, then the retailer stored first.
+func (n *RetailerNormalizer) Normalize(raw string) (Retailer, bool) {
+	key := retailerKey(raw)
+	if key == "" {
+		return Retailer{}, false
+	}
+	if retailer, ok := n.byKey[key]; ok {
+		return *retailer, true
+	}
+
+	var best *retailerPattern
+	for i, candidate := range n.patterns {
+		if matched, _ := path.Match(candidate.pattern, key); !matched {
+			continue
+		}
+		if best == nil || candidate.literals > best.literals ||
+			candidate.literals == best.literals && candidate.retailer.ID < best.retailer.ID {
+			best = &n.patterns[i]
+		}
+	}
+
+	if best == nil {
+		return Retailer{}, false
+	}
+	return *best.retailer, true
+}
+
+// normalizeRetailerAliases puts alias patterns in the form retailer names
+// are compared in, keeping the * and ? wildcards, and drops empty, repeated
+// and wildcard-only ones, which would match every retailer.
+func normalizeRetailerAliases(aliases []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, alias := range aliases {
+		alias = strings.Map(func(c rune) rune {
+			if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '*' || c == '?' {
+				return unicode.ToLower(c)
+			}
+			return -1
+		}, alias)
+		if strings.Trim(alias, "*?") != "" && !seen[alias] {
+			seen[alias] = true
+			normalized = append(normalized, alias)
+		}
+	}
+	return normalized
+}
+
+var retailerCache struct {
+	sync.Mutex
+	normalizer *RetailerNormalizer
+	loadedAt   time.Time
+}
+
+// GetRetailerNormalizer returns the normalizer of the stored retailers,
+// loading them at most once per retailerCacheTTL.
+func GetRetailerNormalizer(db *pgxpool.Pool) (*RetailerNormalizer, error) {
+	retailerCache.Lock()
+	defer retailerCache.Unlock()
+
+	if retailerCache.normalizer != nil && time.Since(retailerCache.loadedAt) < retailerCacheTTL {
+		return retailerCache.normalizer, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	retailers, err := loadRetailers(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	retailerCache.normalizer, retailerCache.loadedAt = NewRetailerNormalizer(retailers), time.Now()
+	return retailerCache.normalizer, nil
+}
+
+// invalidateRetailers makes the next GetRetailerNormalizer reload the
+// retailers.
+func invalidateRetailers() {
+	retailerCache.Lock()
+	defer retailerCache.Unlock()
+	retailerCache.normalizer = nil
+}
+
+func loadRetailers(ctx context.Context, q querier) ([]Retailer, error) {
+	rows, err := q.Query(ctx, `SELECT id, name, aliases FROM retailers ORDER BY id`)
+	if err != nil {
+		config.Log.Error("Failed to load retailers", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	retailers := []Retailer{}
+	for rows.Next() {
+		var retailer Retailer
+		if err := rows.Scan(&retailer.ID, &retailer.Name, &retailer.Aliases); err != nil {
+			config.Log.Error("Failed to scan retailer", zap.Error(err))
+			return nil, err
+		}
+		retailers = append(retailers, retailer)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate retailers", zap.Error(err))
+		return nil, err
+	}
+
+	return retailers, nil
+}
+
+// NormalizeRetailer links the receipt to the canonical retailer its
+// retailer name matches, if any. Should the retailers fail to load, the
+// receipt is left unlinked and the failure logged rather than failing the
+// receipt.
+func NormalizeRetailer(db *pgxpool.Pool, receipt *Receipt) {
+	receipt.RetailerID, receipt.CanonicalRetailer = 0, ""
+
+	normalizer, err := GetRetailerNormalizer(db)
+	if err != nil {
+		config.Log.Error("Failed to normalize retailer", zap.String("id", receipt.ID.String()), zap.Error(err))
+		return
+	}
+
+	if retailer, ok := normalizer.Normalize(receipt.Retailer); ok {
+		receipt.RetailerID, receipt.CanonicalRetailer = retailer.ID, retailer.Name
+	}
+}
+
+// ListRetailers returns the canonical retailers by name.
+func ListRetailers(db *pgxpool.Pool) ([]Retailer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	retailers, err := loadRetailers(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(retailers, func(i, j int) bool {
+		return strings.ToLower(retailers[i].Name) < strings.ToLower(retailers[j].Name)
+	})
+	return retailers, nil
+}
+
+// GetRetailer returns a canonical retailer, or pgx.ErrNoRows if there is no
+// such retailer.
+func GetRetailer(db *pgxpool.Pool, id int) (*Retailer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	retailer := Retailer{ID: id}
+	err := db.QueryRow(ctx, `SELECT name, aliases FROM retailers WHERE id = $1`, id).
+		Scan(&retailer.Name, &retailer.Aliases)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to retrieve retailer", zap.Int("id", id), zap.Error(err))
+		}
+		return nil, err
+	}
+
+	return &retailer, nil
+}
+
+// CreateRetailer stores a new canonical retailer and sets its ID. Stored
+// receipts it matches are only linked to it by RelinkReceipts.
+func CreateRetailer(db *pgxpool.Pool, retailer *Retailer) error {
+	retailer.Aliases = normalizeRetailerAliases(retailer.Aliases)
+	return saveRetailer(db, retailer, `
+		INSERT INTO retailers (name, aliases) VALUES ($1, $2)
+		RETURNING id
+	`, retailer.Name, retailer.Aliases)
+}
+
+// UpdateRetailer replaces the name and aliases of a canonical retailer, or
+// returns pgx.ErrNoRows if there is no such retailer. Stored receipts are
+// only relinked by RelinkReceipts.
+func UpdateRetailer(db *pgxpool.Pool, retailer *Retailer) error {
+	retailer.Aliases = normalizeRetailerAliases(retailer.Aliases)
+	return saveRetailer(db, retailer, `
+		UPDATE retailers SET name = $2, aliases = $3, updated_at = now()
+		WHERE id = $1
+		RETURNING id
+	`, retailer.ID, retailer.Name, retailer.Aliases)
+}
+
+// saveRetailer runs statement, which stores the retailer and returns its
+// ID.
+func saveRetailer(db *pgxpool.Pool, retailer *Retailer, statement string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.QueryRow(ctx, statement, args...).Scan(&retailer.ID); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrDuplicateRetailer
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to save retailer", zap.String("name", retailer.Name), zap.Error(err))
+		}
+		return err
+	}
+
+	invalidateRetailers()
+	config.Log.Info("Retailer saved",
+		zap.Int("id", retailer.ID),
+		zap.String("name", retailer.Name))
+	return nil
+}
+
+// DeleteRetailer removes a canonical retailer. It returns pgx.ErrNoRows if
+// there is no such retailer, and ErrRetailerInUse while stores or receipts,
+// deleted ones included, are linked to it.
+func DeleteRetailer(db *pgxpool.Pool, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `DELETE FROM retailers WHERE id = $1`, id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrRetailerInUse
+		}
+		config.Log.Error("Failed to delete retailer", zap.Int("id", id), zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	invalidateRetailers()
+	config.Log.Info("Retailer deleted", zap.Int("id", id))
+	return nil
+}
+
+// relinkBatchSize is how many receipts RelinkReceipts reads per
+// transaction.
+const relinkBatchSize = 500
+
+// RetailerRelink counts what RelinkReceipts changed: receipts linked to
+// another canonical retailer (or to none), the ones among them whose points
+// were recalculated, and receipts with a store left linked to their
+// retailer because their name matches no retailer now.
+type RetailerRelink struct {
+	Relinked     int64 `json:"relinked"`
+	Recalculated int64 `json:"recalculated"`
+	Kept         int64 `json:"kept"`
+}
+
+// RelinkReceipts links every stored receipt, deleted ones included, to the
+// canonical retailer its retailer name matches now, after retailers were
+// added or changed. A relinked receipt's store moves to the new retailer,
+// its points are calculated again with rules when they count the canonical
+// name, and it gets a new version, audited as a relink. A receipt with a
+// store whose name matches no retailer keeps its retailer, since stores
+// belong to one.
+func RelinkReceipts(db *pgxpool.Pool, rules config.PointsRules) (RetailerRelink, error) {
+	startTime := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	retailers, err := loadRetailers(ctx, db)
+	cancel()
+	if err != nil {
+		return RetailerRelink{}, err
+	}
+	normalizer := NewRetailerNormalizer(retailers)
+
+	var relink RetailerRelink
+	after := uuid.Nil
+	for {
+		last, count, err := relinkReceiptsBatch(db, normalizer, rules, after, &relink)
+		if err != nil {
+			return relink, err
+		}
+		if count < relinkBatchSize {
+			break
+		}
+		after = last
+	}
+
+	config.Log.Info("RelinkReceipts executed",
+		zap.Int64("relinked", relink.Relinked),
+		zap.Int64("recalculated", relink.Recalculated),
+		zap.Int64("kept", relink.Kept),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return relink, nil
+}
+
+// relinkReceiptsBatch relinks the next receipts by ID after after,
+// returning the last ID it read and how many receipts it read.
+func relinkReceiptsBatch(db *pgxpool.Pool, normalizer *RetailerNormalizer, rules config.PointsRules, after uuid.UUID, relink *RetailerRelink) (uuid.UUID, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return after, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT `+receiptColumns+`
+		FROM receipts
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE
+	`, after, relinkBatchSize)
+	if err != nil {
+		config.Log.Error("Failed to select receipts to relink", zap.Error(err))
+		return after, 0, err
+	}
+	defer rows.Close()
+
+	var read int
+	var lastID uuid.UUID
+	var counts RetailerRelink
+	receipts := []Receipt{}
+	var receiptIDs []string
+	for rows.Next() {
+		var row receiptRow
+		if err := rows.Scan(row.targets()...); err != nil {
+			config.Log.Error("Failed to scan receipt to relink", zap.Error(err))
+			return after, 0, err
+		}
+		receipt := row.result()
+		read, lastID = read+1, receipt.ID
+
+		retailer, _ := normalizer.Normalize(receipt.Retailer)
+		switch {
+		case retailer.ID == receipt.RetailerID:
+			continue
+		case retailer.ID == 0 && receipt.Store != nil:
+			counts.Kept++
+			continue
+		}
+		receipts = append(receipts, receipt)
+		receiptIDs = append(receiptIDs, receipt.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate receipts to relink", zap.Error(err))
+		return after, 0, err
+	}
+	rows.Close()
+	if read == 0 {
+		return after, 0, nil
+	}
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, tx, receiptIDs)
+	if err != nil {
+		return after, 0, err
+	}
+
+	// Move the stores first, so the receipts can be linked to them
+	stores := &pgx.Batch{}
+	previous := make([]Receipt, len(receipts))
+	for i := range receipts {
+		receipt := &receipts[i]
+		receipt.Items = itemsByReceipt[receipt.ID]
+		previous[i] = *receipt
+		if receipt.Store != nil {
+			store := *receipt.Store
+			previous[i].Store = &store
+		}
+
+		retailer, _ := normalizer.Normalize(receipt.Retailer)
+		receipt.RetailerID, receipt.CanonicalRetailer = retailer.ID, retailer.Name
+		queueStoreUpsert(stores, receipt)
+	}
+	if err := tx.SendBatch(ctx, stores).Close(); err != nil {
+		config.Log.Error("Failed to move stores to their relinked retailer", zap.Error(err))
+		return after, 0, err
+	}
+
+	batch := &pgx.Batch{}
+	for i := range receipts {
+		receipt, before := &receipts[i], &previous[i]
+		if rules.RetailerName == config.PointsRetailerCanonical {
+			receipt.CalculatePointsWithRules(rules)
+		}
+		receipt.Version = before.Version + 1
+
+		snapshot, err := json.Marshal(before)
+		if err != nil {
+			config.Log.Error("Failed to encode receipt snapshot", zap.Error(err))
+			return after, 0, err
+		}
+		var storeID *int
+		if receipt.Store != nil {
+			storeID = &receipt.Store.ID
+		}
+
+		counts.Relinked++
+		batch.Queue(`
+			INSERT INTO receipt_versions (receipt_id, version, snapshot)
+			VALUES ($1, $2, $3)
+		`, before.ID, before.Version, snapshot)
+		batch.Queue(`
+			UPDATE receipts SET retailer_id = NULLIF($2, 0), store_id = $3, points = $4, points_breakdown = $5,
+				version = version + 1, updated_at = now()
+			WHERE id = $1
+		`, receipt.ID, receipt.RetailerID, storeID, receipt.Points, pointsBreakdown(receipt))
+		queueAuditEntry(batch, receipt.ID, AuditActionRelink, AuditInfo{}, receiptDiff(before, receipt))
+		if before.Points != receipt.Points {
+			counts.Recalculated++
+			queueAuditEntry(batch, receipt.ID, AuditActionPointsRecalculated, AuditInfo{}, map[string]AuditChange{
+				"points": {From: before.Points, To: receipt.Points},
+			})
+		}
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		config.Log.Error("Failed to relink receipts", zap.Error(err))
+		return after, 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return after, 0, err
+	}
+
+	relink.Relinked += counts.Relinked
+	relink.Recalculated += counts.Recalculated
+	relink.Kept += counts.Kept
+	return lastID, read, nil
+}
@@ -0,0 +1,220 @@
+// model/retention.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrReceiptNotDeleted is returned when restoring a receipt that is not deleted.
+	ErrReceiptNotDeleted = errors.New("receipt is not deleted")
+
+	// ErrRestoreWindowExpired is returned when restoring a receipt deleted
+	// longer ago than the restore window; it is waiting to be purged.
+	ErrRestoreWindowExpired = errors.New("receipt can no longer be restored")
+)
+
+// DeleteReceipt soft-deletes a receipt: it disappears from every read but
+// can be restored until the purge job removes it. It returns pgx.ErrNoRows
+// if there is no live receipt with that ID.
+func DeleteReceipt(db *pgxpool.Pool, id uuid.UUID, audit AuditInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var deletedAt time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE receipts SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING deleted_at
+	`, id).Scan(&deletedAt)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to delete receipt", zap.String("id", id.String()), zap.Error(err))
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, insertAuditEntrySQL, auditEntryArgs(id, AuditActionDelete, audit, map[string]AuditChange{
+		"deletedAt": {From: nil, To: deletedAt},
+	})...)
+	if err != nil {
+		config.Log.Error("Failed to record audit entry", zap.String("id", id.String()), zap.Error(err))
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return err
+	}
+
+	config.Log.Info("Receipt deleted", zap.String("id", id.String()))
+	return nil
+}
+
+// RestoreReceipt undoes DeleteReceipt if the receipt was deleted within
+// restoreWindow. It returns pgx.ErrNoRows for an unknown (or purged)
+// receipt, ErrReceiptNotDeleted, ErrRestoreWindowExpired, or a
+// *DuplicateReceiptError when the same receipt was submitted again since.
+func RestoreReceipt(db *pgxpool.Pool, id uuid.UUID, restoreWindow time.Duration, audit AuditInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var deletedAt *time.Time
+	var fingerprint *string
+	err = tx.QueryRow(ctx, `
+		SELECT deleted_at, fingerprint FROM receipts WHERE id = $1 FOR UPDATE
+	`, id).Scan(&deletedAt, &fingerprint)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to retrieve receipt", zap.String("id", id.String()), zap.Error(err))
+		}
+		return err
+	}
+
+	switch {
+	case deletedAt == nil:
+		return ErrReceiptNotDeleted
+	case time.Since(*deletedAt) > restoreWindow:
+		return ErrRestoreWindowExpired
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE receipts SET deleted_at = NULL WHERE id = $1`, id); err != nil {
+		config.Log.Error("Failed to restore receipt", zap.String("id", id.String()), zap.Error(err))
+		tx.Rollback(ctx)
+		if fingerprint != nil {
+			return asDuplicateFingerprintError(db, id, *fingerprint, err)
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, insertAuditEntrySQL, auditEntryArgs(id, AuditActionRestore, audit, map[string]AuditChange{
+		"deletedAt": {From: *deletedAt, To: nil},
+	})...)
+	if err != nil {
+		config.Log.Error("Failed to record audit entry", zap.String("id", id.String()), zap.Error(err))
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return err
+	}
+
+	config.Log.Info("Receipt restored", zap.String("id", id.String()))
+	return nil
+}
+
+// PurgeReceipts hard-deletes, items first, the receipts deleted longer ago
+// than the restore window and, when rules.MaxAge is set, every receipt
+// created longer ago than that. It works in transactions of
+// rules.PurgeBatchSize receipts and returns how many were purged. Each
+// purge is recorded in the audit log, which outlives the receipts.
+func PurgeReceipts(db *pgxpool.Pool, rules config.RetentionRules) (int64, error) {
+	startTime := time.Now()
+	deletedBefore := startTime.Add(-rules.RestoreWindow)
+
+	var createdBefore *time.Time
+	if rules.MaxAge > 0 {
+		cutoff := startTime.Add(-rules.MaxAge)
+		createdBefore = &cutoff
+	}
+
+	var purged int64
+	for {
+		count, err := purgeReceiptBatch(db, deletedBefore, createdBefore, rules.PurgeBatchSize)
+		purged += count
+		if err != nil {
+			return purged, err
+		}
+		if count < int64(rules.PurgeBatchSize) {
+			break
+		}
+	}
+
+	config.Log.Info("PurgeReceipts executed",
+		zap.Int64("receipts", purged),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return purged, nil
+}
+
+func purgeReceiptBatch(db *pgxpool.Pool, deletedBefore time.Time, createdBefore *time.Time, limit int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM receipts
+		WHERE deleted_at < $1 OR created_at < $2
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`, deletedBefore, createdBefore, limit)
+	if err != nil {
+		config.Log.Error("Failed to select receipts to purge", zap.Error(err))
+		return 0, err
+	}
+	receiptIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		config.Log.Error("Failed to scan receipts to purge", zap.Error(err))
+		return 0, err
+	}
+	if len(receiptIDs) == 0 {
+		return 0, nil
+	}
+
+	// items has no cascade on its receipt foreign key
+	if _, err := tx.Exec(ctx, `DELETE FROM items WHERE receipt_id = ANY($1)`, receiptIDs); err != nil {
+		config.Log.Error("Failed to purge items", zap.Error(err))
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM receipts WHERE id = ANY($1)`, receiptIDs)
+	if err != nil {
+		config.Log.Error("Failed to purge receipts", zap.Error(err))
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO receipt_audit (receipt_id, action, actor)
+		SELECT id, $2, $3 FROM UNNEST($1::uuid[]) AS id
+	`, receiptIDs, AuditActionPurge, AuditActorSystem)
+	if err != nil {
+		config.Log.Error("Failed to record audit entries", zap.Error(err))
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
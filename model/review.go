@@ -0,0 +1,194 @@
+// model/review.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Receipt statuses. Receipts flagged by fraud scoring start out pending;
+// every other receipt is approved on submission. Points only count for
+// approved receipts.
+const (
+	ReceiptStatusPending  = "pending"
+	ReceiptStatusApproved = "approved"
+	ReceiptStatusRejected = "rejected"
+)
+
+// ErrReceiptNotPending is returned when reviewing a receipt that has
+// already been approved or rejected.
+var ErrReceiptNotPending = errors.New("receipt is not pending review")
+
+// ReviewDecision is one entry of a receipt's review audit trail.
+type ReviewDecision struct {
+	ID        int64     `json:"id"`
+	ReceiptID uuid.UUID `json:"receiptID"`
+	Decision  string    `json:"decision"`
+	Reason    string    `json:"reason,omitempty"`
+	Reviewer  string    `json:"reviewer,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GetPendingReceipts returns up to limit receipts awaiting review, oldest
+// first, along with their items.
+func GetPendingReceipts(db *pgxpool.Pool, limit int) ([]Receipt, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT `+receiptColumns+`
+		FROM receipts
+		WHERE status = 'pending' AND deleted_at IS NULL
+		ORDER BY created_at, id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		config.Log.Error("Failed to retrieve pending receipts", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []Receipt{}
+	var receiptIDs []string
+	for rows.Next() {
+		var row receiptRow
+		if err := rows.Scan(row.targets()...); err != nil {
+			config.Log.Error("Failed to scan receipt", zap.Error(err))
+			return nil, err
+		}
+
+		receipt := row.result()
+		receipts = append(receipts, receipt)
+		receiptIDs = append(receiptIDs, receipt.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate receipts", zap.Error(err))
+		return nil, err
+	}
+	rows.Close()
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, db, receiptIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range receipts {
+		receipts[i].Items = itemsByReceipt[receipts[i].ID]
+	}
+
+	config.Log.Info("GetPendingReceipts executed",
+		zap.Int("receipts", len(receipts)),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return receipts, nil
+}
+
+// ReviewReceipt records a reviewer's decision (ReceiptStatusApproved or
+// ReceiptStatusRejected) on a pending receipt and moves it to that status,
+// in one transaction, audited as audit. It returns pgx.ErrNoRows for an
+// unknown receipt and ErrReceiptNotPending if the receipt was already decided.
+func ReviewReceipt(db *pgxpool.Pool, id uuid.UUID, decision, reason, reviewer string, audit AuditInfo) (*ReviewDecision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if decision != ReceiptStatusApproved && decision != ReceiptStatusRejected {
+		return nil, errors.New("decision must be approved or rejected")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the receipt so two reviewers cannot decide it concurrently
+	var status string
+	err = tx.QueryRow(ctx, `SELECT status FROM receipts WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&status)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to retrieve receipt", zap.String("id", id.String()), zap.Error(err))
+		}
+		return nil, err
+	}
+	if status != ReceiptStatusPending {
+		return nil, ErrReceiptNotPending
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE receipts SET status = $2 WHERE id = $1`, id, decision); err != nil {
+		config.Log.Error("Failed to update receipt status", zap.String("id", id.String()), zap.Error(err))
+		return nil, err
+	}
+
+	review := &ReviewDecision{ReceiptID: id, Decision: decision, Reason: reason, Reviewer: reviewer}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO receipt_reviews (receipt_id, decision, reason, reviewer)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, id, decision, reason, reviewer).Scan(&review.ID, &review.CreatedAt)
+	if err != nil {
+		config.Log.Error("Failed to record review decision", zap.String("id", id.String()), zap.Error(err))
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, insertAuditEntrySQL, auditEntryArgs(id, AuditActionReview, audit, map[string]AuditChange{
+		"status": {From: status, To: decision},
+	})...)
+	if err != nil {
+		config.Log.Error("Failed to record audit entry", zap.String("id", id.String()), zap.Error(err))
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+
+	config.Log.Info("Receipt reviewed",
+		zap.String("id", id.String()),
+		zap.String("decision", decision),
+		zap.String("reviewer", reviewer))
+
+	return review, nil
+}
+
+// GetReviewDecisions returns the review audit trail of a receipt, oldest first.
+func GetReviewDecisions(db *pgxpool.Pool, id uuid.UUID) ([]ReviewDecision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT id, receipt_id, decision, reason, reviewer, created_at
+		FROM receipt_reviews
+		WHERE receipt_id = $1
+		ORDER BY created_at, id
+	`, id)
+	if err != nil {
+		config.Log.Error("Failed to retrieve review decisions", zap.String("id", id.String()), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	decisions := []ReviewDecision{}
+	for rows.Next() {
+		var decision ReviewDecision
+		err := rows.Scan(&decision.ID, &decision.ReceiptID, &decision.Decision,
+			&decision.Reason, &decision.Reviewer, &decision.CreatedAt)
+		if err != nil {
+			config.Log.Error("Failed to scan review decision", zap.Error(err))
+			return nil, err
+		}
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, rows.Err()
+}
@@ -0,0 +1,280 @@
+// model/search.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// ErrEmptySearchQuery is returned for a query without any searchable word.
+var ErrEmptySearchQuery = errors.New("search query has no words to search for")
+
+// SearchTerm is one condition of a search: a run of words that must appear
+// next to each other (a single word unless it came from a quoted phrase),
+// the last of which may be a prefix.
+type SearchTerm struct {
+	Words  []string
+	Prefix bool
+}
+
+// SearchQuery is a parsed search; every term must match the retailer or
+// one item description of a receipt.
+type SearchQuery struct {
+	Terms []SearchTerm
+}
+
+// SearchResult is a matching receipt and how well it matched.
+type SearchResult struct {
+	Receipt Receipt `json:"receipt"`
+	Rank    float64 `json:"rank"`
+}
+
+// ParseSearchQuery reads a user query: whitespace separated words that must
+// all match, "quoted phrases" whose words must appear in order, and a
+// trailing * for prefix matching (doritos* or "nacho chee*"). Words are
+// lowercased and split on anything that is not a letter or digit, the same
+// way the Postgres 'simple' text search configuration tokenizes.
+func ParseSearchQuery(input string) (SearchQuery, error) {
+	var query SearchQuery
+
+	for len(input) > 0 {
+		input = strings.TrimLeftFunc(input, unicode.IsSpace)
+		if input == "" {
+			break
+		}
+
+		var raw string
+		if input[0] == '"' {
+			end := strings.IndexByte(input[1:], '"')
+			if end < 0 {
+				raw, input = input[1:], ""
+			} else {
+				raw, input = input[1:end+1], input[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(input, unicode.IsSpace)
+			if end < 0 {
+				end = len(input)
+			}
+			raw, input = input[:end], input[end:]
+		}
+
+		raw = strings.TrimSpace(raw)
+		term := SearchTerm{
+			Words:  searchWords(raw),
+			Prefix: strings.HasSuffix(raw, "*"),
+		}
+		if len(term.Words) > 0 {
+			query.Terms = append(query.Terms, term)
+		}
+	}
+
+	if len(query.Terms) == 0 {
+		return query, ErrEmptySearchQuery
+	}
+	return query, nil
+}
+
+// TSQuery renders the query as to_tsquery('simple', ...) input. Words only
+// ever contain letters and digits, so they need no escaping.
+func (q SearchQuery) TSQuery() string {
+	return "(" + strings.Join(q.TermTSQueries(), ") & (") + ")"
+}
+
+// TermTSQueries renders each term as its own to_tsquery('simple', ...)
+// input, so that every term can be matched against a different document.
+func (q SearchQuery) TermTSQueries() []string {
+	terms := make([]string, len(q.Terms))
+	for i, term := range q.Terms {
+		words := append([]string{}, term.Words...)
+		if term.Prefix {
+			words[len(words)-1] += ":*"
+		}
+		terms[i] = strings.Join(words, " <-> ")
+	}
+	return terms
+}
+
+// Match is the in-memory equivalent of the database search: it reports
+// whether every term matches the retailer or one item description of the
+// receipt, and ranks the match by how many terms matched in how many places.
+// Different terms may match different documents, but a phrase must match
+// within one.
+func (q SearchQuery) Match(receipt Receipt) (bool, float64) {
+	documents := make([][]string, 0, len(receipt.Items)+1)
+	documents = append(documents, searchWords(receipt.Retailer))
+	for _, item := range receipt.Items {
+		documents = append(documents, searchWords(item.ShortDescription))
+	}
+
+	var rank float64
+	for _, term := range q.Terms {
+		matches := 0
+		for _, words := range documents {
+			if term.matches(words) {
+				matches++
+			}
+		}
+		if matches == 0 {
+			return false, 0
+		}
+		rank += float64(matches) / float64(len(documents))
+	}
+	return true, rank / float64(len(q.Terms))
+}
+
+func (t SearchTerm) matches(words []string) bool {
+	for start := 0; start+len(t.Words) <= len(words); start++ {
+		matched := true
+		for i, word := range t.Words {
+			candidate := words[start+i]
+			last := i == len(t.Words)-1
+			if candidate != word && !(last && t.Prefix && strings.HasPrefix(candidate, word)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// searchWords lowercases text and splits it into words of letters and digits.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// searchMatchesCTE ranks the receipts matching every term in $1, one
+// to_tsquery input per term. Each term is matched on its own against the
+// retailer and every item description, the same way SearchQuery.Match does,
+// and a receipt is ranked by the average of each term's best match.
+const searchMatchesCTE = `
+	WITH terms AS (
+		SELECT n, to_tsquery('simple', term) AS query
+		FROM unnest($1::text[]) WITH ORDINALITY AS t(term, n)
+	), matches AS (
+		SELECT r.id, terms.n, ts_rank(r.search_vector, terms.query) AS rank
+		FROM receipts r, terms
+		WHERE r.search_vector @@ terms.query
+		UNION ALL
+		SELECT i.receipt_id, terms.n, ts_rank(i.search_vector, terms.query)
+		FROM items i, terms
+		WHERE i.search_vector @@ terms.query
+	), term_ranks AS (
+		SELECT id, n, MAX(rank) AS rank FROM matches GROUP BY id, n
+	), ranked AS (
+		SELECT id, AVG(rank) AS rank
+		FROM term_ranks
+		GROUP BY id
+		HAVING COUNT(*) = (SELECT COUNT(*) FROM terms)
+	)`
+
+// SearchReceipts runs a full-text search over retailers and item
+// descriptions and returns one page of matching receipts, best match first,
+// along with the total number of matches.
+func SearchReceipts(db *pgxpool.Pool, query SearchQuery, limit, offset int) ([]SearchResult, int, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, searchMatchesCTE+`
+		SELECT `+receiptColumns+`, ranked.rank::float8, COUNT(*) OVER ()
+		FROM receipts
+		JOIN ranked USING (id)
+		WHERE deleted_at IS NULL
+		ORDER BY ranked.rank DESC, created_at DESC, id
+		LIMIT $2 OFFSET $3
+	`, query.TermTSQueries(), limit, offset)
+	if err != nil {
+		config.Log.Error("Failed to search receipts", zap.Error(err))
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	var receiptIDs []string
+	total := 0
+	for rows.Next() {
+		var row receiptRow
+		var result SearchResult
+		if err := rows.Scan(append(row.targets(), &result.Rank, &total)...); err != nil {
+			config.Log.Error("Failed to scan search result", zap.Error(err))
+			return nil, 0, err
+		}
+
+		result.Receipt = row.result()
+		results = append(results, result)
+		receiptIDs = append(receiptIDs, result.Receipt.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate search results", zap.Error(err))
+		return nil, 0, err
+	}
+	rows.Close()
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, db, receiptIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range results {
+		results[i].Receipt.Items = itemsByReceipt[results[i].Receipt.ID]
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(results) == 0 && offset > 0 {
+		if err := db.QueryRow(ctx, searchMatchesCTE+`
+			SELECT COUNT(*)
+			FROM receipts
+			JOIN ranked USING (id)
+			WHERE deleted_at IS NULL
+		`, query.TermTSQueries()).Scan(&total); err != nil {
+			config.Log.Error("Failed to count search results", zap.Error(err))
+			return nil, 0, err
+		}
+	}
+
+	config.Log.Info("SearchReceipts executed",
+		zap.Int("results", len(results)),
+		zap.Int("total", total),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return results, total, nil
+}
+
+// SearchReceiptsInMemory is SearchReceipts over receipts already in memory,
+// using SearchQuery.Match. Ties keep their input order.
+func SearchReceiptsInMemory(receipts []Receipt, query SearchQuery, limit, offset int) ([]SearchResult, int) {
+	results := []SearchResult{}
+	for _, receipt := range receipts {
+		if ok, rank := query.Match(receipt); ok {
+			results = append(results, SearchResult{Receipt: receipt, Rank: rank})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+
+	total := len(results)
+	if offset >= total {
+		return []SearchResult{}, total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return results[offset:end], total
+}
@@ -0,0 +1,211 @@
+// model/sku.go
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"go.uber.org/zap"
+)
+
+// ErrInvalidSKU is returned for a SKU string that does not follow the SKU
+// grammar.
+var ErrInvalidSKU = errors.New("invalid SKU format")
+
+/*
+SKU strings are dash-separated parts:
+
+	PREFIX-CATEGORY-MANUFACTURER-PRODUCTLINE[-KEY-VALUE]...-UNIQUEID
+
+e.g. "WMT-GROC-NESTLE-CHOC-WEIGHT-100G-67890". Attributes are written as
+key/value pairs sorted by key, so a SKU always serializes the same way. A
+part containing a dash or a backslash escapes it with a backslash:
+"WMT-BVRG-COKE-SODA-SIZE-2\-LITER-00042" has the SIZE "2-LITER".
+*/
+const (
+	skuSeparator = '-'
+	skuEscape    = '\\'
+)
+
+// skuFixedParts is the number of parts besides the attributes: prefix,
+// category, manufacturer, product line and unique identifier.
+const skuFixedParts = 5
+
+// ParseSKU reads a SKU string into s. It returns an ErrInvalidSKU error when
+// the string has too few parts, an unpaired attribute, a repeated or empty
+// attribute key, an empty unique identifier or a dangling escape.
+func (s *SKU) ParseSKU(skuString string) error {
+	if err := s.parseSKU(skuString); err != nil {
+		config.Log.Error("Invalid SKU", zap.String("sku", skuString), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// parseSKU is ParseSKU without logging, for callers that fall back to other
+// formats.
+func (s *SKU) parseSKU(skuString string) error {
+	skuParts, err := splitSKU(skuString)
+	if err == nil {
+		err = checkSKUParts(skuParts)
+	}
+	if err != nil {
+		return err
+	}
+
+	*s = SKU{}
+	s.Prefix = skuParts[0]
+	s.ProductCategory = skuParts[1]
+	s.Manufacturer = skuParts[2]
+	s.ProductLine = skuParts[3]
+
+	s.Attributes = make(map[string]string)
+	for i := 4; i < len(skuParts)-1; i += 2 {
+		s.Attributes[skuParts[i]] = skuParts[i+1]
+	}
+
+	s.UniqueIdentifier = skuParts[len(skuParts)-1]
+
+	return nil
+}
+
+// CombinePartsToString writes s in the SKU grammar. The result is the same
+// on every call, and ParseSKU reads it back to an equal SKU as long as s has
+// a unique identifier and no empty attribute name. A SKU in a retailer
+// format is written as it was submitted, and the zero SKU as "".
+func (s SKU) CombinePartsToString() string {
+	if s.Raw != "" || s.IsZero() {
+		return s.Raw
+	}
+
+	skuParts := []string{s.Prefix, s.ProductCategory, s.Manufacturer, s.ProductLine}
+
+	keys := make([]string, 0, len(s.Attributes))
+	for key := range s.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		skuParts = append(skuParts, key, s.Attributes[key])
+	}
+
+	skuParts = append(skuParts, s.UniqueIdentifier)
+
+	for i, part := range skuParts {
+		skuParts[i] = escapeSKUPart(part)
+	}
+	return strings.Join(skuParts, string(skuSeparator))
+}
+
+// IsZero reports whether s is the zero SKU of an item submitted without one.
+func (s SKU) IsZero() bool {
+	return s.UniqueIdentifier == "" && s.Raw == ""
+}
+
+// MarshalJSON writes the SKU as its string form, which UnmarshalJSON reads,
+// or null for the zero SKU.
+func (s SKU) MarshalJSON() ([]byte, error) {
+	if s.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(s.CombinePartsToString())
+}
+
+// UnmarshalJSON reads a SKU string in any format recognizable without the
+// retailer; see ParseRetailerSKU and Receipt.ResolveSKUs. Null and the
+// empty string leave the zero SKU, as SKUs are optional.
+func (s *SKU) UnmarshalJSON(data []byte) error {
+	var skuString *string
+	if err := json.Unmarshal(data, &skuString); err != nil {
+		return err
+	}
+	if skuString == nil || *skuString == "" {
+		*s = SKU{}
+		return nil
+	}
+
+	sku, err := ParseRetailerSKU(*skuString, "")
+	if err != nil {
+		return err
+	}
+	*s = sku
+	return nil
+}
+
+// splitSKU splits a SKU string on its unescaped separators and unescapes
+// each part.
+func splitSKU(skuString string) ([]string, error) {
+	var skuParts []string
+	var part strings.Builder
+
+	escaped := false
+	for _, c := range skuString {
+		switch {
+		case escaped:
+			part.WriteRune(c)
+			escaped = false
+		case c == skuEscape:
+			escaped = true
+		case c == skuSeparator:
+			skuParts = append(skuParts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(c)
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("%w: dangling %q at the end", ErrInvalidSKU, skuEscape)
+	}
+
+	return append(skuParts, part.String()), nil
+}
+
+// checkSKUParts rejects split SKU parts that do not map onto exactly one
+// SKU, so that every valid string round-trips.
+func checkSKUParts(skuParts []string) error {
+	if len(skuParts) < skuFixedParts {
+		return fmt.Errorf("%w: expected at least %d parts, got %d", ErrInvalidSKU, skuFixedParts, len(skuParts))
+	}
+	if (len(skuParts)-skuFixedParts)%2 != 0 {
+		return fmt.Errorf("%w: attribute without a value", ErrInvalidSKU)
+	}
+	if skuParts[len(skuParts)-1] == "" {
+		return fmt.Errorf("%w: empty unique identifier", ErrInvalidSKU)
+	}
+
+	seen := make(map[string]bool)
+	for i := 4; i < len(skuParts)-1; i += 2 {
+		key := skuParts[i]
+		if key == "" {
+			return fmt.Errorf("%w: empty attribute name", ErrInvalidSKU)
+		}
+		if seen[key] {
+			return fmt.Errorf("%w: repeated attribute %q", ErrInvalidSKU, key)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// escapeSKUPart escapes the separator and the escape character in a part.
+func escapeSKUPart(part string) string {
+	if !strings.ContainsAny(part, string([]rune{skuSeparator, skuEscape})) {
+		return part
+	}
+
+	var escaped strings.Builder
+	for _, c := range part {
+		if c == skuSeparator || c == skuEscape {
+			escaped.WriteRune(skuEscape)
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
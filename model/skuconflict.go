@@ -0,0 +1,155 @@
+// model/skuconflict.go
+
+package model
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// How a SKU conflict was resolved, following config.Catalog.SKUConflictPolicy
+const (
+	SKUConflictRejected    = "rejected"
+	SKUConflictKeptStored  = "kept_stored"
+	SKUConflictOverwritten = "overwritten"
+	SKUConflictVersioned   = "versioned"
+)
+
+// SKUConflict is a receipt item defining a stored SKU differently: same
+// prefix and unique identifier, different category, manufacturer, product
+// line or attributes.
+type SKUConflict struct {
+	Prefix           string `json:"prefix"`
+	UniqueIdentifier string `json:"uniqueIdentifier"`
+	Stored           SKU    `json:"stored"`
+	Submitted        SKU    `json:"submitted"`
+	Resolution       string `json:"resolution"`
+	// Version is the catalog version of the SKU once resolved
+	Version int `json:"version"`
+}
+
+// SKUConflictError is returned when a receipt conflicts with the catalog
+// under the reject policy.
+type SKUConflictError struct {
+	Conflict SKUConflict
+}
+
+func (e *SKUConflictError) Error() string {
+	return "SKU " + e.Conflict.UniqueIdentifier + " of prefix " + strconv.Quote(e.Conflict.Prefix) + " conflicts with the stored definition " +
+		e.Conflict.Stored.CombinePartsToString()
+}
+
+// storedSKUDefinition is what a SKU conflict compares, as stored.
+const storedSKUDefinition = `(skus.product_category, skus.manufacturer, skus.product_line, skus.attributes)`
+
+// queueSKUUpsert queues the statements storing an item's SKU onto batch.
+// The insert locks a stored SKU with a different definition and returns
+// it, so the conflict is detected, and then resolved by the policy's
+// statement, without racing concurrent receipts. Conflicts are appended to
+// receipt.SKUConflicts; under the reject policy the batch fails with a
+// *SKUConflictError instead.
+func queueSKUUpsert(batch *pgx.Batch, receipt *Receipt, sku SKU) {
+	policy := config.Catalog.SKUConflictPolicy
+	args := []any{sku.UniqueIdentifier, sku.Prefix, sku.ProductCategory, sku.Manufacturer,
+		sku.ProductLine, sku.Attributes, sku.Format, sku.Raw}
+
+	batch.Queue(`
+		INSERT INTO skus (unique_identifier, prefix, product_category, manufacturer, product_line, attributes, format, raw)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
+		ON CONFLICT (prefix, unique_identifier) DO UPDATE SET unique_identifier = skus.unique_identifier
+		WHERE `+storedSKUDefinition+` IS DISTINCT FROM
+			(EXCLUDED.product_category, EXCLUDED.manufacturer, EXCLUDED.product_line, EXCLUDED.attributes)
+		RETURNING product_category, manufacturer, product_line, COALESCE(attributes, '{}'::jsonb),
+			COALESCE(format, ''), COALESCE(raw, ''), version
+	`, args...).QueryRow(func(row pgx.Row) error {
+		stored := SKU{Prefix: sku.Prefix, UniqueIdentifier: sku.UniqueIdentifier}
+		var version int
+		err := row.Scan(&stored.ProductCategory, &stored.Manufacturer, &stored.ProductLine,
+			&stored.Attributes, &stored.Format, &stored.Raw, &version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil // stored with the same definition
+		}
+		if err != nil {
+			return err
+		}
+		if sameSKUDefinition(stored, sku) {
+			return nil // newly inserted
+		}
+
+		conflict := SKUConflict{
+			Prefix:           sku.Prefix,
+			UniqueIdentifier: sku.UniqueIdentifier,
+			Stored:           stored,
+			Submitted:        sku,
+			Version:          version,
+		}
+		switch policy {
+		case config.SKUConflictReject:
+			conflict.Resolution = SKUConflictRejected
+		case config.SKUConflictLastWriteWins:
+			conflict.Resolution = SKUConflictOverwritten
+		case config.SKUConflictVersion:
+			conflict.Resolution = SKUConflictVersioned
+			conflict.Version++
+		default:
+			conflict.Resolution = SKUConflictKeptStored
+		}
+
+		config.Log.Warn("SKU conflicts with the stored definition",
+			zap.String("receiptID", receipt.ID.String()),
+			zap.String("prefix", sku.Prefix),
+			zap.String("sku", sku.UniqueIdentifier),
+			zap.String("stored", stored.CombinePartsToString()),
+			zap.String("submitted", sku.CombinePartsToString()),
+			zap.String("resolution", conflict.Resolution))
+
+		if conflict.Resolution == SKUConflictRejected {
+			return &SKUConflictError{Conflict: conflict}
+		}
+		receipt.SKUConflicts = append(receipt.SKUConflicts, conflict)
+		return nil
+	})
+
+	// Only SKUs locked above as conflicting still differ
+	switch policy {
+	case config.SKUConflictLastWriteWins:
+		batch.Queue(`
+			UPDATE skus SET product_category = $3, manufacturer = $4, product_line = $5,
+				attributes = $6, format = NULLIF($7, ''), raw = NULLIF($8, '')
+			WHERE prefix = $2 AND unique_identifier = $1
+				AND `+storedSKUDefinition+` IS DISTINCT FROM ($3, $4, $5, $6::jsonb)
+		`, args...)
+	case config.SKUConflictVersion:
+		batch.Queue(`
+			WITH superseded AS (
+				INSERT INTO sku_versions (unique_identifier, version, prefix, product_category, manufacturer,
+					product_line, attributes, format, raw)
+				SELECT unique_identifier, version, prefix, product_category, manufacturer,
+					product_line, attributes, format, raw
+				FROM skus
+				WHERE prefix = $2 AND unique_identifier = $1
+					AND `+storedSKUDefinition+` IS DISTINCT FROM ($3, $4, $5, $6::jsonb)
+				RETURNING prefix, unique_identifier
+			)
+			UPDATE skus SET product_category = $3, manufacturer = $4, product_line = $5,
+				attributes = $6, format = NULLIF($7, ''), raw = NULLIF($8, ''), version = skus.version + 1
+			FROM superseded
+			WHERE skus.prefix = superseded.prefix AND skus.unique_identifier = superseded.unique_identifier
+		`, args...)
+	}
+}
+
+// sameSKUDefinition reports whether two SKUs of the same prefix and unique
+// identifier agree on everything a conflict is about.
+func sameSKUDefinition(a, b SKU) bool {
+	return a.ProductCategory == b.ProductCategory &&
+		a.Manufacturer == b.Manufacturer &&
+		a.ProductLine == b.ProductLine &&
+		(len(a.Attributes) == 0 && len(b.Attributes) == 0 || reflect.DeepEqual(a.Attributes, b.Attributes))
+}
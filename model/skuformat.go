@@ -0,0 +1,220 @@
+// model/skuformat.go
+
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// SKUFormatRaw is the format of a SKU no known format could read; it is
+// stored as submitted, with the whole string as its unique identifier.
+const SKUFormatRaw = "raw"
+
+// maxSKULength bounds a submitted SKU string, which is also the longest
+// unique identifier the skus table can store.
+const maxSKULength = 255
+
+// maxSKUPrefixLength is the longest prefix the skus table can store.
+const maxSKUPrefixLength = 50
+
+// SKUFormat reads the SKUs of one retailer that are not in the SKU grammar.
+// A format is chosen either by the SKU string starting with Prefix and a
+// separator ("TGT-071-03-0612") or by the receipt's retailer being one of
+// Retailers ("071-03-0612" on a Target receipt).
+type SKUFormat struct {
+	Name string
+	// Prefix is the retailer's SKU prefix, set on every SKU read
+	Prefix string
+	// Retailers are retailer names, compared ignoring case and anything
+	// that is not a letter or digit; "walmart" matches "Walmart Supercenter"
+	Retailers []string
+	// Parse reads the retailer's code into the SKU's other fields, or
+	// reports that it is not in this format
+	Parse func(code string) (SKU, bool)
+}
+
+var (
+	walmartItemNumber = regexp.MustCompile(`^\d{9}$`)
+	targetDPCI        = regexp.MustCompile(`^(\d{3})-(\d{2})-(\d{4})$`)
+	targetTCIN        = regexp.MustCompile(`^\d{8}$`)
+	amazonASIN        = regexp.MustCompile(`^(B0[0-9A-Z]{8}|\d{9}[0-9X])$`)
+)
+
+// skuFormats are the known retailer formats, tried in order.
+var skuFormats = []SKUFormat{
+	{
+		// Walmart item numbers (WIN), e.g. 552315461
+		Name:      "walmart-item",
+		Prefix:    "WMT",
+		Retailers: []string{"walmart"},
+		Parse: func(code string) (SKU, bool) {
+			return SKU{UniqueIdentifier: code}, walmartItemNumber.MatchString(code)
+		},
+	},
+	{
+		// Target department-class-item numbers, e.g. 071-03-0612
+		Name:      "target-dpci",
+		Prefix:    "TGT",
+		Retailers: []string{"target"},
+		Parse: func(code string) (SKU, bool) {
+			parts := targetDPCI.FindStringSubmatch(code)
+			if parts == nil {
+				return SKU{}, false
+			}
+			return SKU{
+				ProductCategory:  parts[1],
+				ProductLine:      parts[2],
+				UniqueIdentifier: code,
+			}, true
+		},
+	},
+	{
+		// Target online item numbers (TCIN), e.g. 13288347
+		Name:      "target-tcin",
+		Prefix:    "TGT",
+		Retailers: []string{"target"},
+		Parse: func(code string) (SKU, bool) {
+			return SKU{UniqueIdentifier: code}, targetTCIN.MatchString(code)
+		},
+	},
+	{
+		// Amazon standard identification numbers, e.g. B07XJ8C8F5, which
+		// for books are their ISBN-10
+		Name:      "amazon-asin",
+		Prefix:    "AMZ",
+		Retailers: []string{"amazon"},
+		Parse: func(code string) (SKU, bool) {
+			code = strings.ToUpper(code)
+			return SKU{UniqueIdentifier: code}, amazonASIN.MatchString(code)
+		},
+	},
+}
+
+// RegisterSKUFormat adds a retailer SKU format, tried after the ones known
+// already. It is not safe to call once receipts are being processed.
+func RegisterSKUFormat(format SKUFormat) {
+	skuFormats = append(skuFormats, format)
+}
+
+// ParseRetailerSKU reads a SKU string submitted on a receipt from retailer,
+// which may be empty when unknown. The SKU grammar is tried first, then the
+// retailer formats keyed by the string's prefix, then those of the
+// retailer. A SKU none of them reads is kept as an opaque SKUFormatRaw SKU
+// rather than failing the receipt, under the prefix of the retailer's format
+// or, for a retailer without one, a prefix made from its name; only an empty
+// or overlong string is an ErrInvalidSKU error.
+func ParseRetailerSKU(skuString, retailer string) (SKU, error) {
+	if skuString == "" {
+		return SKU{}, fmt.Errorf("%w: empty SKU", ErrInvalidSKU)
+	}
+	if len(skuString) > maxSKULength {
+		return SKU{}, fmt.Errorf("%w: longer than %d characters", ErrInvalidSKU, maxSKULength)
+	}
+
+	var sku SKU
+	if err := sku.parseSKU(skuString); err == nil {
+		return sku, nil
+	}
+
+	if prefix, code, ok := strings.Cut(skuString, string(skuSeparator)); ok {
+		for _, format := range skuFormats {
+			if strings.EqualFold(format.Prefix, prefix) {
+				if sku, ok := format.parse(code, skuString); ok {
+					return sku, nil
+				}
+			}
+		}
+	}
+
+	prefix := ""
+	key := retailerKey(retailer)
+	if key != "" {
+		for _, format := range skuFormats {
+			if format.usedBy(key) {
+				if sku, ok := format.parse(skuString, skuString); ok {
+					return sku, nil
+				}
+				if prefix == "" {
+					prefix = format.Prefix
+				}
+			}
+		}
+	}
+
+	// The prefix keeps the raw SKUs of different retailers apart
+	if prefix == "" {
+		prefix = rawSKUPrefix(key)
+	}
+	return SKU{
+		Prefix:           prefix,
+		Attributes:       map[string]string{},
+		UniqueIdentifier: skuString,
+		Format:           SKUFormatRaw,
+		Raw:              skuString,
+	}, nil
+}
+
+// ResolveSKUs reads again, now knowing the retailer, the item SKUs that
+// were kept raw when the receipt was decoded.
+func (r *Receipt) ResolveSKUs() {
+	for i := range r.Items {
+		sku := &r.Items[i].SKU
+		if sku.Format != SKUFormatRaw {
+			continue
+		}
+		if resolved, err := ParseRetailerSKU(sku.Raw, r.Retailer); err == nil {
+			*sku = resolved
+		}
+	}
+}
+
+// parse runs the format's Parse function on code and fills in what every
+// SKU of the format shares.
+func (f SKUFormat) parse(code, raw string) (SKU, bool) {
+	sku, ok := f.Parse(code)
+	if !ok {
+		return SKU{}, false
+	}
+
+	sku.Prefix = f.Prefix
+	if sku.Attributes == nil {
+		sku.Attributes = map[string]string{}
+	}
+	sku.Format = f.Name
+	sku.Raw = raw
+	return sku, true
+}
+
+func (f SKUFormat) usedBy(retailer string) bool {
+	for _, name := range f.Retailers {
+		if strings.HasPrefix(retailer, retailerKey(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rawSKUPrefix is the prefix of the raw SKUs of a retailer no format is
+// registered for: its retailerKey uppercased, cut to the longest prefix the
+// skus table stores.
+func rawSKUPrefix(key string) string {
+	prefix := []rune(strings.ToUpper(key))
+	if len(prefix) > maxSKUPrefixLength {
+		prefix = prefix[:maxSKUPrefixLength]
+	}
+	return string(prefix)
+}
+
+// retailerKey lowercases a retailer name and drops everything but letters
+// and digits, so "Wal-Mart" and "WALMART #1234" compare alike.
+func retailerKey(retailer string) string {
+	return strings.Map(func(c rune) rune {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			return unicode.ToLower(c)
+		}
+		return -1
+	}, retailer)
+}
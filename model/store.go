@@ -0,0 +1,297 @@
+// model/store.go
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrInvalidStore is returned for a store location without a store
+	// number or address, with a store number or address too long to store,
+	// or with coordinates out of range or only one of them.
+	ErrInvalidStore = errors.New("invalid store location")
+	// ErrStoreWithoutRetailer is returned for a store location on a receipt
+	// whose retailer is not a canonical retailer, which stores belong to.
+	ErrStoreWithoutRetailer = errors.New("a store location needs a known retailer; add the retailer first")
+)
+
+// earthRadiusKm is the mean radius of the Earth used for distances.
+const earthRadiusKm = 6371.0
+
+// Lengths, in characters, of the stores.store_number and stores.store_key
+// columns.
+const (
+	maxStoreNumberLength = 50
+	maxStoreKeyLength    = 512
+)
+
+// Store is a store location of a canonical retailer, e.g. Target #1234. A
+// receipt may carry one; the store is identified by its store number or,
+// without one, its address, so receipts from the same store share it.
+// Latitude and longitude are in degrees and set together.
+type Store struct {
+	ID          int      `json:"id,omitempty"`
+	RetailerID  int      `json:"retailerID,omitempty"`
+	StoreNumber string   `json:"storeNumber,omitempty"`
+	Address     string   `json:"address,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+// NearbyReceipt is a receipt from a store within a radius of a point.
+type NearbyReceipt struct {
+	Receipt    Receipt `json:"receipt"`
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+// NormalizeStore validates the receipt's store location, if any, and trims
+// its store number and address.
+func (r *Receipt) NormalizeStore() error {
+	if r.Store == nil {
+		return nil
+	}
+	store := r.Store
+	store.StoreNumber = strings.TrimSpace(store.StoreNumber)
+	store.Address = strings.Join(strings.Fields(store.Address), " ")
+
+	if store.StoreNumber == "" && store.Address == "" {
+		return fmt.Errorf("%w: a store number or address is required", ErrInvalidStore)
+	}
+	if utf8.RuneCountInString(store.StoreNumber) > maxStoreNumberLength {
+		return fmt.Errorf("%w: the store number is longer than %d characters", ErrInvalidStore, maxStoreNumberLength)
+	}
+	if utf8.RuneCountInString(storeKey(store)) > maxStoreKeyLength {
+		return fmt.Errorf("%w: the address is longer than %d characters", ErrInvalidStore, maxStoreKeyLength)
+	}
+	if (store.Latitude == nil) != (store.Longitude == nil) {
+		return fmt.Errorf("%w: latitude and longitude go together", ErrInvalidStore)
+	}
+	if store.Latitude != nil && !ValidCoordinates(*store.Latitude, *store.Longitude) {
+		return fmt.Errorf("%w: coordinates %v, %v are out of range", ErrInvalidStore, *store.Latitude, *store.Longitude)
+	}
+	return nil
+}
+
+// ValidCoordinates reports whether a latitude and longitude, in degrees,
+// are within range.
+func ValidCoordinates(latitude, longitude float64) bool {
+	return latitude >= -90 && latitude <= 90 && longitude >= -180 && longitude <= 180
+}
+
+// DistanceKm returns the great-circle distance between two points in
+// kilometers, by the haversine formula GetReceiptsNear uses too.
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat, dLon := toRadians(lat2-lat1), toRadians(lon2-lon1)
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// searchSpans returns how many degrees of latitude and of longitude on
+// either side of a point at latitude contain every point within radiusKm
+// of it. The longitude span is all 180 degrees when the radius reaches a
+// pole.
+func searchSpans(latitude, radiusKm float64) (latSpan, lonSpan float64) {
+	angle := radiusKm / earthRadiusKm
+	latSpan = angle * 180 / math.Pi
+	if math.Abs(latitude)+latSpan >= 90 {
+		return latSpan, 180
+	}
+	return latSpan, math.Asin(math.Sin(angle)/math.Cos(latitude*math.Pi/180)) * 180 / math.Pi
+}
+
+// storeKey identifies a store within its retailer: its store number,
+// compared the way retailer names are, or else its address, compared by
+// words.
+func storeKey(store *Store) string {
+	if store == nil {
+		return ""
+	}
+	if number := retailerKey(store.StoreNumber); number != "" {
+		return "#" + number
+	}
+	return "@" + strings.Join(searchWords(store.Address), " ")
+}
+
+// queueStoreUpsert queues the statement storing the receipt's store under
+// its retailer onto batch, filling in the details the stored one lacks, and
+// sets the store's ID. It must run before the receipt is stored, which
+// links the store by storeKey. A receipt without a store or a canonical
+// retailer queues nothing.
+func queueStoreUpsert(batch *pgx.Batch, receipt *Receipt) {
+	store := receipt.Store
+	if store == nil || receipt.RetailerID == 0 {
+		return
+	}
+	store.RetailerID = receipt.RetailerID
+
+	batch.Queue(`
+		INSERT INTO stores (retailer_id, store_key, store_number, address, latitude, longitude)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (retailer_id, store_key) DO UPDATE SET
+			store_number = COALESCE(NULLIF(EXCLUDED.store_number, ''), stores.store_number),
+			address = COALESCE(NULLIF(EXCLUDED.address, ''), stores.address),
+			latitude = CASE WHEN EXCLUDED.latitude IS NULL THEN stores.latitude ELSE EXCLUDED.latitude END,
+			longitude = CASE WHEN EXCLUDED.latitude IS NULL THEN stores.longitude ELSE EXCLUDED.longitude END,
+			updated_at = now()
+		RETURNING id, store_number, address, latitude, longitude
+	`, store.RetailerID, storeKey(store), store.StoreNumber, store.Address, store.Latitude, store.Longitude).
+		QueryRow(func(row pgx.Row) error {
+			return row.Scan(&store.ID, &store.StoreNumber, &store.Address, &store.Latitude, &store.Longitude)
+		})
+}
+
+// ListStores returns a page of the stores, of one retailer unless
+// retailerID is 0, by retailer and store number, with the total count.
+func ListStores(db *pgxpool.Pool, retailerID, limit, offset int) ([]Store, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT id, retailer_id, store_number, address, latitude, longitude, COUNT(*) OVER ()
+		FROM stores
+		WHERE $1 = 0 OR retailer_id = $1
+		ORDER BY retailer_id, store_number, address, id
+		LIMIT $2 OFFSET $3
+	`, retailerID, limit, offset)
+	if err != nil {
+		config.Log.Error("Failed to retrieve stores", zap.Int("retailerID", retailerID), zap.Error(err))
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	stores := []Store{}
+	total := 0
+	for rows.Next() {
+		var store Store
+		if err := rows.Scan(&store.ID, &store.RetailerID, &store.StoreNumber, &store.Address,
+			&store.Latitude, &store.Longitude, &total); err != nil {
+			config.Log.Error("Failed to scan store", zap.Error(err))
+			return nil, 0, err
+		}
+		stores = append(stores, store)
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate stores", zap.Error(err))
+		return nil, 0, err
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(stores) == 0 && offset > 0 {
+		if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM stores WHERE $1 = 0 OR retailer_id = $1`, retailerID).
+			Scan(&total); err != nil {
+			config.Log.Error("Failed to count stores", zap.Error(err))
+			return nil, 0, err
+		}
+	}
+
+	return stores, total, nil
+}
+
+// nearbyStores selects the stores within $3 kilometers of the point ($1,
+// $2) with their distance_km, given the Earth's radius as $4 and the spans
+// of latitudes and longitudes the radius covers as $5 and $6 (see
+// searchSpans). Stores outside those spans, measured across the
+// antimeridian, are skipped before computing distances.
+const nearbyStores = `
+	SELECT id AS store_id, distance_km
+	FROM stores
+	CROSS JOIN LATERAL (
+		SELECT 2 * $4::float8 * asin(least(1, sqrt(
+			power(sin(radians(latitude - $1) / 2), 2) +
+			cos(radians($1)) * cos(radians(latitude)) * power(sin(radians(longitude - $2) / 2), 2)
+		))) AS distance_km
+	) distance
+	WHERE latitude BETWEEN $1 - $5 AND $1 + $5
+		AND least(abs(longitude - $2), 360 - abs(longitude - $2)) <= $6
+		AND distance_km <= $3`
+
+// GetReceiptsNear returns a page of the live receipts from stores within
+// radiusKm kilometers of a point, nearest first, with the total count.
+func GetReceiptsNear(db *pgxpool.Pool, latitude, longitude, radiusKm float64, limit, offset int) ([]NearbyReceipt, int, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	latSpan, lonSpan := searchSpans(latitude, radiusKm)
+	args := []any{latitude, longitude, radiusKm, earthRadiusKm, latSpan, lonSpan}
+	rows, err := db.Query(ctx, `
+		WITH nearby AS (`+nearbyStores+`)
+		SELECT `+receiptColumns+`, nearby.distance_km, COUNT(*) OVER ()
+		FROM receipts
+		JOIN nearby USING (store_id)
+		WHERE deleted_at IS NULL
+		ORDER BY nearby.distance_km, created_at DESC, id
+		LIMIT $7 OFFSET $8
+	`, append(args, limit, offset)...)
+	if err != nil {
+		config.Log.Error("Failed to retrieve nearby receipts", zap.Error(err))
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []NearbyReceipt{}
+	var receiptIDs []string
+	total := 0
+	for rows.Next() {
+		var row receiptRow
+		var result NearbyReceipt
+		if err := rows.Scan(append(row.targets(), &result.DistanceKm, &total)...); err != nil {
+			config.Log.Error("Failed to scan nearby receipt", zap.Error(err))
+			return nil, 0, err
+		}
+
+		result.Receipt = row.result()
+		results = append(results, result)
+		receiptIDs = append(receiptIDs, result.Receipt.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		config.Log.Error("Failed to iterate nearby receipts", zap.Error(err))
+		return nil, 0, err
+	}
+	rows.Close()
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, db, receiptIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range results {
+		results[i].Receipt.Items = itemsByReceipt[results[i].Receipt.ID]
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(results) == 0 && offset > 0 {
+		if err := db.QueryRow(ctx, `
+			WITH nearby AS (`+nearbyStores+`)
+			SELECT COUNT(*)
+			FROM receipts
+			JOIN nearby USING (store_id)
+			WHERE deleted_at IS NULL
+		`, args...).Scan(&total); err != nil {
+			config.Log.Error("Failed to count nearby receipts", zap.Error(err))
+			return nil, 0, err
+		}
+	}
+
+	config.Log.Info("GetReceiptsNear executed",
+		zap.Float64("radiusKm", radiusKm),
+		zap.Int("results", len(results)),
+		zap.Int("total", total),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return results, total, nil
+}
@@ -0,0 +1,148 @@
+// model/update.go
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"rcpt-proc-challenge-ans/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// VersionConflictError is returned when a receipt update was based on a
+// version that is no longer the stored one.
+type VersionConflictError struct {
+	CurrentVersion int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("receipt has been modified, current version is %d", e.CurrentVersion)
+}
+
+// UpdateReceipt replaces a stored receipt with a prepared (validated,
+// cleaned and scored) one carrying the same ID, provided the stored
+// version is still expectedVersion. The previous version is kept as a
+// snapshot in receipt_versions, and the items and SKUs are replaced in the
+// same transaction. A pending or rejected receipt keeps its status, as only
+// a reviewer decides it; an approved one goes back to review if the update
+// gets it flagged. The change, and a
+// points recalculation if the points moved, are audited as audit.
+//
+// It returns pgx.ErrNoRows for an unknown receipt, *VersionConflictError
+// on a version mismatch and *DuplicateReceiptError if the new content
+// matches another stored receipt. On success receipt.Version and
+// receipt.Status hold the stored values.
+func UpdateReceipt(db *pgxpool.Pool, receipt *Receipt, expectedVersion int, audit AuditInfo) error {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		config.Log.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the stored receipt until the update commits
+	var row receiptRow
+	err = tx.QueryRow(ctx, `
+		SELECT `+receiptColumns+`
+		FROM receipts
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, receipt.ID).Scan(row.targets()...)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			config.Log.Error("Failed to retrieve receipt", zap.String("id", receipt.ID.String()), zap.Error(err))
+		}
+		return err
+	}
+	previous := row.result()
+
+	if previous.Version != expectedVersion {
+		return &VersionConflictError{CurrentVersion: previous.Version}
+	}
+
+	itemsByReceipt, err := getItemsForReceipts(ctx, tx, []string{previous.ID.String()})
+	if err != nil {
+		return err
+	}
+	previous.Items = itemsByReceipt[previous.ID]
+
+	snapshot, err := json.Marshal(previous)
+	if err != nil {
+		config.Log.Error("Failed to encode receipt snapshot", zap.Error(err))
+		return err
+	}
+
+	fraud := FraudAssessment{Reasons: []string{}}
+	if receipt.Fraud != nil {
+		fraud = *receipt.Fraud
+	}
+	if receipt.Status == "" {
+		receipt.Status = ReceiptStatusApproved
+	}
+	if previous.Status == ReceiptStatusPending || previous.Status == ReceiptStatusRejected {
+		receipt.Status = previous.Status
+	}
+	receipt.Version = previous.Version + 1
+
+	batch := &pgx.Batch{}
+	batch.Queue(`
+		INSERT INTO receipt_versions (receipt_id, version, snapshot)
+		VALUES ($1, $2, $3)
+	`, previous.ID, previous.Version, snapshot)
+	queueStoreUpsert(batch, receipt)
+	batch.Queue(`
+		UPDATE receipts SET retailer = $2, purchase_date = $3::date, purchase_time = $4::time,
+			total = $5, points = $6, fingerprint = $7, member_id = NULLIF($8, ''),
+			status = CASE WHEN status IN ('pending', 'rejected') THEN status ELSE $9 END,
+			fraud_score = $10, fraud_reasons = $11, flagged = $12, retailer_id = NULLIF($13, 0),
+			store_id = (SELECT id FROM stores WHERE retailer_id = $13 AND store_key = $14), points_breakdown = $15,
+			version = version + 1, updated_at = now()
+		WHERE id = $1
+		RETURNING status, version
+	`, receipt.ID, receipt.Retailer, receipt.PurchaseDate, receipt.PurchaseTime, receipt.Total, receipt.Points,
+		receipt.Fingerprint(), receipt.MemberID, receipt.Status, fraud.Score, fraud.Reasons, fraud.Flagged, receipt.RetailerID,
+		storeKey(receipt.Store), pointsBreakdown(receipt)).
+		QueryRow(func(row pgx.Row) error {
+			return row.Scan(&receipt.Status, &receipt.Version)
+		})
+	batch.Queue(`DELETE FROM items WHERE receipt_id = $1`, receipt.ID)
+	if err := queueItemInserts(batch, receipt); err != nil {
+		return err
+	}
+
+	queueAuditEntry(batch, receipt.ID, AuditActionUpdate, audit, receiptDiff(&previous, receipt))
+	if previous.Points != receipt.Points {
+		queueAuditEntry(batch, receipt.ID, AuditActionPointsRecalculated, audit, map[string]AuditChange{
+			"points": {From: previous.Points, To: receipt.Points},
+		})
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		config.Log.Error("Failed to update receipt", zap.String("id", receipt.ID.String()), zap.Error(err))
+		tx.Rollback(ctx)
+		return asDuplicateReceiptError(db, receipt, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		config.Log.Error("Failed to commit transaction", zap.Error(err))
+		return err
+	}
+
+	config.Log.Info("UpdateReceipt executed",
+		zap.String("id", receipt.ID.String()),
+		zap.Int("version", receipt.Version),
+		zap.Duration("duration", time.Since(startTime)))
+
+	return nil
+}